@@ -0,0 +1,257 @@
+package main
+
+import (
+	"cmp"
+	"context"
+	"encoding/json"
+	"log/slog"
+	"slices"
+	"sync"
+	"time"
+
+	"git.sr.ht/~runxiyu/cca/db"
+)
+
+// AdminDashboard summarizes selection activity for the live dashboard. It
+// follows the events the hub delivers to students, including those relayed
+// from other instances, so it costs no queries of its own after startup.
+// Connected students and failed selections are those of this instance.
+type AdminDashboard struct {
+	queries *db.Queries
+	hub     *WebSocketHub
+
+	mu          sync.Mutex
+	minutes     [dashboardMinutes]dashboardMinute
+	courses     map[string]*dashboardCourse
+	failures    []dashboardFailure
+	subscribers map[chan []byte]struct{}
+}
+
+const (
+	// dashboardMinutes is how many minutes of selection activity are kept.
+	dashboardMinutes = 15
+	// dashboardCourses is how many of the fullest courses are shown.
+	dashboardCourses = 20
+	// dashboardFailures is how many recent failed selections are shown.
+	dashboardFailures  = 20
+	dashboardFrequency = time.Second
+)
+
+type dashboardMinute struct {
+	Minute time.Time `json:"minute"`
+	// Changes counts the students whose selections changed.
+	Changes int `json:"changes"`
+}
+
+type dashboardCourse struct {
+	ID              string `json:"id"`
+	Name            string `json:"name"`
+	Period          string `json:"period"`
+	CurrentStudents int64  `json:"current_students"`
+	MaxStudents     int64  `json:"max_students"`
+}
+
+type dashboardFailure struct {
+	Time      time.Time `json:"time"`
+	StudentID int64     `json:"student_id"`
+	CourseID  string    `json:"course_id"`
+	Error     string    `json:"error"`
+}
+
+type dashboardGrade struct {
+	Grade    string `json:"grade"`
+	Students int    `json:"students"`
+}
+
+type dashboardSnapshot struct {
+	Time             time.Time          `json:"ts"`
+	Minutes          []dashboardMinute  `json:"minutes"`
+	Connected        int                `json:"connected"`
+	ConnectedByGrade []dashboardGrade   `json:"connected_by_grade"`
+	Courses          []dashboardCourse  `json:"courses"`
+	Failures         []dashboardFailure `json:"failures"`
+}
+
+func NewAdminDashboard(queries *db.Queries, hub *WebSocketHub) *AdminDashboard {
+	return &AdminDashboard{
+		queries:     queries,
+		hub:         hub,
+		courses:     make(map[string]*dashboardCourse),
+		subscribers: make(map[chan []byte]struct{}),
+	}
+}
+
+// observe is called by the hub with every event it delivers.
+func (d *AdminDashboard) observe(message WSMessage, studentIDs []int64) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	switch p := message.Payload.(type) {
+	case WSSelections:
+		minute := message.Time.Truncate(time.Minute)
+		slot := &d.minutes[minute.Unix()/60%dashboardMinutes]
+		if !slot.Minute.Equal(minute) {
+			*slot = dashboardMinute{Minute: minute}
+		}
+		slot.Changes += len(studentIDs)
+	case WSCourseCounts:
+		for _, count := range p.Counts {
+			if course, ok := d.courses[count.CourseID]; ok {
+				course.CurrentStudents = count.CurrentStudents
+			}
+		}
+	case WSCourses:
+		d.setCourses(p.Courses)
+	}
+}
+
+// setCourses must be called with mu held.
+func (d *AdminDashboard) setCourses(rows []db.GetCoursesRow) {
+	clear(d.courses)
+	for _, row := range rows {
+		d.courses[row.ID] = &dashboardCourse{
+			ID:              row.ID,
+			Name:            row.Name,
+			Period:          row.Period,
+			CurrentStudents: row.CurrentStudents,
+			MaxStudents:     row.MaxStudents,
+		}
+	}
+}
+
+// RecordFailure records a selection the database refused, such as one for a
+// full course.
+func (d *AdminDashboard) RecordFailure(studentID int64, courseID string, err error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.failures = append(d.failures, dashboardFailure{
+		Time:      time.Now(),
+		StudentID: studentID,
+		CourseID:  courseID,
+		Error:     err.Error(),
+	})
+	if len(d.failures) > dashboardFailures {
+		d.failures = slices.Delete(d.failures, 0, len(d.failures)-dashboardFailures)
+	}
+}
+
+// Subscribe returns a channel that receives the dashboard as JSON every
+// dashboardFrequency, and a function to stop. Slow subscribers skip
+// snapshots rather than receive stale ones.
+func (d *AdminDashboard) Subscribe() (<-chan []byte, func()) {
+	ch := make(chan []byte, 1)
+	d.mu.Lock()
+	d.subscribers[ch] = struct{}{}
+	d.mu.Unlock()
+	return ch, func() {
+		d.mu.Lock()
+		delete(d.subscribers, ch)
+		d.mu.Unlock()
+	}
+}
+
+func (d *AdminDashboard) Run(ctx context.Context) {
+	courses, err := d.queries.GetCourses(ctx)
+	if err != nil {
+		// Courses appear with the next courses event instead.
+		slog.Error(logMsgAdminDashboardError, slog.Any("error", err))
+	} else {
+		d.mu.Lock()
+		d.setCourses(courses)
+		d.mu.Unlock()
+	}
+
+	ticker := time.NewTicker(dashboardFrequency)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		d.mu.Lock()
+		if len(d.subscribers) == 0 {
+			d.mu.Unlock()
+			continue
+		}
+		data, err := json.Marshal(d.snapshot())
+		if err != nil {
+			d.mu.Unlock()
+			slog.Error(logMsgAdminDashboardError, slog.Any("error", err))
+			continue
+		}
+		for ch := range d.subscribers {
+			select {
+			case <-ch:
+			default:
+			}
+			ch <- data
+		}
+		d.mu.Unlock()
+	}
+}
+
+// Courses returns the enrollment of those of the given courses that the
+// dashboard knows of, for pages that follow only a few courses.
+func (d *AdminDashboard) Courses(ids []string) []dashboardCourse {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	courses := make([]dashboardCourse, 0, len(ids))
+	for _, id := range ids {
+		if course, ok := d.courses[id]; ok {
+			courses = append(courses, *course)
+		}
+	}
+	return courses
+}
+
+// snapshot must be called with mu held.
+func (d *AdminDashboard) snapshot() dashboardSnapshot {
+	now := time.Now()
+	snapshot := dashboardSnapshot{
+		Time:     now,
+		Minutes:  make([]dashboardMinute, 0, dashboardMinutes),
+		Failures: slices.Clone(d.failures),
+	}
+
+	current := now.Truncate(time.Minute)
+	for i := dashboardMinutes - 1; i >= 0; i-- {
+		minute := current.Add(-time.Duration(i) * time.Minute)
+		entry := dashboardMinute{Minute: minute}
+		if slot := d.minutes[minute.Unix()/60%dashboardMinutes]; slot.Minute.Equal(minute) {
+			entry.Changes = slot.Changes
+		}
+		snapshot.Minutes = append(snapshot.Minutes, entry)
+	}
+
+	for grade, n := range d.hub.ConnectedByGrade() {
+		snapshot.Connected += n
+		snapshot.ConnectedByGrade = append(snapshot.ConnectedByGrade, dashboardGrade{Grade: grade, Students: n})
+	}
+	slices.SortFunc(snapshot.ConnectedByGrade, func(a, b dashboardGrade) int {
+		return cmp.Compare(a.Grade, b.Grade)
+	})
+
+	for _, course := range d.courses {
+		snapshot.Courses = append(snapshot.Courses, *course)
+	}
+	slices.SortFunc(snapshot.Courses, func(a, b dashboardCourse) int {
+		return cmp.Or(
+			cmp.Compare(fillRatio(b), fillRatio(a)),
+			cmp.Compare(a.ID, b.ID),
+		)
+	})
+	if len(snapshot.Courses) > dashboardCourses {
+		snapshot.Courses = snapshot.Courses[:dashboardCourses]
+	}
+	return snapshot
+}
+
+func fillRatio(c dashboardCourse) float64 {
+	if c.MaxStudents == 0 {
+		return 1
+	}
+	return float64(c.CurrentStudents) / float64(c.MaxStudents)
+}
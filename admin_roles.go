@@ -0,0 +1,89 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"net/http"
+	"slices"
+
+	"git.sr.ht/~runxiyu/cca/db"
+)
+
+// adminPermission is something an administrator's role may allow. adminOnly
+// checks the permission a route needs; handlers that do several things
+// check the others themselves.
+type adminPermission int
+
+const (
+	// adminPermView allows viewing every admin page.
+	adminPermView adminPermission = iota
+	// adminPermSelections allows adding, changing and removing selections.
+	adminPermSelections
+	// adminPermNotify allows sending and scheduling notifications.
+	adminPermNotify
+	// adminPermSetup allows managing categories, periods, grades, courses
+	// and students.
+	adminPermSetup
+	// adminPermAccounts allows managing administrators and sessions.
+	adminPermAccounts
+)
+
+var adminRolePermissions = map[db.AdminRole][]adminPermission{
+	db.AdminRoleSuperuser:   {adminPermView, adminPermSelections, adminPermNotify, adminPermSetup, adminPermAccounts},
+	db.AdminRoleCoordinator: {adminPermView, adminPermSelections, adminPermNotify},
+	db.AdminRoleAuditor:     {adminPermView},
+}
+
+var adminRoles = []db.AdminRole{db.AdminRoleSuperuser, db.AdminRoleCoordinator, db.AdminRoleAuditor}
+
+// can reports whether the administrator's role, and the API token the
+// request was made with if any, allow permission.
+func (u *UserInfoAdmin) can(permission adminPermission) bool {
+	if u.APIToken != nil && !slices.Contains(u.APIToken.Permissions, permission) {
+		return false
+	}
+	return slices.Contains(adminRolePermissions[u.Role], permission)
+}
+
+// canGrade reports whether the administrator may act on students in grade.
+func (u *UserInfoAdmin) canGrade(grade string) bool {
+	return u.AllGrades || slices.Contains(u.Grades, grade)
+}
+
+var errAdminOutOfScope = errors.New("your account may only act on students in grades you are responsible for")
+
+// checkStudentScope returns errAdminOutOfScope if any of the students is in
+// a grade the administrator is not responsible for. Unknown students are
+// left for the caller to reject.
+func (u *UserInfoAdmin) checkStudentScope(ctx context.Context, q *db.Queries, studentIDs []int64) error {
+	if u.AllGrades {
+		return nil
+	}
+	outside, err := q.GetStudentIDsOutsideGrades(ctx, db.GetStudentIDsOutsideGradesParams{
+		StudentIds: studentIDs,
+		Grades:     nonNil(u.Grades),
+	})
+	if err != nil {
+		return err
+	}
+	if len(outside) > 0 {
+		return errAdminOutOfScope
+	}
+	return nil
+}
+
+// requireStudentScope responds and returns false unless the administrator
+// may act on all of the students.
+func (app *App) requireStudentScope(w http.ResponseWriter, r *http.Request, aui *UserInfoAdmin, studentIDs []int64) bool {
+	err := aui.checkStudentScope(r.Context(), app.queries, studentIDs)
+	switch {
+	case errors.Is(err, errAdminOutOfScope):
+		app.respondHTTPError(r, w, http.StatusForbidden, "Forbidden\n"+err.Error(), nil, slog.String("admin_username", aui.Username), slog.Any("student_ids", studentIDs))
+		return false
+	case err != nil:
+		app.respondHTTPError(r, w, http.StatusInternalServerError, "Internal Server Error\n"+err.Error(), err, slog.String("admin_username", aui.Username))
+		return false
+	}
+	return true
+}
@@ -0,0 +1,46 @@
+document.addEventListener("DOMContentLoaded", () => {
+	const status = document.getElementById("dashboard-status");
+	const minutes = document.getElementById("dashboard-minutes");
+	const grades = document.getElementById("dashboard-grades");
+	const connected = document.getElementById("dashboard-connected");
+	const courses = document.getElementById("dashboard-courses");
+	const failures = document.getElementById("dashboard-failures");
+
+	const row = cells => {
+		const tr = document.createElement("tr");
+		cells.forEach(text => {
+			const td = document.createElement("td");
+			td.textContent = text;
+			tr.appendChild(td);
+		});
+		return tr;
+	};
+
+	const time = value => new Date(value).toLocaleTimeString();
+
+	const render = data => {
+		minutes.replaceChildren(...data.minutes.slice().reverse().map(m => row([time(m.minute), m.changes])));
+		grades.replaceChildren(...(data.connected_by_grade || []).map(g => row([g.grade, g.students])));
+		connected.textContent = data.connected;
+		courses.replaceChildren(...(data.courses || []).map(c => {
+			const tr = row([`${c.id} — ${c.name}`, c.period, `${c.current_students} / ${c.max_students}`]);
+			if (c.current_students >= c.max_students) {
+				tr.classList.add("is-full");
+			}
+			return tr;
+		}));
+		failures.replaceChildren(...(data.failures || []).slice().reverse().map(f => row([time(f.time), f.student_id, f.course_id, f.error])));
+		status.textContent = `Updated ${time(data.ts)}`;
+	};
+
+	const connect = () => {
+		const scheme = window.location.protocol === "https:" ? "wss" : "ws";
+		const ws = new WebSocket(`${scheme}://${window.location.host}/admin/api/dashboard/events`);
+		ws.addEventListener("message", event => render(JSON.parse(event.data)));
+		ws.addEventListener("close", () => {
+			status.textContent = "Disconnected, reconnecting...";
+			setTimeout(connect, 3000);
+		});
+	};
+	connect();
+});
@@ -0,0 +1,24 @@
+document.addEventListener("DOMContentLoaded", () => {
+	const status = document.getElementById("teacher-status");
+
+	const render = data => {
+		data.courses.forEach(c => {
+			const count = document.querySelector(`[data-course-count="${CSS.escape(c.id)}"]`);
+			if (count) {
+				count.textContent = c.current_students;
+			}
+		});
+		status.textContent = `Updated ${new Date(data.ts).toLocaleTimeString()}`;
+	};
+
+	const connect = () => {
+		const scheme = window.location.protocol === "https:" ? "wss" : "ws";
+		const ws = new WebSocket(`${scheme}://${window.location.host}/teacher/api/events`);
+		ws.addEventListener("message", event => render(JSON.parse(event.data)));
+		ws.addEventListener("close", () => {
+			status.textContent = "Disconnected, reconnecting...";
+			setTimeout(connect, 3000);
+		});
+	};
+	connect();
+});
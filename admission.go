@@ -0,0 +1,239 @@
+package main
+
+import (
+	"container/list"
+	"context"
+	"errors"
+	"log/slog"
+	"net/http"
+	"sync"
+	"time"
+)
+
+var errAdmissionTimeout = errors.New("timed out waiting for admission")
+
+// admissionAnnounceInterval is how often waiting students are told their new
+// positions at most. Announcing on every release would send a message to
+// every waiting student, and through the cluster to every other instance,
+// each time a slot frees up.
+const admissionAnnounceInterval = time.Second
+
+// AdmissionQueue limits how many student mutations run concurrently. Requests
+// above the limit wait in FIFO order and are told their position in the queue
+// over the WebSocket hub until a slot frees up.
+type AdmissionQueue struct {
+	maxActive        int
+	maxWait          time.Duration
+	hub              *WebSocketHub
+	announceInterval time.Duration
+
+	mu      sync.Mutex
+	active  int
+	waiting *list.List
+	// announceScheduled is set while an announcement is waiting to run.
+	announceScheduled bool
+	lastAnnounce      time.Time
+
+	admittedTotal int64
+	queuedTotal   int64
+	timedOutTotal int64
+	waitTotal     time.Duration
+	waitMax       time.Duration
+}
+
+type admissionTicket struct {
+	studentID int64
+	ready     chan struct{}
+	enqueued  time.Time
+	// announced is the position the student was last told, guarded by
+	// the queue's mu.
+	announced int
+}
+
+type AdmissionStats struct {
+	MaxActive     int
+	Active        int
+	Waiting       int
+	AdmittedTotal int64
+	QueuedTotal   int64
+	TimedOutTotal int64
+	WaitAverage   time.Duration
+	WaitMax       time.Duration
+}
+
+func NewAdmissionQueue(maxActive int, maxWait time.Duration, hub *WebSocketHub) *AdmissionQueue {
+	return &AdmissionQueue{
+		maxActive:        maxActive,
+		maxWait:          maxWait,
+		hub:              hub,
+		announceInterval: admissionAnnounceInterval,
+		waiting:          list.New(),
+	}
+}
+
+// Acquire blocks until the student may proceed. The returned function must be
+// called exactly once to hand the slot to the next student in the queue.
+func (q *AdmissionQueue) Acquire(ctx context.Context, studentID int64) (func(), error) {
+	if q.maxActive <= 0 {
+		return func() {}, nil
+	}
+
+	q.mu.Lock()
+	if q.waiting.Len() == 0 && q.active < q.maxActive {
+		q.active++
+		q.admittedTotal++
+		q.mu.Unlock()
+		return q.release, nil
+	}
+
+	ticket := &admissionTicket{
+		studentID: studentID,
+		ready:     make(chan struct{}),
+		enqueued:  time.Now(),
+	}
+	elem := q.waiting.PushBack(ticket)
+	position := q.waiting.Len()
+	ticket.announced = position
+	q.queuedTotal++
+	q.mu.Unlock()
+
+	slog.Info(logMsgAdmissionQueued, slog.Int64("student_id", studentID), slog.Int("position", position))
+	q.hub.BroadcastToStudents([]int64{studentID}, newWSMessage(WSQueuePosition{Position: position}))
+
+	var timeout <-chan time.Time
+	if q.maxWait > 0 {
+		timer := time.NewTimer(q.maxWait)
+		defer timer.Stop()
+		timeout = timer.C
+	}
+
+	var err error
+	select {
+	case <-ticket.ready:
+		q.hub.BroadcastToStudents([]int64{studentID}, newWSMessage(WSQueueAdmitted{}))
+		slog.Info(logMsgAdmissionAdmitted, slog.Int64("student_id", studentID), slog.Duration("waited", time.Since(ticket.enqueued)))
+		return q.release, nil
+	case <-timeout:
+		err = errAdmissionTimeout
+	case <-ctx.Done():
+		err = ctx.Err()
+	}
+
+	q.mu.Lock()
+	select {
+	case <-ticket.ready:
+		// We were admitted while giving up; pass the slot on.
+		q.mu.Unlock()
+		q.release()
+	default:
+		q.waiting.Remove(elem)
+		q.timedOutTotal++
+		q.scheduleAnnounceLocked()
+		q.mu.Unlock()
+	}
+	slog.Warn(logMsgAdmissionAbandoned, slog.Int64("student_id", studentID), slog.Any("error", err))
+	return nil, err
+}
+
+func (q *AdmissionQueue) release() {
+	q.mu.Lock()
+	front := q.waiting.Front()
+	if front == nil {
+		q.active--
+		q.mu.Unlock()
+		return
+	}
+
+	ticket := q.waiting.Remove(front).(*admissionTicket)
+	waited := time.Since(ticket.enqueued)
+	q.admittedTotal++
+	q.waitTotal += waited
+	if waited > q.waitMax {
+		q.waitMax = waited
+	}
+	close(ticket.ready)
+	q.scheduleAnnounceLocked()
+	q.mu.Unlock()
+}
+
+type admissionPosition struct {
+	studentID int64
+	position  int
+}
+
+// scheduleAnnounceLocked arranges for announce to run once
+// announceInterval has passed since it last ran, unless it is already
+// scheduled, so that releases in quick succession are announced together.
+func (q *AdmissionQueue) scheduleAnnounceLocked() {
+	if q.announceScheduled || q.waiting.Len() == 0 {
+		return
+	}
+	q.announceScheduled = true
+	time.AfterFunc(max(0, q.announceInterval-time.Since(q.lastAnnounce)), q.announce)
+}
+
+// changedPositionsLocked returns the positions of the waiting students that
+// differ from what they were last told, and records them as told.
+func (q *AdmissionQueue) changedPositionsLocked() []admissionPosition {
+	var positions []admissionPosition
+	i := 1
+	for e := q.waiting.Front(); e != nil; e = e.Next() {
+		ticket := e.Value.(*admissionTicket)
+		if ticket.announced != i {
+			ticket.announced = i
+			positions = append(positions, admissionPosition{
+				studentID: ticket.studentID,
+				position:  i,
+			})
+		}
+		i++
+	}
+	return positions
+}
+
+// announce tells waiting students whose position changed their new
+// position.
+func (q *AdmissionQueue) announce() {
+	q.mu.Lock()
+	q.announceScheduled = false
+	q.lastAnnounce = time.Now()
+	positions := q.changedPositionsLocked()
+	q.mu.Unlock()
+
+	for _, p := range positions {
+		q.hub.BroadcastToStudents([]int64{p.studentID}, newWSMessage(WSQueuePosition{Position: p.position}))
+	}
+}
+
+func (q *AdmissionQueue) Stats() AdmissionStats {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	stats := AdmissionStats{
+		MaxActive:     q.maxActive,
+		Active:        q.active,
+		Waiting:       q.waiting.Len(),
+		AdmittedTotal: q.admittedTotal,
+		QueuedTotal:   q.queuedTotal,
+		TimedOutTotal: q.timedOutTotal,
+		WaitMax:       q.waitMax,
+	}
+	if admittedFromQueue := q.queuedTotal - q.timedOutTotal - int64(q.waiting.Len()); admittedFromQueue > 0 {
+		stats.WaitAverage = q.waitTotal / time.Duration(admittedFromQueue)
+	}
+	return stats
+}
+
+// admitStudent waits for an admission slot for a student mutation, writing an
+// API error and returning false if the student could not be admitted.
+func (app *App) admitStudent(w http.ResponseWriter, r *http.Request, sui *UserInfoStudent) (func(), bool) {
+	release, err := app.admission.Acquire(r.Context(), sui.ID)
+	if err != nil {
+		if errors.Is(err, errAdmissionTimeout) {
+			w.Header().Set("Retry-After", "5")
+			app.apiError(r, w, http.StatusServiceUnavailable, "The server is busy; please try again", slog.Int64("student_id", sui.ID))
+		}
+		return nil, false
+	}
+	return release, true
+}
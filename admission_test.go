@@ -0,0 +1,94 @@
+package main
+
+import (
+	"context"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+)
+
+// recordPositions runs hub and returns a function that reports the queue
+// positions it has delivered so far.
+func recordPositions(hub *WebSocketHub) func() []admissionPosition {
+	var mu sync.Mutex
+	var positions []admissionPosition
+	hub.observe = func(message WSMessage, studentIDs []int64) {
+		p, ok := message.Payload.(WSQueuePosition)
+		if !ok {
+			return
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		for _, id := range studentIDs {
+			positions = append(positions, admissionPosition{studentID: id, position: p.Position})
+		}
+	}
+	go hub.Run()
+	return func() []admissionPosition {
+		mu.Lock()
+		defer mu.Unlock()
+		return slices.Clone(positions)
+	}
+}
+
+func waitFor(t *testing.T, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatal("timed out")
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestAdmissionQueueAnnouncesChangedPositionsOnce(t *testing.T) {
+	hub := NewWebSocketHub(0, 0, 0)
+	positions := recordPositions(hub)
+	q := NewAdmissionQueue(1, 0, hub)
+	q.announceInterval = 200 * time.Millisecond
+	q.lastAnnounce = time.Now()
+
+	release, err := q.Acquire(context.Background(), 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	releases := make(chan func(), 3)
+	for _, id := range []int64{2, 3, 4} {
+		go func() {
+			release, err := q.Acquire(context.Background(), id)
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			releases <- release
+		}()
+		waitFor(t, func() bool { return q.Stats().Waiting == int(id-1) })
+	}
+	waitFor(t, func() bool { return len(positions()) == 3 })
+
+	// Two releases within one interval are announced together, and only
+	// to the student whose position changed since they were last told.
+	release()
+	(<-releases)()
+	<-releases
+	waitFor(t, func() bool { return len(positions()) > 3 })
+	time.Sleep(2 * q.announceInterval)
+
+	want := []admissionPosition{{2, 1}, {3, 2}, {4, 3}, {4, 1}}
+	if got := positions(); !slices.Equal(got, want) {
+		t.Fatalf("positions = %v, want %v", got, want)
+	}
+}
+
+func TestAdmissionQueueUnlimited(t *testing.T) {
+	q := NewAdmissionQueue(0, 0, nil)
+	for range 3 {
+		release, err := q.Acquire(context.Background(), 1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer release()
+	}
+}
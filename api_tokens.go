@@ -0,0 +1,104 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgtype"
+
+	"git.sr.ht/~runxiyu/cca/db"
+)
+
+const (
+	// apiTokenPrefix makes API tokens recognizable, e.g. to secret
+	// scanners, and lets authenticateAPIToken skip the database for
+	// anything else.
+	apiTokenPrefix = "cca_"
+	// apiTokenMaxDays is the longest an API token may be valid for; tokens
+	// cannot be renewed, only replaced.
+	apiTokenMaxDays     = 365
+	apiTokenDefaultDays = 90
+)
+
+// apiTokenScope is a permission that API tokens may be given. Scope names
+// are what the api_tokens table stores. adminPermAccounts is deliberately
+// not among them, so administrators and sessions can only be managed
+// interactively.
+type apiTokenScope struct {
+	Name        string
+	Permission  adminPermission
+	Description string
+}
+
+var apiTokenScopes = []apiTokenScope{
+	{"view", adminPermView, "view every admin page and export selections"},
+	{"selections", adminPermSelections, "add, change, remove and import selections"},
+	{"notify", adminPermNotify, "send and schedule notifications"},
+	{"setup", adminPermSetup, "manage and import categories, periods, grades, courses, students and teachers"},
+}
+
+// apiTokenInfo is set on UserInfoAdmin when the request was authenticated
+// with an API token rather than a session.
+type apiTokenInfo struct {
+	ID          int64
+	Permissions []adminPermission
+}
+
+func apiTokenPermissions(scopes []string) []adminPermission {
+	var permissions []adminPermission
+	for _, scope := range apiTokenScopes {
+		for _, name := range scopes {
+			if name == scope.Name {
+				permissions = append(permissions, scope.Permission)
+			}
+		}
+	}
+	return permissions
+}
+
+// bearerToken returns the token of an Authorization: Bearer header.
+func bearerToken(r *http.Request) (string, bool) {
+	scheme, token, ok := strings.Cut(r.Header.Get("Authorization"), " ")
+	if !ok || !strings.EqualFold(scheme, "Bearer") {
+		return "", false
+	}
+	return strings.TrimSpace(token), true
+}
+
+// authenticateAPIToken returns the administrator an API token belongs to,
+// or nil if it is unknown or has expired. Like sessions, the time the token
+// was last used is only updated once every sessionRenewInterval, and a
+// failed update is only logged.
+func (app *App) authenticateAPIToken(r *http.Request, token string) (*UserInfoAdmin, error) {
+	if !strings.HasPrefix(token, apiTokenPrefix) {
+		return nil, nil
+	}
+	u, err := app.queries.GetAdminByAPIToken(r.Context(), app.hashSessionToken(token))
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("fetch admin by API token: %w", err)
+	}
+	if !u.LastUsedAt.Valid || time.Since(u.LastUsedAt.Time) >= sessionRenewInterval {
+		if err := app.queries.TouchAPIToken(r.Context(), db.TouchAPITokenParams{
+			ID:         u.TokenID,
+			LastUsedIp: pgtype.Text{String: remoteHost(r), Valid: true},
+		}); err != nil {
+			app.logWarn(r, logMsgAuthAPITokenTouchError, slog.Int64("api_token_id", u.TokenID), slog.Any("error", err))
+		}
+	}
+	return &UserInfoAdmin{
+		Admin:  u.Admin,
+		Grades: u.Grades,
+		APIToken: &apiTokenInfo{
+			ID:          u.TokenID,
+			Permissions: apiTokenPermissions(u.Scopes),
+		},
+	}, nil
+}
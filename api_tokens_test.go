@@ -0,0 +1,173 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"slices"
+	"strings"
+	"testing"
+
+	"git.sr.ht/~runxiyu/cca/db"
+)
+
+func TestBearerToken(t *testing.T) {
+	tests := []struct {
+		header    string
+		want      string
+		wantFound bool
+	}{
+		{"Bearer cca_abc", "cca_abc", true},
+		{"bearer cca_abc", "cca_abc", true},
+		{"Bearer  cca_abc ", "cca_abc", true},
+		{"Bearer ", "", true},
+		{"Bearer", "", false},
+		{"Basic dXNlcjpwYXNz", "", false},
+		{"cca_abc", "", false},
+		{"", "", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.header, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/admin/", nil)
+			if tt.header != "" {
+				r.Header.Set("Authorization", tt.header)
+			}
+			got, found := bearerToken(r)
+			if got != tt.want || found != tt.wantFound {
+				t.Errorf("bearerToken(%q) = %q, %v, want %q, %v", tt.header, got, found, tt.want, tt.wantFound)
+			}
+		})
+	}
+}
+
+func TestAPITokenPermissions(t *testing.T) {
+	tests := []struct {
+		name   string
+		scopes []string
+		want   []adminPermission
+	}{
+		{"none", nil, nil},
+		{"view", []string{"view"}, []adminPermission{adminPermView}},
+		{"in scope order", []string{"setup", "view"}, []adminPermission{adminPermView, adminPermSetup}},
+		{"every scope", []string{"view", "selections", "notify", "setup"}, []adminPermission{adminPermView, adminPermSelections, adminPermNotify, adminPermSetup}},
+		{"unknown scopes are ignored", []string{"accounts", "VIEW", "notify"}, []adminPermission{adminPermNotify}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := apiTokenPermissions(tt.scopes); !slices.Equal(got, tt.want) {
+				t.Errorf("apiTokenPermissions(%v) = %v, want %v", tt.scopes, got, tt.want)
+			}
+		})
+	}
+
+	for _, scope := range apiTokenScopes {
+		if scope.Permission == adminPermAccounts {
+			t.Errorf("scope %s allows managing accounts", scope.Name)
+		}
+	}
+}
+
+// TestCanWithAPIToken checks that a token allows the intersection of its
+// scopes and the role of its administrator.
+func TestCanWithAPIToken(t *testing.T) {
+	tests := []struct {
+		name   string
+		role   db.AdminRole
+		scopes []string
+		want   []adminPermission
+	}{
+		{"superuser with view", db.AdminRoleSuperuser, []string{"view"}, []adminPermission{adminPermView}},
+		{"superuser with every scope", db.AdminRoleSuperuser, []string{"view", "selections", "notify", "setup"}, []adminPermission{adminPermView, adminPermSelections, adminPermNotify, adminPermSetup}},
+		{"coordinator with setup", db.AdminRoleCoordinator, []string{"selections", "setup"}, []adminPermission{adminPermSelections}},
+		{"auditor with setup", db.AdminRoleAuditor, []string{"view", "setup"}, []adminPermission{adminPermView}},
+		{"no scopes", db.AdminRoleSuperuser, nil, nil},
+	}
+	all := []adminPermission{adminPermView, adminPermSelections, adminPermNotify, adminPermSetup, adminPermAccounts}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			u := &UserInfoAdmin{
+				Admin:    db.Admin{Role: tt.role},
+				APIToken: &apiTokenInfo{Permissions: apiTokenPermissions(tt.scopes)},
+			}
+			for _, permission := range all {
+				if got, want := u.can(permission), slices.Contains(tt.want, permission); got != want {
+					t.Errorf("can(%d) = %v, want %v", permission, got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestParseAPITokenForm(t *testing.T) {
+	coordinator := &UserInfoAdmin{Admin: db.Admin{Role: db.AdminRoleCoordinator}}
+	tests := []struct {
+		name       string
+		form       url.Values
+		wantErr    error
+		wantName   string
+		wantScopes []string
+		wantDays   int
+	}{
+		{"valid", url.Values{"name": {" nightly import "}, "scopes": {"selections", "view"}, "days": {"30"}}, nil, "nightly import", []string{"view", "selections"}, 30},
+		{"longest", url.Values{"name": {"x"}, "scopes": {"view"}, "days": {"365"}}, nil, "x", []string{"view"}, 365},
+		{"no name", url.Values{"name": {"  "}, "scopes": {"view"}, "days": {"30"}}, errAPITokenNoName, "", nil, 0},
+		{"no scopes", url.Values{"name": {"x"}, "days": {"30"}}, errAPITokenNoScopes, "", nil, 0},
+		{"unknown scope", url.Values{"name": {"x"}, "scopes": {"accounts"}, "days": {"30"}}, errAPITokenNoScopes, "", nil, 0},
+		{"scope beyond role", url.Values{"name": {"x"}, "scopes": {"view", "setup"}, "days": {"30"}}, errAPITokenScope, "", nil, 0},
+		{"too long", url.Values{"name": {"x"}, "scopes": {"view"}, "days": {"366"}}, errAPITokenLifetime, "", nil, 0},
+		{"zero days", url.Values{"name": {"x"}, "scopes": {"view"}, "days": {"0"}}, errAPITokenLifetime, "", nil, 0},
+		{"days not a number", url.Values{"name": {"x"}, "scopes": {"view"}, "days": {"forever"}}, errAPITokenLifetime, "", nil, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodPost, "/admin/tokens/new", strings.NewReader(tt.form.Encode()))
+			r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+			got, err := parseAPITokenForm(r, coordinator)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("error = %v, want %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			if got.name != tt.wantName || !slices.Equal(got.scopes, tt.wantScopes) || got.days != tt.wantDays {
+				t.Errorf("parseAPITokenForm = %+v, want %q, %v, %d", got, tt.wantName, tt.wantScopes, tt.wantDays)
+			}
+		})
+	}
+}
+
+// TestAdminOnlyUnknownToken checks that scripts get a 401 rather than being
+// redirected to the login page, which they cannot follow.
+func TestAdminOnlyUnknownToken(t *testing.T) {
+	app := &App{}
+	h := app.adminOnly("handleTest", adminPermView, func(http.ResponseWriter, *http.Request, *UserInfoAdmin) {
+		t.Fatal("handler called")
+	})
+
+	tests := []struct {
+		name          string
+		authorization string
+		wantStatus    int
+	}{
+		{"token without the prefix", "Bearer nope", http.StatusUnauthorized},
+		{"empty token", "Bearer ", http.StatusUnauthorized},
+		{"browser", "", http.StatusSeeOther},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/admin/", nil)
+			if tt.authorization != "" {
+				r.Header.Set("Authorization", tt.authorization)
+			}
+			w := httptest.NewRecorder()
+			h(w, r)
+			if w.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d", w.Code, tt.wantStatus)
+			}
+			if tt.wantStatus == http.StatusUnauthorized && !strings.HasPrefix(w.Header().Get("WWW-Authenticate"), "Bearer") {
+				t.Errorf("WWW-Authenticate = %q", w.Header().Get("WWW-Authenticate"))
+			}
+		})
+	}
+}
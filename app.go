@@ -10,10 +10,21 @@ import (
 )
 
 type App struct {
-	config  Config
-	pool    *pgxpool.Pool
-	queries *db.Queries
-	kf      keyfunc.Keyfunc
-	admTmpl map[string]*template.Template
-	wsHub   *WebSocketHub
+	config       Config
+	pool         *pgxpool.Pool
+	queries      *db.Queries
+	oidc         oidcProvider
+	kf           keyfunc.Keyfunc
+	identity     *identityMapper
+	sessionKey   []byte
+	admTmpl      map[string]*template.Template
+	tchTmpl      map[string]*template.Template
+	loginTmpl    map[string]*template.Template
+	wsHub        *WebSocketHub
+	courseCounts *CourseCountBatcher
+	changes      *ChangeDispatcher
+	events       *EventLog
+	scheduler    *NotificationScheduler
+	dashboard    *AdminDashboard
+	admission    *AdmissionQueue
 }
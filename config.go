@@ -2,6 +2,7 @@ package main
 
 import (
 	"bufio"
+	"errors"
 	"fmt"
 	"os"
 	"time"
@@ -30,12 +31,41 @@ type Config struct {
 		} `scfgs:"tls"`
 	} `scfgs:"listen"`
 	OIDC struct {
-		Bypass    bool   `scfgs:"bypass"`
-		Client    string `scfgs:"client"`
-		Authorize string `scfgs:"authorize"`
-		JWKS      string `scfgs:"jwks"`
+		Client string   `scfgs:"client"`
+		Issuer string   `scfgs:"issuer"`
+		Scopes []string `scfgs:"scopes"`
+		Secret string   `scfgs:"secret"`
 	} `scfgs:"oidc"`
-	Admins map[string]struct{} `scfgs:"admins"`
+	Identity struct {
+		Claim          string            `scfgs:"claim"`
+		Domains        []string          `scfgs:"domains"`
+		StudentPattern string            `scfgs:"student_pattern"`
+		StaffPattern   string            `scfgs:"staff_pattern"`
+		StudentLookup  map[string]int64  `scfgs:"student_lookup"`
+		StaffLookup    map[string]string `scfgs:"staff_lookup"`
+	} `scfgs:"identity"`
+	DevLogin struct {
+		InsecureEnable bool     `scfgs:"insecure_enable"`
+		Loopback       bool     `scfgs:"loopback"`
+		Students       []int64  `scfgs:"students"`
+		Staff          []string `scfgs:"staff"`
+	} `scfgs:"dev_login"`
+	Session struct {
+		Lifetime time.Duration `scfgs:"lifetime"`
+		KeyFile  string        `scfgs:"key_file"`
+	} `scfgs:"session"`
+	WebSocket struct {
+		CountInterval time.Duration `scfgs:"count_interval"`
+		Cluster       bool          `scfgs:"cluster"`
+		ReplayEvents  int32         `scfgs:"replay_events"`
+		PingInterval  time.Duration `scfgs:"ping_interval"`
+		WriteTimeout  time.Duration `scfgs:"write_timeout"`
+		MaxPerStudent int           `scfgs:"max_per_student"`
+	} `scfgs:"websocket"`
+	Admission struct {
+		MaxActive int           `scfgs:"max_active"`
+		MaxWait   time.Duration `scfgs:"max_wait"`
+	} `scfgs:"admission"`
 	// SSEBuf int                 `scfgs:"sse_buf"` // Not needed anymore
 }
 
@@ -50,6 +80,23 @@ func loadConfig(path string) (Config, error) {
 	if err != nil {
 		return config, fmt.Errorf("decode config: %w", err)
 	}
+	if err := config.validate(); err != nil {
+		return config, fmt.Errorf("invalid config: %w", err)
+	}
 
 	return config, nil
 }
+
+// validate rejects values that decode but that the server cannot run with.
+func (config *Config) validate() error {
+	// Unlike the other intervals, the course count interval cannot be
+	// disabled, as counts are only ever sent when it elapses.
+	if config.WebSocket.CountInterval <= 0 {
+		return errors.New("websocket.count_interval must be positive")
+	}
+	// Sessions would expire as soon as they are created.
+	if config.Session.Lifetime <= 0 {
+		return errors.New("session.lifetime must be positive")
+	}
+	return nil
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// loadShippedConfig loads cca.scfgs with old replaced by replacement.
+func loadShippedConfig(t *testing.T, old, replacement string) (Config, error) {
+	t.Helper()
+	shipped, err := os.ReadFile("cca.scfgs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(shipped), old) {
+		t.Fatalf("cca.scfgs does not contain %q", old)
+	}
+	path := filepath.Join(t.TempDir(), "cca.scfgs")
+	if err := os.WriteFile(path, []byte(strings.Replace(string(shipped), old, replacement, 1)), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return loadConfig(path)
+}
+
+func TestLoadConfig(t *testing.T) {
+	tests := []struct {
+		name        string
+		old         string
+		replacement string
+		wantErr     string
+	}{
+		{"shipped", "", "", ""},
+		{"count interval zero", "count_interval 250000000", "count_interval 0", "websocket.count_interval"},
+		{"count interval negative", "count_interval 250000000", "count_interval -1", "websocket.count_interval"},
+		{"session lifetime zero", "lifetime 259200000000000", "lifetime 0", "session.lifetime"},
+		{"session lifetime negative", "lifetime 259200000000000", "lifetime -1", "session.lifetime"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := loadShippedConfig(t, tt.old, tt.replacement)
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Fatal(err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Fatalf("error = %v, want one mentioning %s", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"html/template"
+	"net/http"
+	"regexp"
+	"strconv"
+)
+
+// Admin pages carry a CSRF token in every POST form, which adminOnly checks
+// on every request that is not a GET or HEAD. Tokens are derived from the
+// session ID with the session key, so they are per session and need not be
+// stored.
+const (
+	csrfFormField = "csrf_token"
+	csrfHeader    = "X-CSRF-Token"
+)
+
+type csrfContextKey struct{}
+
+func (app *App) csrfToken(sessionID int64) string {
+	mac := hmac.New(sha256.New, app.sessionKey)
+	mac.Write([]byte("csrf:" + strconv.FormatInt(sessionID, 10)))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+func withCSRFToken(r *http.Request, token string) *http.Request {
+	return r.WithContext(context.WithValue(r.Context(), csrfContextKey{}, token))
+}
+
+func csrfTokenFromContext(ctx context.Context) string {
+	token, _ := ctx.Value(csrfContextKey{}).(string)
+	return token
+}
+
+// checkCSRF reports whether a request carries the CSRF token of its session,
+// either in the X-CSRF-Token header or in the form. Multipart forms are
+// parsed with the same limit as the import handlers use.
+func (app *App) checkCSRF(r *http.Request, sessionID int64) bool {
+	token := r.Header.Get(csrfHeader)
+	if token == "" {
+		if err := r.ParseMultipartForm(8 << 20); err != nil && !errors.Is(err, http.ErrNotMultipart) {
+			return false
+		}
+		token = r.PostFormValue(csrfFormField)
+	}
+	return token != "" && equalConstantTime(token, app.csrfToken(sessionID))
+}
+
+var csrfFormPattern = regexp.MustCompile(`(?i)<form\b[^>]*\bmethod="post"[^>]*>`)
+
+// injectCSRF adds the token as a hidden field to every POST form in a
+// rendered page.
+func injectCSRF(page []byte, token string) []byte {
+	field := []byte(`<input type="hidden" name="` + csrfFormField + `" value="` + template.HTMLEscapeString(token) + `" />`)
+	return csrfFormPattern.ReplaceAllFunc(page, func(form []byte) []byte {
+		return append(bytes.Clone(form), field...)
+	})
+}
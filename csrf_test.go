@@ -0,0 +1,112 @@
+package main
+
+import (
+	"bytes"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func TestInjectCSRF(t *testing.T) {
+	field := `<input type="hidden" name="csrf_token" value="tok" />`
+	tests := []struct {
+		name string
+		page string
+		want string
+	}{
+		{"post form", `<form method="POST" action="/x"><button>Go</button></form>`, `<form method="POST" action="/x">` + field + `<button>Go</button></form>`},
+		{"lowercase", `<form action="/x" method="post">`, `<form action="/x" method="post">` + field},
+		{"get form", `<form method="GET" action="/search">`, `<form method="GET" action="/search">`},
+		{"no method", `<form action="/search">`, `<form action="/search">`},
+		{"two forms", `<form method="POST"></form><form method="POST"></form>`, `<form method="POST">` + field + `</form><form method="POST">` + field + `</form>`},
+		{"not a form", `<formula method="POST">`, `<formula method="POST">`},
+		{"text mentioning forms", `<p>Submit the form method="POST" below</p>`, `<p>Submit the form method="POST" below</p>`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := string(injectCSRF([]byte(tt.page), "tok")); got != tt.want {
+				t.Errorf("injectCSRF(%q) = %q, want %q", tt.page, got, tt.want)
+			}
+		})
+	}
+
+	if got := string(injectCSRF([]byte(`<form method="POST">`), `"><script>`)); strings.Contains(got, "<script>") {
+		t.Errorf("token not escaped: %s", got)
+	}
+}
+
+func TestCSRFToken(t *testing.T) {
+	app := &App{sessionKey: bytes.Repeat([]byte("k"), sessionKeyMinLength)}
+	other := &App{sessionKey: bytes.Repeat([]byte("o"), sessionKeyMinLength)}
+	if app.csrfToken(5) != app.csrfToken(5) {
+		t.Error("token is not stable within a session")
+	}
+	if app.csrfToken(5) == app.csrfToken(6) {
+		t.Error("sessions share a token")
+	}
+	if app.csrfToken(5) == other.csrfToken(5) {
+		t.Error("token does not depend on the session key")
+	}
+}
+
+func TestCheckCSRF(t *testing.T) {
+	app := &App{sessionKey: bytes.Repeat([]byte("k"), sessionKeyMinLength)}
+	token := app.csrfToken(5)
+
+	urlencoded := func(values url.Values) func() *http.Request {
+		return func() *http.Request {
+			r := httptest.NewRequest(http.MethodPost, "/admin/x", strings.NewReader(values.Encode()))
+			r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+			return r
+		}
+	}
+	multipartForm := func(token string) func() *http.Request {
+		return func() *http.Request {
+			var body bytes.Buffer
+			mw := multipart.NewWriter(&body)
+			_ = mw.WriteField(csrfFormField, token)
+			fw, _ := mw.CreateFormFile("csv", "students.csv")
+			_, _ = fw.Write([]byte("id,name\n"))
+			_ = mw.Close()
+			r := httptest.NewRequest(http.MethodPost, "/admin/x", &body)
+			r.Header.Set("Content-Type", mw.FormDataContentType())
+			return r
+		}
+	}
+	header := func(token string) func() *http.Request {
+		return func() *http.Request {
+			r := httptest.NewRequest(http.MethodPost, "/admin/x", strings.NewReader(`{}`))
+			r.Header.Set("Content-Type", "application/json")
+			r.Header.Set(csrfHeader, token)
+			return r
+		}
+	}
+
+	tests := []struct {
+		name    string
+		request func() *http.Request
+		want    bool
+	}{
+		{"urlencoded", urlencoded(url.Values{csrfFormField: {token}, "id": {"1"}}), true},
+		{"urlencoded wrong token", urlencoded(url.Values{csrfFormField: {app.csrfToken(6)}}), false},
+		{"urlencoded no token", urlencoded(url.Values{"id": {"1"}}), false},
+		{"urlencoded empty token", urlencoded(url.Values{csrfFormField: {""}}), false},
+		{"multipart", multipartForm(token), true},
+		{"multipart wrong token", multipartForm("wrong"), false},
+		{"header", header(token), true},
+		{"header wrong token", header("wrong"), false},
+		{"query", func() *http.Request {
+			return httptest.NewRequest(http.MethodPost, "/admin/x?"+csrfFormField+"="+token, nil)
+		}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := app.checkCSRF(tt.request(), 5); got != tt.want {
+				t.Errorf("checkCSRF = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
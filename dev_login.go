@@ -0,0 +1,130 @@
+package main
+
+import (
+	"crypto/rand"
+	"log/slog"
+	"net"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+)
+
+// The development login form carries a token that must match a cookie set
+// when the form was served, so that other sites cannot log a browser in as
+// an identity of their choosing.
+const (
+	devLoginCookie   = "dev_login"
+	devLoginLifetime = time.Hour
+)
+
+// devLoginProxyHeaders are set by reverse proxies. Behind one, every request
+// comes from the address of the proxy, which is often loopback.
+var devLoginProxyHeaders = []string{"Forwarded", "X-Forwarded-For", "X-Real-IP"}
+
+func setDevLoginCookie(w http.ResponseWriter, token string) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     devLoginCookie,
+		Value:    token,
+		Path:     "/auth/dev",
+		SameSite: http.SameSiteStrictMode,
+		HttpOnly: true,
+		Secure:   true,
+		MaxAge:   int(devLoginLifetime / time.Second),
+	})
+}
+
+// devLoginTokenValid reports whether a development login form was served to
+// the browser that submitted it.
+func devLoginTokenValid(r *http.Request) bool {
+	cookie, err := r.Cookie(devLoginCookie)
+	if err != nil || cookie.Value == "" {
+		return false
+	}
+	return equalConstantTime(r.PostFormValue(csrfFormField), cookie.Value)
+}
+
+// devLoginLoopback reports whether a request comes from a loopback address
+// and was not forwarded by a reverse proxy. remoteHost does not trust
+// forwarding headers, so a request that carries one is assumed to come
+// from elsewhere.
+func devLoginLoopback(r *http.Request) bool {
+	for _, header := range devLoginProxyHeaders {
+		if r.Header.Get(header) != "" {
+			return false
+		}
+	}
+	ip := net.ParseIP(remoteHost(r))
+	return ip != nil && ip.IsLoopback()
+}
+
+// devLoginAllowed reports whether the development login may be used to log
+// in as what an identity maps to, and removes what it may not log in as.
+// Loopback clients may log in as anyone, others only as the students and
+// staff listed in dev_login.
+func (app *App) devLoginAllowed(r *http.Request, mapped *mappedIdentity) (loopback bool, ok bool) {
+	if app.config.DevLogin.Loopback && devLoginLoopback(r) {
+		return true, mapped.StaffUsername != "" || mapped.IsStudent
+	}
+	if !slices.Contains(app.config.DevLogin.Staff, mapped.StaffUsername) {
+		mapped.StaffUsername = ""
+	}
+	if !slices.Contains(app.config.DevLogin.Students, mapped.StudentID) {
+		mapped.IsStudent = false
+	}
+	return false, mapped.StaffUsername != "" || mapped.IsStudent
+}
+
+// handleAuthDev logs in without the identity provider, as whatever the
+// submitted identity maps to. Logins must be submitted from the form, which
+// GET serves. It is only routed when dev_login.insecure_enable is set.
+func (app *App) handleAuthDev(w http.ResponseWriter, r *http.Request) {
+	app.logRequestStart(r, "handleAuthDev")
+	switch r.Method {
+	case http.MethodGet:
+		token := rand.Text()
+		setDevLoginCookie(w, token)
+		if err := app.loginRenderTemplate(w, r, "dev", struct {
+			Students  []int64
+			Staff     []string
+			Loopback  bool
+			OIDC      bool
+			Token     string
+			TokenName string
+		}{
+			Students:  app.config.DevLogin.Students,
+			Staff:     app.config.DevLogin.Staff,
+			Loopback:  app.config.DevLogin.Loopback,
+			OIDC:      app.kf != nil,
+			Token:     token,
+			TokenName: csrfFormField,
+		}); err != nil {
+			app.respondHTTPError(r, w, http.StatusInternalServerError, "Internal Server Error\nfailed rendering template", err)
+		}
+		return
+	case http.MethodPost:
+	default:
+		app.respondHTTPError(r, w, http.StatusMethodNotAllowed, "Method Not Allowed", nil)
+		return
+	}
+
+	if !devLoginTokenValid(r) {
+		app.respondHTTPError(r, w, http.StatusBadRequest, "Bad Request\nLogin expired or was not started from this site, please try again", nil)
+		return
+	}
+
+	identity := strings.ToLower(strings.TrimSpace(r.PostFormValue("identity")))
+	mapped, err := app.identity.mapIdentity(identity)
+	if err != nil {
+		app.respondHTTPError(r, w, http.StatusUnauthorized, "Unauthorized\nIdentity not in a domain allowed to log in", err, slog.String("identity", identity))
+		return
+	}
+	loopback, ok := app.devLoginAllowed(r, &mapped)
+	if !ok {
+		app.respondHTTPError(r, w, http.StatusForbidden, "Forbidden\nIdentity is not allowed to use the development login", nil, slog.String("identity", identity))
+		return
+	}
+
+	app.logWarn(r, logMsgAuthDevLogin, slog.String("identity", identity), slog.Bool("loopback", loopback))
+	app.startMappedSession(w, r, identity, mapped, slog.Bool("dev_login", true))
+}
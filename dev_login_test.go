@@ -0,0 +1,116 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func TestDevLoginAllowed(t *testing.T) {
+	app := &App{}
+	app.config.DevLogin.Students = []int64{1001}
+	app.config.DevLogin.Staff = []string{"test.admin"}
+
+	tests := []struct {
+		name         string
+		loopback     bool
+		remoteAddr   string
+		header       string
+		mapped       mappedIdentity
+		wantLoopback bool
+		wantOK       bool
+		wantMapped   mappedIdentity
+	}{
+		{"listed student", false, "192.0.2.1:1", "", mappedIdentity{StudentID: 1001, IsStudent: true}, false, true, mappedIdentity{StudentID: 1001, IsStudent: true}},
+		{"listed staff only", false, "192.0.2.1:1", "", mappedIdentity{StaffUsername: "test.admin", StudentID: 5, IsStudent: true}, false, true, mappedIdentity{StaffUsername: "test.admin", StudentID: 5}},
+		{"unlisted", false, "192.0.2.1:1", "", mappedIdentity{StaffUsername: "head", StudentID: 5, IsStudent: true}, false, false, mappedIdentity{StudentID: 5}},
+		{"loopback not enabled", false, "127.0.0.1:1", "", mappedIdentity{StaffUsername: "head"}, false, false, mappedIdentity{}},
+		{"loopback", true, "127.0.0.1:1", "", mappedIdentity{StaffUsername: "head"}, true, true, mappedIdentity{StaffUsername: "head"}},
+		{"IPv6 loopback", true, "[::1]:1", "", mappedIdentity{StudentID: 5, IsStudent: true}, true, true, mappedIdentity{StudentID: 5, IsStudent: true}},
+		{"loopback maps to nothing", true, "127.0.0.1:1", "", mappedIdentity{}, true, false, mappedIdentity{}},
+		{"not loopback", true, "192.0.2.1:1", "", mappedIdentity{StaffUsername: "head"}, false, false, mappedIdentity{}},
+		{"behind a proxy", true, "127.0.0.1:1", "Forwarded", mappedIdentity{StaffUsername: "head"}, false, false, mappedIdentity{}},
+		{"X-Forwarded-For", true, "127.0.0.1:1", "X-Forwarded-For", mappedIdentity{StaffUsername: "head"}, false, false, mappedIdentity{}},
+		{"X-Real-IP", true, "127.0.0.1:1", "X-Real-IP", mappedIdentity{StaffUsername: "head"}, false, false, mappedIdentity{}},
+		{"behind a proxy, listed", true, "127.0.0.1:1", "X-Forwarded-For", mappedIdentity{StaffUsername: "test.admin"}, false, true, mappedIdentity{StaffUsername: "test.admin"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			app.config.DevLogin.Loopback = tt.loopback
+			r := httptest.NewRequest(http.MethodPost, "/auth/dev", nil)
+			r.RemoteAddr = tt.remoteAddr
+			if tt.header != "" {
+				r.Header.Set(tt.header, "192.0.2.9")
+			}
+			mapped := tt.mapped
+			loopback, ok := app.devLoginAllowed(r, &mapped)
+			if loopback != tt.wantLoopback || ok != tt.wantOK || mapped != tt.wantMapped {
+				t.Errorf("devLoginAllowed = %v, %v, %+v, want %v, %v, %+v", loopback, ok, mapped, tt.wantLoopback, tt.wantOK, tt.wantMapped)
+			}
+		})
+	}
+}
+
+// TestAuthDevRequiresFormToken checks that logins are only accepted from the
+// form served to the same browser. Identities outside the allowed domains
+// are rejected after the token is checked, before the database is used.
+func TestAuthDevRequiresFormToken(t *testing.T) {
+	app := &App{}
+	if err := app.loginLoadTemplates(); err != nil {
+		t.Fatal(err)
+	}
+	app.config.DevLogin.InsecureEnable = true
+	app.config.Identity.Claim = "email"
+	app.config.Identity.Domains = []string{"school.example"}
+	var err error
+	if app.identity, err = newIdentityMapper(app.config); err != nil {
+		t.Fatal(err)
+	}
+
+	w := httptest.NewRecorder()
+	app.handleAuthDev(w, httptest.NewRequest(http.MethodGet, "/auth/dev", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("GET status = %d", w.Code)
+	}
+	var cookie *http.Cookie
+	for _, c := range w.Result().Cookies() {
+		if c.Name == devLoginCookie {
+			cookie = c
+		}
+	}
+	if cookie == nil || cookie.Value == "" || cookie.SameSite != http.SameSiteStrictMode || !cookie.HttpOnly {
+		t.Fatalf("cookie = %+v", cookie)
+	}
+	if field := `name="` + csrfFormField + `" value="` + cookie.Value + `"`; !strings.Contains(w.Body.String(), field) {
+		t.Fatalf("form lacks %s", field)
+	}
+
+	tests := []struct {
+		name       string
+		cookie     string
+		token      string
+		wantStatus int
+	}{
+		{"no cookie", "", cookie.Value, http.StatusBadRequest},
+		{"no token", cookie.Value, "", http.StatusBadRequest},
+		{"token of another browser", "attacker", cookie.Value, http.StatusBadRequest},
+		{"matching", cookie.Value, cookie.Value, http.StatusUnauthorized},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			form := url.Values{"identity": {"someone@elsewhere.example"}, csrfFormField: {tt.token}}
+			r := httptest.NewRequest(http.MethodPost, "/auth/dev", strings.NewReader(form.Encode()))
+			r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+			if tt.cookie != "" {
+				r.AddCookie(&http.Cookie{Name: devLoginCookie, Value: tt.cookie})
+			}
+			w := httptest.NewRecorder()
+			app.handleAuthDev(w, r)
+			if w.Code != tt.wantStatus {
+				t.Errorf("status = %d, want %d: %s", w.Code, tt.wantStatus, w.Body.String())
+			}
+		})
+	}
+}
@@ -12,7 +12,27 @@ func (app *App) handleAdm(w http.ResponseWriter, r *http.Request, aui *UserInfoA
 		return
 	}
 
-	if err := app.admRenderTemplate(w, r, "index", "", slog.String("admin_username", aui.Username)); err != nil {
+	var cluster *WSClusterStats
+	if app.wsHub.cluster != nil {
+		stats := app.wsHub.cluster.Stats()
+		cluster = &stats
+	}
+
+	if err := app.admRenderTemplate(w, r, "index", struct {
+		Admission    AdmissionStats
+		WebSocket    WebSocketHubStats
+		CourseCounts CourseCountBatcherStats
+		Cluster      *WSClusterStats
+		Changes      ChangeDispatcherStats
+		Scheduler    NotificationSchedulerStats
+	}{
+		Admission:    app.admission.Stats(),
+		WebSocket:    app.wsHub.Stats(),
+		CourseCounts: app.courseCounts.Stats(),
+		Cluster:      cluster,
+		Changes:      app.changes.Stats(),
+		Scheduler:    app.scheduler.Stats(),
+	}, slog.String("admin_username", aui.Username)); err != nil {
 		app.respondHTTPError(r, w, http.StatusInternalServerError, "Internal Server Error\nfailed rendering template", err, slog.String("admin_username", aui.Username))
 	}
 }
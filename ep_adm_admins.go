@@ -0,0 +1,233 @@
+package main
+
+import (
+	"errors"
+	"log/slog"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+
+	"git.sr.ht/~runxiyu/cca/db"
+)
+
+var (
+	errAdminUnknownRole = errors.New("unknown role")
+	errAdminNoGrades    = errors.New("select at least one grade, or all grades")
+	errAdminSelf        = errors.New("you cannot change or delete your own account")
+)
+
+// adminForm is what the new and edit forms on the admins page submit.
+type adminForm struct {
+	role      db.AdminRole
+	allGrades bool
+	grades    []string
+}
+
+func parseAdminForm(r *http.Request) (adminForm, error) {
+	if err := r.ParseForm(); err != nil {
+		return adminForm{}, err
+	}
+	form := adminForm{
+		role:      db.AdminRole(strings.TrimSpace(r.PostForm.Get("role"))),
+		allGrades: r.PostForm.Get("all_grades") != "",
+	}
+	if !slices.Contains(adminRoles, form.role) {
+		return form, errAdminUnknownRole
+	}
+	if !form.allGrades {
+		form.grades = nonEmptyValues(r.PostForm["grades"])
+		if len(form.grades) == 0 {
+			return form, errAdminNoGrades
+		}
+	}
+	return form, nil
+}
+
+func (app *App) handleAdmAdmins(w http.ResponseWriter, r *http.Request, aui *UserInfoAdmin) {
+	app.logRequestStart(r, "handleAdmAdmins", slog.String("admin_username", aui.Username))
+	if r.Method != http.MethodGet {
+		app.respondHTTPError(r, w, http.StatusMethodNotAllowed, "Method Not Allowed", nil, slog.String("admin_username", aui.Username))
+		return
+	}
+
+	admins, err := app.queries.GetAdmins(r.Context())
+	if err != nil {
+		app.respondHTTPError(r, w, http.StatusInternalServerError, "Internal Server Error\n"+err.Error(), err, slog.String("admin_username", aui.Username))
+		return
+	}
+	grades, err := app.queries.GetGrades(r.Context())
+	if err != nil {
+		app.respondHTTPError(r, w, http.StatusInternalServerError, "Internal Server Error\n"+err.Error(), err, slog.String("admin_username", aui.Username))
+		return
+	}
+
+	if err := app.admRenderTemplate(w, r, "admins", struct {
+		Admins  []db.GetAdminsRow
+		Grades  []db.Grade
+		Roles   []db.AdminRole
+		Current int64
+	}{
+		Admins:  admins,
+		Grades:  grades,
+		Roles:   adminRoles,
+		Current: aui.ID,
+	}, slog.String("admin_username", aui.Username)); err != nil {
+		app.respondHTTPError(r, w, http.StatusInternalServerError, "Internal Server Error\nfailed rendering template", err, slog.String("admin_username", aui.Username))
+	}
+}
+
+func (app *App) handleAdmAdminsNew(w http.ResponseWriter, r *http.Request, aui *UserInfoAdmin) {
+	app.logRequestStart(r, "handleAdmAdminsNew", slog.String("admin_username", aui.Username))
+	if r.Method != http.MethodPost {
+		app.respondHTTPError(r, w, http.StatusMethodNotAllowed, "Method Not Allowed", nil, slog.String("admin_username", aui.Username))
+		return
+	}
+
+	// Usernames are what the identity configuration maps the lowercased
+	// identity of staff to on login.
+	username := strings.ToLower(strings.TrimSpace(r.FormValue("username")))
+	if username == "" {
+		app.respondHTTPError(r, w, http.StatusBadRequest, "Bad Request\nYou are trying to add an administrator without a username, which is not allowed", nil, slog.String("admin_username", aui.Username))
+		return
+	}
+	form, err := parseAdminForm(r)
+	if err != nil {
+		app.respondHTTPError(r, w, http.StatusBadRequest, "Bad Request\n"+err.Error(), err, slog.String("admin_username", aui.Username), slog.String("username", username))
+		return
+	}
+
+	tx, err := app.pool.Begin(r.Context())
+	if err != nil {
+		app.respondHTTPError(r, w, http.StatusInternalServerError, "Internal Server Error\n"+err.Error(), err, slog.String("admin_username", aui.Username))
+		return
+	}
+	defer func() {
+		_ = tx.Rollback(r.Context())
+	}()
+
+	qtx := app.queries.WithTx(tx)
+	id, err := qtx.NewAdmin(r.Context(), db.NewAdminParams{
+		Username:  username,
+		Role:      form.role,
+		AllGrades: form.allGrades,
+	})
+	if err != nil {
+		app.respondHTTPError(r, w, http.StatusInternalServerError, "Internal Server Error\n"+err.Error(), err, slog.String("admin_username", aui.Username), slog.String("username", username))
+		return
+	}
+	if err := qtx.AddAdminGrades(r.Context(), db.AddAdminGradesParams{
+		AdminID: id,
+		Grades:  nonNil(form.grades),
+	}); err != nil {
+		app.respondHTTPError(r, w, http.StatusInternalServerError, "Internal Server Error\n"+err.Error(), err, slog.String("admin_username", aui.Username), slog.String("username", username))
+		return
+	}
+	if err := tx.Commit(r.Context()); err != nil {
+		app.respondHTTPError(r, w, http.StatusInternalServerError, "Internal Server Error\n"+err.Error(), err, slog.String("admin_username", aui.Username))
+		return
+	}
+
+	app.logInfo(r, logMsgAdminAdminsCreate, slog.String("admin_username", aui.Username), slog.Int64("admin_id", id), slog.String("username", username), slog.String("role", string(form.role)), slog.Bool("all_grades", form.allGrades), slog.Any("grades", form.grades))
+	http.Redirect(w, r, "/admin/admins", http.StatusSeeOther)
+}
+
+func (app *App) handleAdmAdminsEdit(w http.ResponseWriter, r *http.Request, aui *UserInfoAdmin) {
+	app.logRequestStart(r, "handleAdmAdminsEdit", slog.String("admin_username", aui.Username))
+	if r.Method != http.MethodPost {
+		app.respondHTTPError(r, w, http.StatusMethodNotAllowed, "Method Not Allowed", nil, slog.String("admin_username", aui.Username))
+		return
+	}
+
+	id, err := strconv.ParseInt(r.FormValue("id"), 10, 64)
+	if err != nil {
+		app.respondHTTPError(r, w, http.StatusBadRequest, "Bad Request\nAdministrator ID must be a number", err, slog.String("admin_username", aui.Username))
+		return
+	}
+	// Superusers cannot demote themselves, so there is always at least one
+	// left to manage the others.
+	if id == aui.ID {
+		app.respondHTTPError(r, w, http.StatusBadRequest, "Bad Request\n"+errAdminSelf.Error(), errAdminSelf, slog.String("admin_username", aui.Username))
+		return
+	}
+	form, err := parseAdminForm(r)
+	if err != nil {
+		app.respondHTTPError(r, w, http.StatusBadRequest, "Bad Request\n"+err.Error(), err, slog.String("admin_username", aui.Username), slog.Int64("admin_id", id))
+		return
+	}
+
+	tx, err := app.pool.Begin(r.Context())
+	if err != nil {
+		app.respondHTTPError(r, w, http.StatusInternalServerError, "Internal Server Error\n"+err.Error(), err, slog.String("admin_username", aui.Username))
+		return
+	}
+	defer func() {
+		_ = tx.Rollback(r.Context())
+	}()
+
+	qtx := app.queries.WithTx(tx)
+	n, err := qtx.UpdateAdmin(r.Context(), db.UpdateAdminParams{
+		ID:        id,
+		Role:      form.role,
+		AllGrades: form.allGrades,
+	})
+	if err != nil {
+		app.respondHTTPError(r, w, http.StatusInternalServerError, "Internal Server Error\n"+err.Error(), err, slog.String("admin_username", aui.Username), slog.Int64("admin_id", id))
+		return
+	}
+	if n == 0 {
+		app.respondHTTPError(r, w, http.StatusNotFound, "Not Found\nNo such administrator", nil, slog.String("admin_username", aui.Username), slog.Int64("admin_id", id))
+		return
+	}
+	if err := qtx.DeleteAdminGrades(r.Context(), id); err != nil {
+		app.respondHTTPError(r, w, http.StatusInternalServerError, "Internal Server Error\n"+err.Error(), err, slog.String("admin_username", aui.Username), slog.Int64("admin_id", id))
+		return
+	}
+	if err := qtx.AddAdminGrades(r.Context(), db.AddAdminGradesParams{
+		AdminID: id,
+		Grades:  nonNil(form.grades),
+	}); err != nil {
+		app.respondHTTPError(r, w, http.StatusInternalServerError, "Internal Server Error\n"+err.Error(), err, slog.String("admin_username", aui.Username), slog.Int64("admin_id", id))
+		return
+	}
+	if err := tx.Commit(r.Context()); err != nil {
+		app.respondHTTPError(r, w, http.StatusInternalServerError, "Internal Server Error\n"+err.Error(), err, slog.String("admin_username", aui.Username))
+		return
+	}
+
+	app.logInfo(r, logMsgAdminAdminsUpdate, slog.String("admin_username", aui.Username), slog.Int64("admin_id", id), slog.String("role", string(form.role)), slog.Bool("all_grades", form.allGrades), slog.Any("grades", form.grades))
+	http.Redirect(w, r, "/admin/admins", http.StatusSeeOther)
+}
+
+// handleAdmAdminsDelete removes an administrator. Their sessions go with
+// them, so they are logged out at once.
+func (app *App) handleAdmAdminsDelete(w http.ResponseWriter, r *http.Request, aui *UserInfoAdmin) {
+	app.logRequestStart(r, "handleAdmAdminsDelete", slog.String("admin_username", aui.Username))
+	if r.Method != http.MethodPost {
+		app.respondHTTPError(r, w, http.StatusMethodNotAllowed, "Method Not Allowed", nil, slog.String("admin_username", aui.Username))
+		return
+	}
+
+	id, err := strconv.ParseInt(r.FormValue("id"), 10, 64)
+	if err != nil {
+		app.respondHTTPError(r, w, http.StatusBadRequest, "Bad Request\nAdministrator ID must be a number", err, slog.String("admin_username", aui.Username))
+		return
+	}
+	if id == aui.ID {
+		app.respondHTTPError(r, w, http.StatusBadRequest, "Bad Request\n"+errAdminSelf.Error(), errAdminSelf, slog.String("admin_username", aui.Username))
+		return
+	}
+
+	n, err := app.queries.DeleteAdmin(r.Context(), id)
+	if err != nil {
+		app.respondHTTPError(r, w, http.StatusInternalServerError, "Internal Server Error\n"+err.Error(), err, slog.String("admin_username", aui.Username), slog.Int64("admin_id", id))
+		return
+	}
+	if n == 0 {
+		app.respondHTTPError(r, w, http.StatusNotFound, "Not Found\nNo such administrator", nil, slog.String("admin_username", aui.Username), slog.Int64("admin_id", id))
+		return
+	}
+
+	app.logInfo(r, logMsgAdminAdminsDelete, slog.String("admin_username", aui.Username), slog.Int64("admin_id", id))
+	http.Redirect(w, r, "/admin/admins", http.StatusSeeOther)
+}
@@ -25,6 +25,11 @@ func (app *App) handleAdmCategories(w http.ResponseWriter, r *http.Request, aui
 
 func (app *App) handleAdmCategoriesNew(w http.ResponseWriter, r *http.Request, aui *UserInfoAdmin) {
 	app.logRequestStart(r, "handleAdmCategoriesNew", slog.String("admin_username", aui.Username))
+	if r.Method != http.MethodPost {
+		app.respondHTTPError(r, w, http.StatusMethodNotAllowed, "Method Not Allowed", nil, slog.String("admin_username", aui.Username))
+		return
+	}
+
 	id := r.FormValue("id")
 	if id == "" {
 		app.respondHTTPError(r, w, http.StatusBadRequest, "Bad Request\nYou are trying to add an empty category ID, which is not allowed", nil, slog.String("admin_username", aui.Username))
@@ -38,13 +43,17 @@ func (app *App) handleAdmCategoriesNew(w http.ResponseWriter, r *http.Request, a
 	}
 
 	app.logInfo(r, logMsgAdminCategoriesCreate, slog.String("admin_username", aui.Username), slog.String("category_id", id))
-	app.wsHub.Broadcast(WSMessage("invalidate_categories"))
 
 	http.Redirect(w, r, "/admin/categories", http.StatusSeeOther)
 }
 
 func (app *App) handleAdmCategoriesDelete(w http.ResponseWriter, r *http.Request, aui *UserInfoAdmin) {
 	app.logRequestStart(r, "handleAdmCategoriesDelete", slog.String("admin_username", aui.Username))
+	if r.Method != http.MethodPost {
+		app.respondHTTPError(r, w, http.StatusMethodNotAllowed, "Method Not Allowed", nil, slog.String("admin_username", aui.Username))
+		return
+	}
+
 	id := r.FormValue("id")
 	if id == "" {
 		app.respondHTTPError(r, w, http.StatusBadRequest, "Bad Request\nYou are trying to delete an empty category ID, which is not allowed", nil, slog.String("admin_username", aui.Username))
@@ -58,7 +67,6 @@ func (app *App) handleAdmCategoriesDelete(w http.ResponseWriter, r *http.Request
 	}
 
 	app.logInfo(r, logMsgAdminCategoriesDelete, slog.String("admin_username", aui.Username), slog.String("category_id", id))
-	app.wsHub.Broadcast(WSMessage("invalidate_categories"))
 
 	http.Redirect(w, r, "/admin/categories", http.StatusSeeOther)
 }
@@ -127,6 +127,11 @@ func (app *App) handleAdmCourses(w http.ResponseWriter, r *http.Request, aui *Us
 
 func (app *App) handleAdmCoursesNew(w http.ResponseWriter, r *http.Request, aui *UserInfoAdmin) {
 	app.logRequestStart(r, "handleAdmCoursesNew", slog.String("admin_username", aui.Username))
+	if r.Method != http.MethodPost {
+		app.respondHTTPError(r, w, http.StatusMethodNotAllowed, "Method Not Allowed", nil, slog.String("admin_username", aui.Username))
+		return
+	}
+
 	err := r.ParseForm()
 	if err != nil {
 		app.respondHTTPError(r, w, http.StatusBadRequest, "Bad Request\n"+err.Error(), err, slog.String("admin_username", aui.Username))
@@ -265,13 +270,17 @@ func (app *App) handleAdmCoursesNew(w http.ResponseWriter, r *http.Request, aui
 	}
 
 	app.logInfo(r, logMsgAdminCoursesCreate, slog.String("admin_username", aui.Username), slog.String("course_id", id))
-	app.wsHub.Broadcast(WSMessage("invalidate_courses"))
 
 	http.Redirect(w, r, "/admin/courses", http.StatusSeeOther)
 }
 
 func (app *App) handleAdmCoursesEdit(w http.ResponseWriter, r *http.Request, aui *UserInfoAdmin) {
 	app.logRequestStart(r, "handleAdmCoursesEdit", slog.String("admin_username", aui.Username))
+	if r.Method != http.MethodPost {
+		app.respondHTTPError(r, w, http.StatusMethodNotAllowed, "Method Not Allowed", nil, slog.String("admin_username", aui.Username))
+		return
+	}
+
 	err := r.ParseForm()
 	if err != nil {
 		app.respondHTTPError(r, w, http.StatusBadRequest, "Bad Request\n"+err.Error(), err, slog.String("admin_username", aui.Username))
@@ -437,13 +446,17 @@ func (app *App) handleAdmCoursesEdit(w http.ResponseWriter, r *http.Request, aui
 	}
 
 	app.logInfo(r, logMsgAdminCoursesUpdate, slog.String("admin_username", aui.Username), slog.String("course_id", id))
-	app.wsHub.Broadcast(WSMessage("invalidate_courses"))
 
 	http.Redirect(w, r, "/admin/courses", http.StatusSeeOther)
 }
 
 func (app *App) handleAdmCoursesDelete(w http.ResponseWriter, r *http.Request, aui *UserInfoAdmin) {
 	app.logRequestStart(r, "handleAdmCoursesDelete", slog.String("admin_username", aui.Username))
+	if r.Method != http.MethodPost {
+		app.respondHTTPError(r, w, http.StatusMethodNotAllowed, "Method Not Allowed", nil, slog.String("admin_username", aui.Username))
+		return
+	}
+
 	id := strings.TrimSpace(r.FormValue("id"))
 	if id == "" {
 		app.respondHTTPError(r, w, http.StatusBadRequest, "Bad Request\nYou are trying to delete a course with an empty ID, which is not allowed", nil, slog.String("admin_username", aui.Username))
@@ -457,7 +470,6 @@ func (app *App) handleAdmCoursesDelete(w http.ResponseWriter, r *http.Request, a
 	}
 
 	app.logInfo(r, logMsgAdminCoursesDelete, slog.String("admin_username", aui.Username), slog.String("course_id", id))
-	app.wsHub.Broadcast(WSMessage("invalidate_courses"))
 
 	http.Redirect(w, r, "/admin/courses", http.StatusSeeOther)
 }
@@ -680,7 +692,6 @@ func (app *App) handleAdmCoursesImport(w http.ResponseWriter, r *http.Request, a
 	}
 
 	app.logInfo(r, logMsgAdminCoursesImport, slog.String("admin_username", aui.Username))
-	app.wsHub.Broadcast(WSMessage("invalidate_courses"))
 
 	http.Redirect(w, r, "/admin/courses", http.StatusSeeOther)
 }
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/coder/websocket"
+)
+
+func (app *App) handleAdmDashboard(w http.ResponseWriter, r *http.Request, aui *UserInfoAdmin) {
+	app.logRequestStart(r, "handleAdmDashboard", slog.String("admin_username", aui.Username))
+	if r.Method != http.MethodGet {
+		app.apiError(r, w, http.StatusMethodNotAllowed, nil, slog.String("admin_username", aui.Username))
+		return
+	}
+
+	if err := app.admRenderTemplate(w, r, "dashboard", struct {
+		Clustered bool
+	}{
+		Clustered: app.wsHub.cluster != nil,
+	}, slog.String("admin_username", aui.Username)); err != nil {
+		app.respondHTTPError(r, w, http.StatusInternalServerError, "Internal Server Error\nfailed rendering template", err, slog.String("admin_username", aui.Username))
+	}
+}
+
+// handleAdmDashboardEvents streams the dashboard to administrators as JSON
+// text messages, one per second while anything is connected.
+func (app *App) handleAdmDashboardEvents(w http.ResponseWriter, r *http.Request, aui *UserInfoAdmin) {
+	app.logRequestStart(r, "handleAdmDashboardEvents", slog.String("admin_username", aui.Username))
+	if r.Method != http.MethodGet {
+		app.apiError(r, w, http.StatusMethodNotAllowed, nil, slog.String("admin_username", aui.Username))
+		return
+	}
+
+	conn, err := websocket.Accept(w, r, nil)
+	if err != nil {
+		app.logError(r, logMsgAdminDashboardUpgradeError, slog.Any("error", err))
+		return
+	}
+	defer func() {
+		_ = conn.CloseNow()
+	}()
+	app.logInfo(r, logMsgAdminDashboardEstablished, slog.String("admin_username", aui.Username))
+
+	// The dashboard sends nothing; CloseRead handles pings and cancels ctx
+	// once the connection is closed.
+	ctx := conn.CloseRead(context.Background())
+
+	snapshots, unsubscribe := app.dashboard.Subscribe()
+	defer unsubscribe()
+
+	var ping <-chan time.Time
+	if app.config.WebSocket.PingInterval > 0 {
+		ticker := time.NewTicker(app.config.WebSocket.PingInterval)
+		defer ticker.Stop()
+		ping = ticker.C
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case data := <-snapshots:
+			writeCtx, cancel := app.wsHub.withWriteTimeout(ctx)
+			err := conn.Write(writeCtx, websocket.MessageText, data)
+			cancel()
+			if err != nil {
+				return
+			}
+		case <-ping:
+			pingCtx, cancel := app.wsHub.withWriteTimeout(ctx)
+			err := conn.Ping(pingCtx)
+			cancel()
+			if err != nil {
+				return
+			}
+		}
+	}
+}
@@ -41,6 +41,11 @@ func (app *App) handleAdmGrades(w http.ResponseWriter, r *http.Request, aui *Use
 
 func (app *App) handleAdmGradesNew(w http.ResponseWriter, r *http.Request, aui *UserInfoAdmin) {
 	app.logRequestStart(r, "handleAdmGradesNew", slog.String("admin_username", aui.Username))
+	if r.Method != http.MethodPost {
+		app.respondHTTPError(r, w, http.StatusMethodNotAllowed, "Method Not Allowed", nil, slog.String("admin_username", aui.Username))
+		return
+	}
+
 	grade := r.FormValue("grade")
 	if grade == "" {
 		app.respondHTTPError(r, w, http.StatusBadRequest, "Bad Request\nYou are trying to add an empty grade name, which is not allowed", nil, slog.String("admin_username", aui.Username))
@@ -68,13 +73,17 @@ func (app *App) handleAdmGradesNew(w http.ResponseWriter, r *http.Request, aui *
 	}
 
 	app.logInfo(r, logMsgAdminGradesCreate, slog.String("admin_username", aui.Username), slog.String("grade", grade))
-	app.wsHub.Broadcast(WSMessage("invalidate_grades"))
 
 	http.Redirect(w, r, "/admin/grades", http.StatusSeeOther)
 }
 
 func (app *App) handleAdmGradesBulkEnabledUpdate(w http.ResponseWriter, r *http.Request, aui *UserInfoAdmin) {
 	app.logRequestStart(r, "handleAdmGradesBulkEnabledUpdate", slog.String("admin_username", aui.Username))
+	if r.Method != http.MethodPost {
+		app.respondHTTPError(r, w, http.StatusMethodNotAllowed, "Method Not Allowed", nil, slog.String("admin_username", aui.Username))
+		return
+	}
+
 	err := r.ParseForm()
 	if err != nil {
 		app.respondHTTPError(r, w, http.StatusBadRequest, "Bad Request\n"+err.Error(), err, slog.String("admin_username", aui.Username))
@@ -137,7 +146,6 @@ func (app *App) handleAdmGradesBulkEnabledUpdate(w http.ResponseWriter, r *http.
 	}
 
 	app.logInfo(r, logMsgAdminGradesUpdateFlags, slog.String("admin_username", aui.Username))
-	app.wsHub.Broadcast(WSMessage("invalidate_grades"))
 
 	http.Redirect(w, r, "/admin/grades", http.StatusSeeOther)
 }
@@ -145,6 +153,11 @@ func (app *App) handleAdmGradesBulkEnabledUpdate(w http.ResponseWriter, r *http.
 // Is this even still used?
 func (app *App) handleAdmGradesEdit(w http.ResponseWriter, r *http.Request, aui *UserInfoAdmin) {
 	app.logRequestStart(r, "handleAdmGradesEdit", slog.String("admin_username", aui.Username))
+	if r.Method != http.MethodPost {
+		app.respondHTTPError(r, w, http.StatusMethodNotAllowed, "Method Not Allowed", nil, slog.String("admin_username", aui.Username))
+		return
+	}
+
 	grade := r.FormValue("grade")
 	if grade == "" {
 		app.respondHTTPError(r, w, http.StatusBadRequest, "Bad Request\nYou are trying to edit an empty grade name, which is not allowed", nil, slog.String("admin_username", aui.Username))
@@ -162,13 +175,17 @@ func (app *App) handleAdmGradesEdit(w http.ResponseWriter, r *http.Request, aui
 	}
 
 	app.logInfo(r, logMsgAdminGradesUpdateFlag, slog.String("admin_username", aui.Username), slog.String("grade", grade))
-	app.wsHub.Broadcast(WSMessage("invalidate_grades"))
 
 	http.Redirect(w, r, "/admin/grades", http.StatusSeeOther)
 }
 
 func (app *App) handleAdmGradesDelete(w http.ResponseWriter, r *http.Request, aui *UserInfoAdmin) {
 	app.logRequestStart(r, "handleAdmGradesDelete", slog.String("admin_username", aui.Username))
+	if r.Method != http.MethodPost {
+		app.respondHTTPError(r, w, http.StatusMethodNotAllowed, "Method Not Allowed", nil, slog.String("admin_username", aui.Username))
+		return
+	}
+
 	grade := r.FormValue("grade")
 	if grade == "" {
 		app.respondHTTPError(r, w, http.StatusBadRequest, "Bad Request\nYou are trying to delete an empty grade name, which is not allowed", nil, slog.String("admin_username", aui.Username))
@@ -182,13 +199,17 @@ func (app *App) handleAdmGradesDelete(w http.ResponseWriter, r *http.Request, au
 	}
 
 	app.logInfo(r, logMsgAdminGradesDelete, slog.String("admin_username", aui.Username), slog.String("grade", grade))
-	app.wsHub.Broadcast(WSMessage("invalidate_grades"))
 
 	http.Redirect(w, r, "/admin/grades", http.StatusSeeOther)
 }
 
 func (app *App) handleAdmGradesNewRequirementGroup(w http.ResponseWriter, r *http.Request, aui *UserInfoAdmin) {
 	app.logRequestStart(r, "handleAdmGradesNewRequirementGroup", slog.String("admin_username", aui.Username))
+	if r.Method != http.MethodPost {
+		app.respondHTTPError(r, w, http.StatusMethodNotAllowed, "Method Not Allowed", nil, slog.String("admin_username", aui.Username))
+		return
+	}
+
 	grade := r.FormValue("grade")
 	if grade == "" {
 		app.respondHTTPError(r, w, http.StatusBadRequest, "Bad Request\nYou are trying to add a requirement group for an empty grade name, which is not allowed", nil, slog.String("admin_username", aui.Username))
@@ -223,13 +244,17 @@ func (app *App) handleAdmGradesNewRequirementGroup(w http.ResponseWriter, r *htt
 	}
 
 	app.logInfo(r, logMsgAdminGradesRequirementGroupCreate, slog.String("admin_username", aui.Username), slog.String("grade", grade))
-	app.wsHub.Broadcast(WSMessage("invalidate_grades"))
 
 	http.Redirect(w, r, "/admin/grades", http.StatusSeeOther)
 }
 
 func (app *App) handleAdmGradesDeleteRequirementGroup(w http.ResponseWriter, r *http.Request, aui *UserInfoAdmin) {
 	app.logRequestStart(r, "handleAdmGradesDeleteRequirementGroup", slog.String("admin_username", aui.Username))
+	if r.Method != http.MethodPost {
+		app.respondHTTPError(r, w, http.StatusMethodNotAllowed, "Method Not Allowed", nil, slog.String("admin_username", aui.Username))
+		return
+	}
+
 	idString := r.FormValue("id")
 	id, err := strconv.ParseInt(idString, 10, 32)
 	if err != nil {
@@ -243,7 +268,6 @@ func (app *App) handleAdmGradesDeleteRequirementGroup(w http.ResponseWriter, r *
 	}
 
 	app.logInfo(r, logMsgAdminGradesRequirementGroupDelete, slog.String("admin_username", aui.Username), slog.Int64("requirement_group_id", id))
-	app.wsHub.Broadcast(WSMessage("invalidate_grades"))
 
 	http.Redirect(w, r, "/admin/grades", http.StatusSeeOther)
 }
@@ -1,16 +1,117 @@
 package main
 
 import (
+	"cmp"
+	"context"
+	"errors"
+	"io"
 	"log/slog"
 	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/jackc/pgx/v5/pgtype"
+
+	"git.sr.ht/~runxiyu/cca/db"
+)
+
+const (
+	notifyTargetAll     = "all"
+	notifyTargetGrades  = "grades"
+	notifyTargetCourses = "courses"
+	notifyTargetMissing = "missing"
+	notifyTargetList    = "list"
+
+	// notifyHistoryLength is how many sent notifications the notify page
+	// lists.
+	notifyHistoryLength = 50
+
+	// notifyTimeLayout is the format of datetime-local inputs, which are
+	// interpreted in the server's time zone.
+	notifyTimeLayout = "2006-01-02T15:04"
+)
+
+// notifyForm is what the notify page submits. Uploaded ID lists are merged
+// into IDs, so that the preview can carry them to the send step without
+// asking for the file again.
+type notifyForm struct {
+	Text      string
+	Target    string
+	Grades    []string
+	CourseIDs []string
+	Periods   []string
+	IDs       string
+
+	// SendAt, RepeatMinutes and RepeatUntil are as entered, and are empty
+	// for notifications sent immediately.
+	SendAt        string
+	RepeatMinutes string
+	RepeatUntil   string
+	schedule      notifySchedule
+}
+
+// notifySchedule is when a scheduled notification is sent. A zero sendAt
+// means now, and a zero repeat that it is sent once.
+type notifySchedule struct {
+	sendAt time.Time
+	repeat time.Duration
+	until  time.Time
+}
+
+func (f notifyForm) Scheduled() bool {
+	return !f.schedule.sendAt.IsZero() || f.schedule.repeat > 0
+}
+
+// Selected reports whether value was chosen, for redisplaying the form.
+func (f notifyForm) Selected(values []string, value string) bool {
+	return slices.Contains(values, value)
+}
+
+// Description summarizes the recipients for the notification history.
+func (f notifyForm) Description() string {
+	switch f.Target {
+	case notifyTargetGrades:
+		return "Grades " + strings.Join(f.Grades, ", ")
+	case notifyTargetCourses:
+		return "Enrolled in " + strings.Join(f.CourseIDs, ", ")
+	case notifyTargetMissing:
+		return "Missing a selection in " + strings.Join(f.Periods, ", ")
+	case notifyTargetList:
+		return "List of " + strconv.Itoa(strings.Count(f.IDs, "\n")+1) + " IDs"
+	default:
+		return "All students"
+	}
+}
+
+type notifyPreview struct {
+	Recipients int
+	Connected  int
+	// Unknown lists uploaded IDs that are not students.
+	Unknown []int64
+}
+
+var (
+	errNotifyEmptyText     = errors.New("notification text is empty")
+	errNotifyUnknownTarget = errors.New("unknown recipient selection")
+	errNotifyNoGrades      = errors.New("select at least one grade")
+	errNotifyNoCourses     = errors.New("select at least one course")
+	errNotifyNoPeriods     = errors.New("select at least one period")
+	errNotifyNoIDs         = errors.New("enter or upload at least one student ID")
+	errNotifyNoRecipients  = errors.New("no students match the selected recipients")
+	errNotifyBadTime       = errors.New("times must be entered as YYYY-MM-DDTHH:MM")
+	errNotifyPastTime      = errors.New("the time to send must be in the future")
+	errNotifyBadRepeat     = errors.New("repeat interval must be a positive number of minutes")
+	errNotifyUntilNoRepeat = errors.New("an end time only applies to repeating notifications")
+	errNotifyUntilBefore   = errors.New("the end time must be after the first time it is sent")
 )
 
 func (app *App) handleAdmNotify(w http.ResponseWriter, r *http.Request, aui *UserInfoAdmin) {
 	app.logRequestStart(r, "handleAdmNotify", slog.String("admin_username", aui.Username))
 	if r.Method == http.MethodGet {
-		if err := app.admRenderTemplate(w, r, "notify", nil, slog.String("admin_username", aui.Username)); err != nil {
-			app.respondHTTPError(r, w, http.StatusInternalServerError, "Internal Server Error\nfailed rendering template", err, slog.String("admin_username", aui.Username))
-		}
+		app.renderAdmNotify(w, r, aui, notifyForm{Target: notifyTargetAll}, nil)
 		return
 	}
 
@@ -19,9 +120,512 @@ func (app *App) handleAdmNotify(w http.ResponseWriter, r *http.Request, aui *Use
 		return
 	}
 
-	message := r.FormValue("text")
-	app.logInfo(r, logMsgAdminNotificationsSend, slog.String("admin_username", aui.Username))
-	app.wsHub.Broadcast(WSMessage("notify," + message))
+	if !aui.can(adminPermNotify) {
+		app.respondHTTPError(r, w, http.StatusForbidden, "Forbidden\nYour role does not allow sending notifications", nil, slog.String("admin_username", aui.Username))
+		return
+	}
+
+	form, err := parseNotifyForm(r)
+	if err != nil {
+		app.respondHTTPError(r, w, http.StatusBadRequest, "Bad Request\n"+err.Error(), err, slog.String("admin_username", aui.Username))
+		return
+	}
+
+	if err := aui.checkNotifyScope(r.Context(), app.queries, form); err != nil {
+		if errors.Is(err, errAdminOutOfScope) {
+			app.respondHTTPError(r, w, http.StatusForbidden, "Forbidden\n"+err.Error(), nil, slog.String("admin_username", aui.Username), slog.String("target", form.Target))
+		} else {
+			app.respondHTTPError(r, w, http.StatusInternalServerError, "Internal Server Error\n"+err.Error(), err, slog.String("admin_username", aui.Username))
+		}
+		return
+	}
+
+	studentIDs, unknown, recipients, err := notifyRecipients(r.Context(), app.queries, form)
+	if err != nil {
+		app.respondHTTPError(r, w, http.StatusInternalServerError, "Internal Server Error\n"+err.Error(), err, slog.String("admin_username", aui.Username))
+		return
+	}
+
+	if r.FormValue("action") != "send" {
+		app.renderAdmNotify(w, r, aui, form, &notifyPreview{
+			Recipients: recipients,
+			Connected:  app.wsHub.ConnectedStudents(studentIDs),
+			Unknown:    unknown,
+		})
+		return
+	}
+
+	if form.Scheduled() {
+		id, err := app.queries.NewScheduledNotification(r.Context(), db.NewScheduledNotificationParams{
+			Text:          form.Text,
+			Sender:        aui.Username,
+			Target:        form.Target,
+			Grades:        form.Grades,
+			CourseIds:     form.CourseIDs,
+			Periods:       form.Periods,
+			Ids:           form.IDs,
+			SendAt:        pgtype.Timestamptz{Time: cmp.Or(form.schedule.sendAt, time.Now()), Valid: true},
+			RepeatSeconds: pgtype.Int8{Int64: int64(form.schedule.repeat / time.Second), Valid: form.schedule.repeat > 0},
+			RepeatUntil:   pgtype.Timestamptz{Time: form.schedule.until, Valid: !form.schedule.until.IsZero()},
+		})
+		if err != nil {
+			app.respondHTTPError(r, w, http.StatusInternalServerError, "Internal Server Error\n"+err.Error(), err, slog.String("admin_username", aui.Username))
+			return
+		}
+		app.logInfo(r, logMsgAdminNotificationsSchedule, slog.String("admin_username", aui.Username), slog.Int64("scheduled_id", id), slog.String("target", form.Target))
+		http.Redirect(w, r, "/admin/notify", http.StatusSeeOther)
+		return
+	}
+
+	if recipients == 0 {
+		app.respondHTTPError(r, w, http.StatusBadRequest, "Bad Request\n"+errNotifyNoRecipients.Error(), errNotifyNoRecipients, slog.String("admin_username", aui.Username))
+		return
+	}
+
+	notificationID, err := newNotification(r.Context(), app.queries, aui.Username, form, studentIDs, recipients)
+	if err != nil {
+		app.respondHTTPError(r, w, http.StatusInternalServerError, "Internal Server Error\n"+err.Error(), err, slog.String("admin_username", aui.Username))
+		return
+	}
+
+	if err := app.events.Publish(r.Context(), studentIDs, WSNotify{NotificationID: notificationID, Text: form.Text}); err != nil {
+		app.respondHTTPError(r, w, http.StatusInternalServerError, "Internal Server Error\n"+err.Error(), err, slog.String("admin_username", aui.Username))
+		return
+	}
+	app.logInfo(r, logMsgAdminNotificationsSend, slog.String("admin_username", aui.Username), slog.Int64("notification_id", notificationID), slog.String("target", form.Target), slog.Int("recipients", recipients))
+
+	http.Redirect(w, r, "/admin/notify", http.StatusSeeOther)
+}
+
+func (app *App) renderAdmNotify(w http.ResponseWriter, r *http.Request, aui *UserInfoAdmin, form notifyForm, preview *notifyPreview) {
+	grades, err := app.queries.GetGrades(r.Context())
+	if err != nil {
+		app.respondHTTPError(r, w, http.StatusInternalServerError, "Internal Server Error\n"+err.Error(), err, slog.String("admin_username", aui.Username))
+		return
+	}
+
+	courses, err := app.queries.GetCourses(r.Context())
+	if err != nil {
+		app.respondHTTPError(r, w, http.StatusInternalServerError, "Internal Server Error\n"+err.Error(), err, slog.String("admin_username", aui.Username))
+		return
+	}
+
+	periods, err := app.queries.GetPeriods(r.Context())
+	if err != nil {
+		app.respondHTTPError(r, w, http.StatusInternalServerError, "Internal Server Error\n"+err.Error(), err, slog.String("admin_username", aui.Username))
+		return
+	}
+
+	history, err := app.queries.GetNotificationHistory(r.Context(), notifyHistoryLength)
+	if err != nil {
+		app.respondHTTPError(r, w, http.StatusInternalServerError, "Internal Server Error\n"+err.Error(), err, slog.String("admin_username", aui.Username))
+		return
+	}
+
+	pending, err := app.queries.GetPendingScheduledNotifications(r.Context())
+	if err != nil {
+		app.respondHTTPError(r, w, http.StatusInternalServerError, "Internal Server Error\n"+err.Error(), err, slog.String("admin_username", aui.Username))
+		return
+	}
+	scheduled := make([]scheduledNotifyView, 0, len(pending))
+	for _, row := range pending {
+		scheduled = append(scheduled, newScheduledNotifyView(row))
+	}
+
+	if err := app.admRenderTemplate(w, r, "notify", struct {
+		Grades    []db.Grade
+		Courses   []db.GetCoursesRow
+		Periods   []string
+		Form      notifyForm
+		Preview   *notifyPreview
+		Clustered bool
+		History   []db.GetNotificationHistoryRow
+		Scheduled []scheduledNotifyView
+		TimeZone  string
+		CanNotify bool
+	}{
+		Grades:    grades,
+		Courses:   courses,
+		Periods:   periods,
+		Form:      form,
+		Preview:   preview,
+		Clustered: app.wsHub.cluster != nil,
+		History:   history,
+		Scheduled: scheduled,
+		TimeZone:  time.Now().Format("MST"),
+		CanNotify: aui.can(adminPermNotify),
+	}, slog.String("admin_username", aui.Username)); err != nil {
+		app.respondHTTPError(r, w, http.StatusInternalServerError, "Internal Server Error\nfailed rendering template", err, slog.String("admin_username", aui.Username))
+	}
+}
+
+func parseNotifyForm(r *http.Request) (notifyForm, error) {
+	if err := r.ParseMultipartForm(8 << 20); err != nil && !errors.Is(err, http.ErrNotMultipart) {
+		return notifyForm{}, err
+	}
+
+	form := notifyForm{
+		Text:      strings.TrimSpace(r.FormValue("text")),
+		Target:    r.FormValue("target"),
+		Grades:    nonEmptyValues(r.PostForm["grades"]),
+		CourseIDs: nonEmptyValues(r.PostForm["course_ids"]),
+		Periods:   nonEmptyValues(r.PostForm["periods"]),
+		IDs:       strings.TrimSpace(r.FormValue("ids")),
+
+		SendAt:        strings.TrimSpace(r.FormValue("send_at")),
+		RepeatMinutes: strings.TrimSpace(r.FormValue("repeat_minutes")),
+		RepeatUntil:   strings.TrimSpace(r.FormValue("repeat_until")),
+	}
+	if form.Text == "" {
+		return form, errNotifyEmptyText
+	}
+
+	schedule, err := parseNotifySchedule(form.SendAt, form.RepeatMinutes, form.RepeatUntil)
+	if err != nil {
+		return form, err
+	}
+	form.schedule = schedule
+
+	switch form.Target {
+	case notifyTargetAll:
+	case notifyTargetGrades:
+		if len(form.Grades) == 0 {
+			return form, errNotifyNoGrades
+		}
+	case notifyTargetCourses:
+		if len(form.CourseIDs) == 0 {
+			return form, errNotifyNoCourses
+		}
+	case notifyTargetMissing:
+		if len(form.Periods) == 0 {
+			return form, errNotifyNoPeriods
+		}
+	case notifyTargetList:
+		if f, _, err := r.FormFile("ids_file"); err == nil {
+			defer func() {
+				_ = f.Close()
+			}()
+			data, err := io.ReadAll(f)
+			if err != nil {
+				return form, err
+			}
+			form.IDs = strings.TrimSpace(form.IDs + "\n" + string(data))
+		} else if !errors.Is(err, http.ErrMissingFile) && !errors.Is(err, http.ErrNotMultipart) {
+			return form, err
+		}
+		ids, err := parseStudentIDList(form.IDs)
+		if err != nil {
+			return form, err
+		}
+		if len(ids) == 0 {
+			return form, errNotifyNoIDs
+		}
+		form.IDs = formatStudentIDList(ids)
+	default:
+		return form, errNotifyUnknownTarget
+	}
+	return form, nil
+}
+
+// parseNotifySchedule reads the scheduling fields of the notify page and of
+// the form for editing a scheduled notification. All of them may be empty.
+func parseNotifySchedule(sendAt, repeatMinutes, repeatUntil string) (notifySchedule, error) {
+	var schedule notifySchedule
+	if sendAt != "" {
+		t, err := time.ParseInLocation(notifyTimeLayout, sendAt, time.Local)
+		if err != nil {
+			return schedule, errNotifyBadTime
+		}
+		if !t.After(time.Now()) {
+			return schedule, errNotifyPastTime
+		}
+		schedule.sendAt = t
+	}
+	if repeatMinutes != "" {
+		minutes, err := strconv.Atoi(repeatMinutes)
+		if err != nil || minutes <= 0 {
+			return schedule, errNotifyBadRepeat
+		}
+		schedule.repeat = time.Duration(minutes) * time.Minute
+	}
+	if repeatUntil != "" {
+		if schedule.repeat == 0 {
+			return schedule, errNotifyUntilNoRepeat
+		}
+		t, err := time.ParseInLocation(notifyTimeLayout, repeatUntil, time.Local)
+		if err != nil {
+			return schedule, errNotifyBadTime
+		}
+		if !t.After(cmp.Or(schedule.sendAt, time.Now())) {
+			return schedule, errNotifyUntilBefore
+		}
+		schedule.until = t
+	}
+	return schedule, nil
+}
+
+// parseStudentIDList reads student IDs separated by commas, semicolons or
+// whitespace, as pasted from a spreadsheet or exported as CSV. Header cells
+// such as "id" or "student_id" are skipped, and duplicates are removed.
+func parseStudentIDList(s string) ([]int64, error) {
+	fields := strings.FieldsFunc(s, func(r rune) bool {
+		// Spreadsheet exports may start with a byte order mark.
+		return r == ',' || r == ';' || r == '"' || r == '\ufeff' || unicode.IsSpace(r)
+	})
+
+	var ids []int64
+	for _, field := range fields {
+		switch strings.ToLower(field) {
+		case "id", "student_id":
+			continue
+		}
+		id, err := strconv.ParseInt(field, 10, 64)
+		if err != nil {
+			return nil, errors.New("not a student ID: " + field)
+		}
+		ids = append(ids, id)
+	}
+	slices.Sort(ids)
+	return slices.Compact(ids), nil
+}
+
+func formatStudentIDList(ids []int64) string {
+	parts := make([]string, len(ids))
+	for i, id := range ids {
+		parts[i] = strconv.FormatInt(id, 10)
+	}
+	return strings.Join(parts, "\n")
+}
+
+func nonEmptyValues(values []string) []string {
+	var out []string
+	for _, v := range values {
+		if v = strings.TrimSpace(v); v != "" && !slices.Contains(out, v) {
+			out = append(out, v)
+		}
+	}
+	return out
+}
+
+// notifyRecipients resolves the students a notification is for, and how
+// many there are. The IDs are nil for everyone, including students added
+// after it is sent. For uploaded lists it also returns the IDs that do not
+// belong to any student.
+func notifyRecipients(ctx context.Context, q *db.Queries, form notifyForm) ([]int64, []int64, int, error) {
+	var ids, unknown []int64
+	var err error
+	switch form.Target {
+	case notifyTargetAll:
+		count, err := q.CountStudents(ctx)
+		if err != nil {
+			return nil, nil, 0, err
+		}
+		return nil, nil, int(count), nil
+	case notifyTargetGrades:
+		ids, err = q.GetStudentIDsByGrades(ctx, form.Grades)
+	case notifyTargetCourses:
+		ids, err = q.GetStudentIDsByCourses(ctx, form.CourseIDs)
+	case notifyTargetMissing:
+		ids, err = q.GetStudentIDsMissingPeriods(ctx, form.Periods)
+	case notifyTargetList:
+		var listed []int64
+		listed, err = parseStudentIDList(form.IDs)
+		if err != nil {
+			return nil, nil, 0, err
+		}
+		ids, err = q.GetExistingStudentIDs(ctx, listed)
+		for _, id := range listed {
+			if _, found := slices.BinarySearch(ids, id); !found {
+				unknown = append(unknown, id)
+			}
+		}
+	default:
+		return nil, nil, 0, errNotifyUnknownTarget
+	}
+	if err != nil {
+		return nil, nil, 0, err
+	}
+	return nonNil(ids), unknown, len(ids), nil
+}
+
+// newNotification stores a notification in the history, addressed to
+// studentIDs or to everyone if that is nil. It only inserts the
+// notification; receipts, which put it in a student's inbox, are created
+// by RecordNotificationsDelivered when the student next fetches their
+// notifications. The caller publishes it once it is committed.
+func newNotification(ctx context.Context, q *db.Queries, sender string, form notifyForm, studentIDs []int64, recipients int) (int64, error) {
+	row, err := q.NewNotification(ctx, db.NewNotificationParams{
+		Text:       form.Text,
+		Sender:     sender,
+		Target:     form.Description(),
+		StudentIds: studentIDs,
+		Recipients: int64(recipients),
+	})
+	return row.ID, err
+}
+
+// checkNotifyScope returns errAdminOutOfScope unless an administrator
+// responsible for only some grades is notifying students in those grades,
+// either by grade or by a list of IDs.
+func (u *UserInfoAdmin) checkNotifyScope(ctx context.Context, q *db.Queries, form notifyForm) error {
+	if u.AllGrades {
+		return nil
+	}
+	switch form.Target {
+	case notifyTargetGrades:
+		for _, grade := range form.Grades {
+			if !u.canGrade(grade) {
+				return errAdminOutOfScope
+			}
+		}
+		return nil
+	case notifyTargetList:
+		ids, err := parseStudentIDList(form.IDs)
+		if err != nil {
+			return err
+		}
+		return u.checkStudentScope(ctx, q, ids)
+	default:
+		return errAdminOutOfScope
+	}
+}
+
+// scheduledSender limits administrators responsible for only some grades
+// to the scheduled notifications they sent themselves.
+func (u *UserInfoAdmin) scheduledSender() pgtype.Text {
+	return pgtype.Text{String: u.Username, Valid: !u.AllGrades}
+}
+
+// nonNil keeps an empty result from being mistaken for everyone.
+func nonNil[T any](values []T) []T {
+	if values == nil {
+		return []T{}
+	}
+	return values
+}
+
+// scheduledNotifyView is a pending scheduled notification as listed on the
+// notify page, with its times formatted for the edit form.
+type scheduledNotifyView struct {
+	ID            int64
+	Text          string
+	Sender        string
+	Description   string
+	SendAt        string
+	RepeatMinutes string
+	RepeatUntil   string
+	Runs          int64
+}
+
+func newScheduledNotifyView(row db.ScheduledNotification) scheduledNotifyView {
+	view := scheduledNotifyView{
+		ID:          row.ID,
+		Text:        row.Text,
+		Sender:      row.Sender,
+		Description: scheduledNotifyForm(row).Description(),
+		SendAt:      row.SendAt.Time.In(time.Local).Format(notifyTimeLayout),
+		Runs:        row.Runs,
+	}
+	if row.RepeatSeconds.Valid {
+		view.RepeatMinutes = strconv.FormatInt(row.RepeatSeconds.Int64/60, 10)
+	}
+	if row.RepeatUntil.Valid {
+		view.RepeatUntil = row.RepeatUntil.Time.In(time.Local).Format(notifyTimeLayout)
+	}
+	return view
+}
+
+func scheduledNotifyForm(row db.ScheduledNotification) notifyForm {
+	return notifyForm{
+		Text:      row.Text,
+		Target:    row.Target,
+		Grades:    row.Grades,
+		CourseIDs: row.CourseIds,
+		Periods:   row.Periods,
+		IDs:       row.Ids,
+	}
+}
+
+// handleAdmNotifyScheduledEdit changes the text and times of a pending
+// scheduled notification. Its recipients cannot be changed; cancel it and
+// schedule a new one instead.
+func (app *App) handleAdmNotifyScheduledEdit(w http.ResponseWriter, r *http.Request, aui *UserInfoAdmin) {
+	app.logRequestStart(r, "handleAdmNotifyScheduledEdit", slog.String("admin_username", aui.Username))
+	if r.Method != http.MethodPost {
+		app.apiError(r, w, http.StatusMethodNotAllowed, nil, slog.String("admin_username", aui.Username))
+		return
+	}
+
+	id, err := strconv.ParseInt(r.FormValue("id"), 10, 64)
+	if err != nil {
+		app.respondHTTPError(r, w, http.StatusBadRequest, "Bad Request\nInvalid scheduled notification ID", err, slog.String("admin_username", aui.Username))
+		return
+	}
+
+	text := strings.TrimSpace(r.FormValue("text"))
+	if text == "" {
+		app.respondHTTPError(r, w, http.StatusBadRequest, "Bad Request\n"+errNotifyEmptyText.Error(), errNotifyEmptyText, slog.String("admin_username", aui.Username))
+		return
+	}
+
+	sendAt := strings.TrimSpace(r.FormValue("send_at"))
+	if sendAt == "" {
+		app.respondHTTPError(r, w, http.StatusBadRequest, "Bad Request\n"+errNotifyBadTime.Error(), errNotifyBadTime, slog.String("admin_username", aui.Username))
+		return
+	}
+	schedule, err := parseNotifySchedule(sendAt, strings.TrimSpace(r.FormValue("repeat_minutes")), strings.TrimSpace(r.FormValue("repeat_until")))
+	if err != nil {
+		app.respondHTTPError(r, w, http.StatusBadRequest, "Bad Request\n"+err.Error(), err, slog.String("admin_username", aui.Username))
+		return
+	}
+
+	n, err := app.queries.UpdateScheduledNotification(r.Context(), db.UpdateScheduledNotificationParams{
+		ID:            id,
+		Sender:        aui.scheduledSender(),
+		Text:          text,
+		SendAt:        pgtype.Timestamptz{Time: schedule.sendAt, Valid: true},
+		RepeatSeconds: pgtype.Int8{Int64: int64(schedule.repeat / time.Second), Valid: schedule.repeat > 0},
+		RepeatUntil:   pgtype.Timestamptz{Time: schedule.until, Valid: !schedule.until.IsZero()},
+	})
+	if err != nil {
+		app.respondHTTPError(r, w, http.StatusInternalServerError, "Internal Server Error\n"+err.Error(), err, slog.String("admin_username", aui.Username), slog.Int64("scheduled_id", id))
+		return
+	}
+	if n == 0 {
+		app.respondHTTPError(r, w, http.StatusNotFound, "Not Found\nThe notification has already been sent or cancelled, or was scheduled by someone else", nil, slog.String("admin_username", aui.Username), slog.Int64("scheduled_id", id))
+		return
+	}
+	app.logInfo(r, logMsgAdminNotificationsScheduleEdit, slog.String("admin_username", aui.Username), slog.Int64("scheduled_id", id))
+
+	http.Redirect(w, r, "/admin/notify", http.StatusSeeOther)
+}
+
+func (app *App) handleAdmNotifyScheduledCancel(w http.ResponseWriter, r *http.Request, aui *UserInfoAdmin) {
+	app.logRequestStart(r, "handleAdmNotifyScheduledCancel", slog.String("admin_username", aui.Username))
+	if r.Method != http.MethodPost {
+		app.apiError(r, w, http.StatusMethodNotAllowed, nil, slog.String("admin_username", aui.Username))
+		return
+	}
+
+	id, err := strconv.ParseInt(r.FormValue("id"), 10, 64)
+	if err != nil {
+		app.respondHTTPError(r, w, http.StatusBadRequest, "Bad Request\nInvalid scheduled notification ID", err, slog.String("admin_username", aui.Username))
+		return
+	}
+
+	n, err := app.queries.CancelScheduledNotification(r.Context(), db.CancelScheduledNotificationParams{
+		ID:     id,
+		Sender: aui.scheduledSender(),
+	})
+	if err != nil {
+		app.respondHTTPError(r, w, http.StatusInternalServerError, "Internal Server Error\n"+err.Error(), err, slog.String("admin_username", aui.Username), slog.Int64("scheduled_id", id))
+		return
+	}
+	if n == 0 {
+		app.respondHTTPError(r, w, http.StatusNotFound, "Not Found\nThe notification has already been sent or cancelled, or was scheduled by someone else", nil, slog.String("admin_username", aui.Username), slog.Int64("scheduled_id", id))
+		return
+	}
+	app.logInfo(r, logMsgAdminNotificationsScheduleCancel, slog.String("admin_username", aui.Username), slog.Int64("scheduled_id", id))
 
 	http.Redirect(w, r, "/admin/notify", http.StatusSeeOther)
 }
@@ -25,6 +25,11 @@ func (app *App) handleAdmPeriods(w http.ResponseWriter, r *http.Request, aui *Us
 
 func (app *App) handleAdmPeriodsNew(w http.ResponseWriter, r *http.Request, aui *UserInfoAdmin) {
 	app.logRequestStart(r, "handleAdmPeriodsNew", slog.String("admin_username", aui.Username))
+	if r.Method != http.MethodPost {
+		app.respondHTTPError(r, w, http.StatusMethodNotAllowed, "Method Not Allowed", nil, slog.String("admin_username", aui.Username))
+		return
+	}
+
 	id := r.FormValue("id")
 	if id == "" {
 		app.respondHTTPError(r, w, http.StatusBadRequest, "Bad Request\nYou are trying to add an empty period ID, which is not allowed", nil, slog.String("admin_username", aui.Username))
@@ -38,13 +43,17 @@ func (app *App) handleAdmPeriodsNew(w http.ResponseWriter, r *http.Request, aui
 	}
 
 	app.logInfo(r, logMsgAdminPeriodsCreate, slog.String("admin_username", aui.Username), slog.String("period_id", id))
-	app.wsHub.Broadcast(WSMessage("invalidate_periods"))
 
 	http.Redirect(w, r, "/admin/periods", http.StatusSeeOther)
 }
 
 func (app *App) handleAdmPeriodsDelete(w http.ResponseWriter, r *http.Request, aui *UserInfoAdmin) {
 	app.logRequestStart(r, "handleAdmPeriodsDelete", slog.String("admin_username", aui.Username))
+	if r.Method != http.MethodPost {
+		app.respondHTTPError(r, w, http.StatusMethodNotAllowed, "Method Not Allowed", nil, slog.String("admin_username", aui.Username))
+		return
+	}
+
 	id := r.FormValue("id")
 	if id == "" {
 		app.respondHTTPError(r, w, http.StatusBadRequest, "Bad Request\nYou are trying to delete an empty period ID, which is not allowed", nil, slog.String("admin_username", aui.Username))
@@ -58,7 +67,6 @@ func (app *App) handleAdmPeriodsDelete(w http.ResponseWriter, r *http.Request, a
 	}
 
 	app.logInfo(r, logMsgAdminPeriodsDelete, slog.String("admin_username", aui.Username), slog.String("period_id", id))
-	app.wsHub.Broadcast(WSMessage("invalidate_periods"))
 
 	http.Redirect(w, r, "/admin/periods", http.StatusSeeOther)
 }
@@ -115,6 +115,11 @@ func (app *App) handleAdmSelectionsExport(w http.ResponseWriter, r *http.Request
 
 func (app *App) handleAdmSelectionsNew(w http.ResponseWriter, r *http.Request, aui *UserInfoAdmin) {
 	app.logRequestStart(r, "handleAdmSelectionsNew", slog.String("admin_username", aui.Username))
+	if r.Method != http.MethodPost {
+		app.respondHTTPError(r, w, http.StatusMethodNotAllowed, "Method Not Allowed", nil, slog.String("admin_username", aui.Username))
+		return
+	}
+
 	err := r.ParseForm()
 	if err != nil {
 		app.respondHTTPError(r, w, http.StatusBadRequest, "Bad Request\n"+err.Error(), err, slog.String("admin_username", aui.Username))
@@ -149,6 +154,9 @@ func (app *App) handleAdmSelectionsNew(w http.ResponseWriter, r *http.Request, a
 		app.respondHTTPError(r, w, http.StatusBadRequest, "Bad Request\nNo valid student IDs provided", nil, slog.String("admin_username", aui.Username))
 		return
 	}
+	if !app.requireStudentScope(w, r, aui, studentIDs) {
+		return
+	}
 
 	rawCourseIDs := r.PostForm["course_ids"]
 	if len(rawCourseIDs) == 0 {
@@ -241,13 +249,16 @@ func (app *App) handleAdmSelectionsNew(w http.ResponseWriter, r *http.Request, a
 		slog.Any("course_ids", courseIDs),
 		slog.String("selection_type", string(selectionType)),
 	)
-	app.wsHub.BroadcastToStudents(studentIDs, WSMessage("invalidate_selections"))
-	app.broadcastCourseCounts(r, courseIDs)
 	http.Redirect(w, r, "/admin/selections", http.StatusSeeOther)
 }
 
 func (app *App) handleAdmSelectionsEdit(w http.ResponseWriter, r *http.Request, aui *UserInfoAdmin) {
 	app.logRequestStart(r, "handleAdmSelectionsEdit", slog.String("admin_username", aui.Username))
+	if r.Method != http.MethodPost {
+		app.respondHTTPError(r, w, http.StatusMethodNotAllowed, "Method Not Allowed", nil, slog.String("admin_username", aui.Username))
+		return
+	}
+
 	err := r.ParseForm()
 	if err != nil {
 		app.respondHTTPError(r, w, http.StatusBadRequest, "Bad Request\n"+err.Error(), err, slog.String("admin_username", aui.Username))
@@ -265,6 +276,9 @@ func (app *App) handleAdmSelectionsEdit(w http.ResponseWriter, r *http.Request,
 		app.respondHTTPError(r, w, http.StatusBadRequest, "Bad Request\nStudent ID must be a number", err, slog.String("admin_username", aui.Username))
 		return
 	}
+	if !app.requireStudentScope(w, r, aui, []int64{studentID}) {
+		return
+	}
 
 	period := strings.TrimSpace(r.FormValue("period"))
 	if period == "" {
@@ -278,15 +292,6 @@ func (app *App) handleAdmSelectionsEdit(w http.ResponseWriter, r *http.Request,
 		return
 	}
 
-	currentCourse, err := app.queries.GetSelectionCourseByStudentAndPeriod(r.Context(), db.GetSelectionCourseByStudentAndPeriodParams{
-		StudentID: studentID,
-		Period:    period,
-	})
-	if err != nil {
-		app.respondHTTPError(r, w, http.StatusInternalServerError, "Internal Server Error\n"+err.Error(), err, slog.String("admin_username", aui.Username), slog.Int64("student_id", studentID), slog.String("period", period))
-		return
-	}
-
 	selectionType := db.SelectionType(strings.TrimSpace(r.FormValue("selection_type")))
 	switch selectionType {
 	case db.SelectionTypeNormal, db.SelectionTypeInvite, db.SelectionTypeForce:
@@ -306,17 +311,16 @@ func (app *App) handleAdmSelectionsEdit(w http.ResponseWriter, r *http.Request,
 	}
 
 	app.logInfo(r, logMsgAdminSelectionsUpdate, slog.String("admin_username", aui.Username), slog.Int64("student_id", studentID), slog.String("course_id", courseID), slog.String("period", period), slog.String("selection_type", string(selectionType)))
-	app.wsHub.BroadcastToStudents([]int64{studentID}, WSMessage("invalidate_selections"))
-	courseSet := []string{courseID}
-	if currentCourse != courseID {
-		courseSet = append(courseSet, currentCourse)
-	}
-	app.broadcastCourseCounts(r, courseSet)
 	http.Redirect(w, r, "/admin/selections", http.StatusSeeOther)
 }
 
 func (app *App) handleAdmSelectionsDelete(w http.ResponseWriter, r *http.Request, aui *UserInfoAdmin) {
 	app.logRequestStart(r, "handleAdmSelectionsDelete", slog.String("admin_username", aui.Username))
+	if r.Method != http.MethodPost {
+		app.respondHTTPError(r, w, http.StatusMethodNotAllowed, "Method Not Allowed", nil, slog.String("admin_username", aui.Username))
+		return
+	}
+
 	studentIDStr := strings.TrimSpace(r.FormValue("student_id"))
 	if studentIDStr == "" {
 		app.respondHTTPError(r, w, http.StatusBadRequest, "Bad Request\nYou are trying to delete a selection without a student ID, which is not allowed", nil, slog.String("admin_username", aui.Username))
@@ -328,6 +332,9 @@ func (app *App) handleAdmSelectionsDelete(w http.ResponseWriter, r *http.Request
 		app.respondHTTPError(r, w, http.StatusBadRequest, "Bad Request\nStudent ID must be a number", err, slog.String("admin_username", aui.Username))
 		return
 	}
+	if !app.requireStudentScope(w, r, aui, []int64{studentID}) {
+		return
+	}
 
 	period := strings.TrimSpace(r.FormValue("period"))
 	if period == "" {
@@ -335,15 +342,6 @@ func (app *App) handleAdmSelectionsDelete(w http.ResponseWriter, r *http.Request
 		return
 	}
 
-	existingCourse, err := app.queries.GetSelectionCourseByStudentAndPeriod(r.Context(), db.GetSelectionCourseByStudentAndPeriodParams{
-		StudentID: studentID,
-		Period:    period,
-	})
-	if err != nil {
-		app.respondHTTPError(r, w, http.StatusInternalServerError, "Internal Server Error\n"+err.Error(), err, slog.String("admin_username", aui.Username), slog.Int64("student_id", studentID), slog.String("period", period))
-		return
-	}
-
 	if err = app.queries.DeleteSelection(r.Context(), db.DeleteSelectionParams{
 		StudentID: studentID,
 		Period:    period,
@@ -353,8 +351,6 @@ func (app *App) handleAdmSelectionsDelete(w http.ResponseWriter, r *http.Request
 	}
 
 	app.logInfo(r, logMsgAdminSelectionsDelete, slog.String("admin_username", aui.Username), slog.Int64("student_id", studentID), slog.String("period", period))
-	app.wsHub.BroadcastToStudents([]int64{studentID}, WSMessage("invalidate_selections"))
-	app.broadcastCourseCounts(r, []string{existingCourse})
 	http.Redirect(w, r, "/admin/selections", http.StatusSeeOther)
 }
 
@@ -480,24 +476,24 @@ func (app *App) handleAdmSelectionsImport(w http.ResponseWriter, r *http.Request
 		row++
 	}
 
+	students := make([]int64, 0, len(studentSet))
+	for id := range studentSet {
+		students = append(students, id)
+	}
+	if !app.requireStudentScope(w, r, aui, students) {
+		return
+	}
+
 	if err := tx.Commit(r.Context()); err != nil {
 		app.respondHTTPError(r, w, http.StatusInternalServerError, "Internal Server Error\n"+err.Error(), err, slog.String("admin_username", aui.Username))
 		return
 	}
 
-	students := make([]int64, 0, len(studentSet))
-	for id := range studentSet {
-		students = append(students, id)
-	}
 	courses := make([]string, 0, len(courseSet))
 	for id := range courseSet {
 		courses = append(courses, id)
 	}
 	app.logInfo(r, logMsgAdminSelectionsImport, slog.String("admin_username", aui.Username), slog.Int("rows", row-2), slog.Int("students_impacted", len(students)), slog.Int("courses_impacted", len(courses)))
-	if len(students) > 0 {
-		app.wsHub.BroadcastToStudents(students, WSMessage("invalidate_selections"))
-	}
-	app.broadcastCourseCounts(r, courses)
 
 	http.Redirect(w, r, "/admin/selections", http.StatusSeeOther)
 }
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/jackc/pgx/v5/pgtype"
+
+	"git.sr.ht/~runxiyu/cca/db"
+)
+
+// adminSessionsLength is how many sessions the sessions page lists.
+const adminSessionsLength = 200
+
+// handleAdmSessions lists the sessions that have not expired, most recently
+// used first, optionally only those of one student.
+func (app *App) handleAdmSessions(w http.ResponseWriter, r *http.Request, aui *UserInfoAdmin) {
+	app.logRequestStart(r, "handleAdmSessions", slog.String("admin_username", aui.Username))
+	if r.Method != http.MethodGet {
+		app.apiError(r, w, http.StatusMethodNotAllowed, nil, slog.String("admin_username", aui.Username))
+		return
+	}
+
+	var student pgtype.Int8
+	if s := strings.TrimSpace(r.URL.Query().Get("student")); s != "" {
+		id, err := strconv.ParseInt(strings.TrimLeft(s, "sS"), 10, 64)
+		if err != nil {
+			app.respondHTTPError(r, w, http.StatusBadRequest, "Bad Request\nStudent ID must be a number", err, slog.String("admin_username", aui.Username))
+			return
+		}
+		student = pgtype.Int8{Int64: id, Valid: true}
+	}
+
+	sessions, err := app.queries.GetSessions(r.Context(), db.GetSessionsParams{
+		StudentID:   student,
+		MaxSessions: adminSessionsLength,
+	})
+	if err != nil {
+		app.respondHTTPError(r, w, http.StatusInternalServerError, "Internal Server Error\n"+err.Error(), err, slog.String("admin_username", aui.Username))
+		return
+	}
+
+	if err := app.admRenderTemplate(w, r, "sessions", struct {
+		Sessions []db.GetSessionsRow
+		Student  pgtype.Int8
+		Current  int64
+		Limit    int
+	}{
+		Sessions: sessions,
+		Student:  student,
+		Current:  aui.SessionID,
+		Limit:    adminSessionsLength,
+	}, slog.String("admin_username", aui.Username)); err != nil {
+		app.respondHTTPError(r, w, http.StatusInternalServerError, "Internal Server Error\nfailed rendering template", err, slog.String("admin_username", aui.Username))
+	}
+}
+
+// handleAdmSessionsRevoke ends one session by its id, or every session of
+// a student by student_id.
+func (app *App) handleAdmSessionsRevoke(w http.ResponseWriter, r *http.Request, aui *UserInfoAdmin) {
+	app.logRequestStart(r, "handleAdmSessionsRevoke", slog.String("admin_username", aui.Username))
+	if r.Method != http.MethodPost {
+		app.apiError(r, w, http.StatusMethodNotAllowed, nil, slog.String("admin_username", aui.Username))
+		return
+	}
+
+	if s := r.FormValue("student_id"); s != "" {
+		studentID, err := strconv.ParseInt(s, 10, 64)
+		if err != nil {
+			app.respondHTTPError(r, w, http.StatusBadRequest, "Bad Request\nStudent ID must be a number", err, slog.String("admin_username", aui.Username))
+			return
+		}
+		n, err := app.queries.DeleteSessionsOfStudent(r.Context(), pgtype.Int8{Int64: studentID, Valid: true})
+		if err != nil {
+			app.respondHTTPError(r, w, http.StatusInternalServerError, "Internal Server Error\n"+err.Error(), err, slog.String("admin_username", aui.Username), slog.Int64("student_id", studentID))
+			return
+		}
+		app.logInfo(r, logMsgAdminSessionsRevoke, slog.String("admin_username", aui.Username), slog.Int64("student_id", studentID), slog.Int64("count", n))
+		http.Redirect(w, r, "/admin/sessions?student="+strconv.FormatInt(studentID, 10), http.StatusSeeOther)
+		return
+	}
+
+	id, err := strconv.ParseInt(r.FormValue("id"), 10, 64)
+	if err != nil {
+		app.respondHTTPError(r, w, http.StatusBadRequest, "Bad Request\nInvalid session ID", err, slog.String("admin_username", aui.Username))
+		return
+	}
+	n, err := app.queries.DeleteSession(r.Context(), id)
+	if err != nil {
+		app.respondHTTPError(r, w, http.StatusInternalServerError, "Internal Server Error\n"+err.Error(), err, slog.String("admin_username", aui.Username), slog.Int64("session_id", id))
+		return
+	}
+	if n == 0 {
+		app.respondHTTPError(r, w, http.StatusNotFound, "Not Found\nThe session has already ended", nil, slog.String("admin_username", aui.Username), slog.Int64("session_id", id))
+		return
+	}
+	app.logInfo(r, logMsgAdminSessionsRevoke, slog.String("admin_username", aui.Username), slog.Int64("session_id", id))
+
+	http.Redirect(w, r, "/admin/sessions", http.StatusSeeOther)
+}
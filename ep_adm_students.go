@@ -36,10 +36,12 @@ func (app *App) handleAdmStudents(w http.ResponseWriter, r *http.Request, aui *U
 		Students   []db.Student
 		Grades     []db.Grade
 		LegalSexes []db.LegalSex
+		CanWrite   bool
 	}{
 		Students:   students,
 		Grades:     grades,
 		LegalSexes: []db.LegalSex{db.LegalSexF, db.LegalSexM, db.LegalSexX},
+		CanWrite:   aui.can(adminPermSelections),
 	}, slog.String("admin_username", aui.Username)); err != nil {
 		app.respondHTTPError(r, w, http.StatusInternalServerError, "Internal Server Error\nfailed rendering template", err, slog.String("admin_username", aui.Username))
 	}
@@ -47,6 +49,11 @@ func (app *App) handleAdmStudents(w http.ResponseWriter, r *http.Request, aui *U
 
 func (app *App) handleAdmStudentsNew(w http.ResponseWriter, r *http.Request, aui *UserInfoAdmin) {
 	app.logRequestStart(r, "handleAdmStudentsNew", slog.String("admin_username", aui.Username))
+	if r.Method != http.MethodPost {
+		app.respondHTTPError(r, w, http.StatusMethodNotAllowed, "Method Not Allowed", nil, slog.String("admin_username", aui.Username))
+		return
+	}
+
 	err := r.ParseForm()
 	if err != nil {
 		app.respondHTTPError(r, w, http.StatusBadRequest, "Bad Request\n"+err.Error(), err, slog.String("admin_username", aui.Username))
@@ -101,6 +108,11 @@ func (app *App) handleAdmStudentsNew(w http.ResponseWriter, r *http.Request, aui
 
 func (app *App) handleAdmStudentsEdit(w http.ResponseWriter, r *http.Request, aui *UserInfoAdmin) {
 	app.logRequestStart(r, "handleAdmStudentsEdit", slog.String("admin_username", aui.Username))
+	if r.Method != http.MethodPost {
+		app.respondHTTPError(r, w, http.StatusMethodNotAllowed, "Method Not Allowed", nil, slog.String("admin_username", aui.Username))
+		return
+	}
+
 	err := r.ParseForm()
 	if err != nil {
 		app.respondHTTPError(r, w, http.StatusBadRequest, "Bad Request\n"+err.Error(), err, slog.String("admin_username", aui.Username))
@@ -155,6 +167,11 @@ func (app *App) handleAdmStudentsEdit(w http.ResponseWriter, r *http.Request, au
 
 func (app *App) handleAdmStudentsDelete(w http.ResponseWriter, r *http.Request, aui *UserInfoAdmin) {
 	app.logRequestStart(r, "handleAdmStudentsDelete", slog.String("admin_username", aui.Username))
+	if r.Method != http.MethodPost {
+		app.respondHTTPError(r, w, http.StatusMethodNotAllowed, "Method Not Allowed", nil, slog.String("admin_username", aui.Username))
+		return
+	}
+
 	idStr := strings.TrimSpace(r.FormValue("id"))
 	if idStr == "" {
 		app.respondHTTPError(r, w, http.StatusBadRequest, "Bad Request\nYou are trying to delete a student with an empty ID, which is not allowed", nil, slog.String("admin_username", aui.Username))
@@ -0,0 +1,157 @@
+package main
+
+import (
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"git.sr.ht/~runxiyu/cca/db"
+)
+
+func (app *App) handleAdmTeachers(w http.ResponseWriter, r *http.Request, aui *UserInfoAdmin) {
+	app.logRequestStart(r, "handleAdmTeachers", slog.String("admin_username", aui.Username))
+	if r.Method != http.MethodGet {
+		app.respondHTTPError(r, w, http.StatusMethodNotAllowed, "Method Not Allowed", nil, slog.String("admin_username", aui.Username))
+		return
+	}
+
+	teachers, err := app.queries.GetTeachers(r.Context())
+	if err != nil {
+		app.respondHTTPError(r, w, http.StatusInternalServerError, "Internal Server Error\n"+err.Error(), err, slog.String("admin_username", aui.Username))
+		return
+	}
+	courses, err := app.queries.GetCourses(r.Context())
+	if err != nil {
+		app.respondHTTPError(r, w, http.StatusInternalServerError, "Internal Server Error\n"+err.Error(), err, slog.String("admin_username", aui.Username))
+		return
+	}
+
+	if err := app.admRenderTemplate(w, r, "teachers", struct {
+		Teachers []db.GetTeachersRow
+		Courses  []db.GetCoursesRow
+	}{
+		Teachers: teachers,
+		Courses:  courses,
+	}, slog.String("admin_username", aui.Username)); err != nil {
+		app.respondHTTPError(r, w, http.StatusInternalServerError, "Internal Server Error\nfailed rendering template", err, slog.String("admin_username", aui.Username))
+	}
+}
+
+func (app *App) handleAdmTeachersNew(w http.ResponseWriter, r *http.Request, aui *UserInfoAdmin) {
+	app.logRequestStart(r, "handleAdmTeachersNew", slog.String("admin_username", aui.Username))
+	if r.Method != http.MethodPost {
+		app.respondHTTPError(r, w, http.StatusMethodNotAllowed, "Method Not Allowed", nil, slog.String("admin_username", aui.Username))
+		return
+	}
+
+	// Like those of administrators, teacher usernames are what the identity
+	// configuration maps the lowercased identity of staff to.
+	username := strings.ToLower(strings.TrimSpace(r.FormValue("username")))
+	if username == "" {
+		app.respondHTTPError(r, w, http.StatusBadRequest, "Bad Request\nYou are trying to add a teacher without a username, which is not allowed", nil, slog.String("admin_username", aui.Username))
+		return
+	}
+	name := strings.TrimSpace(r.FormValue("name"))
+
+	if err := app.queries.NewTeacher(r.Context(), db.NewTeacherParams{
+		Username: username,
+		Name:     name,
+	}); err != nil {
+		app.respondHTTPError(r, w, http.StatusInternalServerError, "Internal Server Error\n"+err.Error(), err, slog.String("admin_username", aui.Username), slog.String("username", username))
+		return
+	}
+
+	app.logInfo(r, logMsgAdminTeachersCreate, slog.String("admin_username", aui.Username), slog.String("username", username))
+	http.Redirect(w, r, "/admin/teachers", http.StatusSeeOther)
+}
+
+// handleAdmTeachersEdit changes the name of a teacher and replaces the
+// courses they are linked to.
+func (app *App) handleAdmTeachersEdit(w http.ResponseWriter, r *http.Request, aui *UserInfoAdmin) {
+	app.logRequestStart(r, "handleAdmTeachersEdit", slog.String("admin_username", aui.Username))
+	if r.Method != http.MethodPost {
+		app.respondHTTPError(r, w, http.StatusMethodNotAllowed, "Method Not Allowed", nil, slog.String("admin_username", aui.Username))
+		return
+	}
+	if err := r.ParseForm(); err != nil {
+		app.respondHTTPError(r, w, http.StatusBadRequest, "Bad Request\n"+err.Error(), err, slog.String("admin_username", aui.Username))
+		return
+	}
+
+	id, err := strconv.ParseInt(r.PostForm.Get("id"), 10, 64)
+	if err != nil {
+		app.respondHTTPError(r, w, http.StatusBadRequest, "Bad Request\nTeacher ID must be a number", err, slog.String("admin_username", aui.Username))
+		return
+	}
+	name := strings.TrimSpace(r.PostForm.Get("name"))
+	courseIDs := nonEmptyValues(r.PostForm["course_ids"])
+
+	tx, err := app.pool.Begin(r.Context())
+	if err != nil {
+		app.respondHTTPError(r, w, http.StatusInternalServerError, "Internal Server Error\n"+err.Error(), err, slog.String("admin_username", aui.Username))
+		return
+	}
+	defer func() {
+		_ = tx.Rollback(r.Context())
+	}()
+
+	qtx := app.queries.WithTx(tx)
+	n, err := qtx.UpdateTeacher(r.Context(), db.UpdateTeacherParams{
+		ID:   id,
+		Name: name,
+	})
+	if err != nil {
+		app.respondHTTPError(r, w, http.StatusInternalServerError, "Internal Server Error\n"+err.Error(), err, slog.String("admin_username", aui.Username), slog.Int64("teacher_id", id))
+		return
+	}
+	if n == 0 {
+		app.respondHTTPError(r, w, http.StatusNotFound, "Not Found\nNo such teacher", nil, slog.String("admin_username", aui.Username), slog.Int64("teacher_id", id))
+		return
+	}
+	if err := qtx.DeleteTeacherCourses(r.Context(), id); err != nil {
+		app.respondHTTPError(r, w, http.StatusInternalServerError, "Internal Server Error\n"+err.Error(), err, slog.String("admin_username", aui.Username), slog.Int64("teacher_id", id))
+		return
+	}
+	if err := qtx.AddTeacherCourses(r.Context(), db.AddTeacherCoursesParams{
+		TeacherID: id,
+		CourseIds: nonNil(courseIDs),
+	}); err != nil {
+		app.respondHTTPError(r, w, http.StatusInternalServerError, "Internal Server Error\n"+err.Error(), err, slog.String("admin_username", aui.Username), slog.Int64("teacher_id", id))
+		return
+	}
+	if err := tx.Commit(r.Context()); err != nil {
+		app.respondHTTPError(r, w, http.StatusInternalServerError, "Internal Server Error\n"+err.Error(), err, slog.String("admin_username", aui.Username))
+		return
+	}
+
+	app.logInfo(r, logMsgAdminTeachersUpdate, slog.String("admin_username", aui.Username), slog.Int64("teacher_id", id), slog.Any("course_ids", courseIDs))
+	http.Redirect(w, r, "/admin/teachers", http.StatusSeeOther)
+}
+
+func (app *App) handleAdmTeachersDelete(w http.ResponseWriter, r *http.Request, aui *UserInfoAdmin) {
+	app.logRequestStart(r, "handleAdmTeachersDelete", slog.String("admin_username", aui.Username))
+	if r.Method != http.MethodPost {
+		app.respondHTTPError(r, w, http.StatusMethodNotAllowed, "Method Not Allowed", nil, slog.String("admin_username", aui.Username))
+		return
+	}
+
+	id, err := strconv.ParseInt(r.FormValue("id"), 10, 64)
+	if err != nil {
+		app.respondHTTPError(r, w, http.StatusBadRequest, "Bad Request\nTeacher ID must be a number", err, slog.String("admin_username", aui.Username))
+		return
+	}
+
+	n, err := app.queries.DeleteTeacher(r.Context(), id)
+	if err != nil {
+		app.respondHTTPError(r, w, http.StatusInternalServerError, "Internal Server Error\n"+err.Error(), err, slog.String("admin_username", aui.Username), slog.Int64("teacher_id", id))
+		return
+	}
+	if n == 0 {
+		app.respondHTTPError(r, w, http.StatusNotFound, "Not Found\nNo such teacher", nil, slog.String("admin_username", aui.Username), slog.Int64("teacher_id", id))
+		return
+	}
+
+	app.logInfo(r, logMsgAdminTeachersDelete, slog.String("admin_username", aui.Username), slog.Int64("teacher_id", id))
+	http.Redirect(w, r, "/admin/teachers", http.StatusSeeOther)
+}
@@ -0,0 +1,187 @@
+package main
+
+import (
+	"crypto/rand"
+	"errors"
+	"log/slog"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgtype"
+
+	"git.sr.ht/~runxiyu/cca/db"
+)
+
+var (
+	errAPITokenNoName    = errors.New("give the token a name, so you know what it is for when you see it listed")
+	errAPITokenNoScopes  = errors.New("select at least one scope")
+	errAPITokenScope     = errors.New("your role does not allow one of the selected scopes")
+	errAPITokenLifetime  = errors.New("tokens must expire after 1 to " + strconv.Itoa(apiTokenMaxDays) + " days")
+	errAPITokenWithToken = errors.New("API tokens cannot be managed with an API token; log in instead")
+)
+
+// apiTokenForm is what the form for new tokens on the tokens page submits.
+type apiTokenForm struct {
+	name   string
+	scopes []string
+	days   int
+}
+
+func parseAPITokenForm(r *http.Request, aui *UserInfoAdmin) (apiTokenForm, error) {
+	if err := r.ParseForm(); err != nil {
+		return apiTokenForm{}, err
+	}
+	form := apiTokenForm{
+		name: strings.TrimSpace(r.PostForm.Get("name")),
+	}
+	if form.name == "" {
+		return form, errAPITokenNoName
+	}
+	for _, scope := range apiTokenScopes {
+		if !slices.Contains(r.PostForm["scopes"], scope.Name) {
+			continue
+		}
+		if !aui.can(scope.Permission) {
+			return form, errAPITokenScope
+		}
+		form.scopes = append(form.scopes, scope.Name)
+	}
+	if len(form.scopes) == 0 {
+		return form, errAPITokenNoScopes
+	}
+	days, err := strconv.Atoi(strings.TrimSpace(r.PostForm.Get("days")))
+	if err != nil || days < 1 || days > apiTokenMaxDays {
+		return form, errAPITokenLifetime
+	}
+	form.days = days
+	return form, nil
+}
+
+// apiTokenOwnerFilter limits listing and revoking to the administrator's
+// own tokens, unless their role may manage accounts.
+func apiTokenOwnerFilter(aui *UserInfoAdmin) pgtype.Int8 {
+	if aui.can(adminPermAccounts) {
+		return pgtype.Int8{}
+	}
+	return pgtype.Int8{Int64: aui.ID, Valid: true}
+}
+
+func (app *App) handleAdmTokens(w http.ResponseWriter, r *http.Request, aui *UserInfoAdmin) {
+	app.logRequestStart(r, "handleAdmTokens", slog.String("admin_username", aui.Username))
+	if r.Method != http.MethodGet {
+		app.respondHTTPError(r, w, http.StatusMethodNotAllowed, "Method Not Allowed", nil, slog.String("admin_username", aui.Username))
+		return
+	}
+
+	app.renderAdmTokens(w, r, aui, "")
+}
+
+// renderAdmTokens renders the tokens page, with a token that was just
+// created if created is not empty. The token is shown only this once, as
+// only its hash is stored.
+func (app *App) renderAdmTokens(w http.ResponseWriter, r *http.Request, aui *UserInfoAdmin, created string) {
+	tokens, err := app.queries.GetAPITokens(r.Context(), apiTokenOwnerFilter(aui))
+	if err != nil {
+		app.respondHTTPError(r, w, http.StatusInternalServerError, "Internal Server Error\n"+err.Error(), err, slog.String("admin_username", aui.Username))
+		return
+	}
+
+	var scopes []apiTokenScope
+	for _, scope := range apiTokenScopes {
+		if aui.can(scope.Permission) {
+			scopes = append(scopes, scope)
+		}
+	}
+
+	if err := app.admRenderTemplate(w, r, "tokens", struct {
+		Tokens      []db.GetAPITokensRow
+		Scopes      []apiTokenScope
+		Created     string
+		AllAdmins   bool
+		DefaultDays int
+		MaxDays     int
+	}{
+		Tokens:      tokens,
+		Scopes:      scopes,
+		Created:     created,
+		AllAdmins:   aui.can(adminPermAccounts),
+		DefaultDays: apiTokenDefaultDays,
+		MaxDays:     apiTokenMaxDays,
+	}, slog.String("admin_username", aui.Username)); err != nil {
+		app.respondHTTPError(r, w, http.StatusInternalServerError, "Internal Server Error\nfailed rendering template", err, slog.String("admin_username", aui.Username))
+	}
+}
+
+// handleAdmTokensNew creates a token for the administrator and shows it on
+// the tokens page instead of redirecting, since it cannot be shown again.
+func (app *App) handleAdmTokensNew(w http.ResponseWriter, r *http.Request, aui *UserInfoAdmin) {
+	app.logRequestStart(r, "handleAdmTokensNew", slog.String("admin_username", aui.Username))
+	if r.Method != http.MethodPost {
+		app.respondHTTPError(r, w, http.StatusMethodNotAllowed, "Method Not Allowed", nil, slog.String("admin_username", aui.Username))
+		return
+	}
+	if aui.APIToken != nil {
+		app.respondHTTPError(r, w, http.StatusForbidden, "Forbidden\n"+errAPITokenWithToken.Error(), nil, slog.String("admin_username", aui.Username))
+		return
+	}
+
+	form, err := parseAPITokenForm(r, aui)
+	if err != nil {
+		app.respondHTTPError(r, w, http.StatusBadRequest, "Bad Request\n"+err.Error(), err, slog.String("admin_username", aui.Username))
+		return
+	}
+
+	token := apiTokenPrefix + rand.Text()
+	expires := time.Now().AddDate(0, 0, form.days)
+	id, err := app.queries.NewAPIToken(r.Context(), db.NewAPITokenParams{
+		AdminID:   aui.ID,
+		Name:      form.name,
+		TokenHash: app.hashSessionToken(token),
+		Scopes:    form.scopes,
+		ExpiresAt: pgtype.Timestamptz{Time: expires, Valid: true},
+	})
+	if err != nil {
+		app.respondHTTPError(r, w, http.StatusInternalServerError, "Internal Server Error\n"+err.Error(), err, slog.String("admin_username", aui.Username))
+		return
+	}
+	app.logInfo(r, logMsgAdminAPITokensCreate, slog.String("admin_username", aui.Username), slog.Int64("api_token_id", id), slog.String("name", form.name), slog.Any("scopes", form.scopes), slog.Time("expires_at", expires))
+
+	w.Header().Set("Cache-Control", "no-store")
+	app.renderAdmTokens(w, r, aui, token)
+}
+
+func (app *App) handleAdmTokensRevoke(w http.ResponseWriter, r *http.Request, aui *UserInfoAdmin) {
+	app.logRequestStart(r, "handleAdmTokensRevoke", slog.String("admin_username", aui.Username))
+	if r.Method != http.MethodPost {
+		app.respondHTTPError(r, w, http.StatusMethodNotAllowed, "Method Not Allowed", nil, slog.String("admin_username", aui.Username))
+		return
+	}
+	if aui.APIToken != nil {
+		app.respondHTTPError(r, w, http.StatusForbidden, "Forbidden\n"+errAPITokenWithToken.Error(), nil, slog.String("admin_username", aui.Username))
+		return
+	}
+
+	id, err := strconv.ParseInt(r.FormValue("id"), 10, 64)
+	if err != nil {
+		app.respondHTTPError(r, w, http.StatusBadRequest, "Bad Request\nInvalid token ID", err, slog.String("admin_username", aui.Username))
+		return
+	}
+	n, err := app.queries.DeleteAPIToken(r.Context(), db.DeleteAPITokenParams{
+		ID:      id,
+		AdminID: apiTokenOwnerFilter(aui),
+	})
+	if err != nil {
+		app.respondHTTPError(r, w, http.StatusInternalServerError, "Internal Server Error\n"+err.Error(), err, slog.String("admin_username", aui.Username), slog.Int64("api_token_id", id))
+		return
+	}
+	if n == 0 {
+		app.respondHTTPError(r, w, http.StatusNotFound, "Not Found\nNo such token, or it is not yours", nil, slog.String("admin_username", aui.Username), slog.Int64("api_token_id", id))
+		return
+	}
+	app.logInfo(r, logMsgAdminAPITokensRevoke, slog.String("admin_username", aui.Username), slog.Int64("api_token_id", id))
+
+	http.Redirect(w, r, "/admin/tokens", http.StatusSeeOther)
+}
@@ -1,41 +1,46 @@
 package main
 
 import (
-	"crypto/rand"
 	"errors"
 	"log/slog"
 	"net/http"
-	"strconv"
-	"strings"
-	"time"
 
-	"git.sr.ht/~runxiyu/cca/db"
 	"github.com/golang-jwt/jwt/v5"
 	"github.com/jackc/pgx/v5"
-	"github.com/jackc/pgx/v5/pgtype"
 )
 
 type Claims struct {
 	Name  string `json:"name"`
 	Email string `json:"email"`
+	Nonce string `json:"nonce"`
 	jwt.RegisteredClaims
+	// all holds every claim, including those above.
+	all map[string]any
 }
 
 func (app *App) handleAuth(w http.ResponseWriter, r *http.Request) {
 	app.logRequestStart(r, "handleAuth")
-	if r.Method != http.MethodPost {
+	if r.Method != http.MethodGet {
 		app.respondHTTPError(r, w, http.StatusMethodNotAllowed, "Method Not Allowed", nil)
 		return
 	}
-
-	err := r.ParseForm()
-	if err != nil {
-		app.respondHTTPError(r, w, http.StatusBadRequest, "Bad Request\nMalformed form", err)
+	if app.kf == nil {
+		app.respondHTTPError(r, w, http.StatusNotFound, "Not Found\nLogging in with an identity provider is not configured", nil)
 		return
 	}
+	app.handleAuthCallback(w, r)
+}
 
-	if e := r.PostFormValue("error"); e != "" {
-		ed := r.PostFormValue("error_description")
+// handleAuthCallback completes the authorization code flow started by
+// handleIndex. The code is only redeemed if the state matches the pre-auth
+// cookie, and the ID token is only accepted if its nonce does.
+func (app *App) handleAuthCallback(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	preAuth, preAuthErr := oidcPreAuthFromRequest(r)
+	clearOIDCPreAuth(w)
+
+	if e := q.Get("error"); e != "" {
+		ed := q.Get("error_description")
 		app.respondHTTPError(
 			r,
 			w,
@@ -48,77 +53,38 @@ func (app *App) handleAuth(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if app.config.OIDC.Bypass && r.PostFormValue("bypass") != "" {
-		sid, err := strconv.ParseInt(strings.TrimLeft(r.PostFormValue("bypass"), "sS"), 10, 64)
-		if err != nil {
-			app.respondHTTPError(r, w, http.StatusUnauthorized, "Unauthorized\nInvalid student ID", nil)
-		}
-		st := rand.Text()
-		tst := "student:" + st
-		cookie := http.Cookie{
-			Name:     "session",
-			Value:    tst,
-			SameSite: http.SameSiteLaxMode,
-			HttpOnly: true,
-			Secure:   true,
-			Expires:  time.Now().Add(72 * time.Hour),
-		}
-		http.SetCookie(w, &cookie)
-		_, err = app.queries.SetStudentSession(
-			r.Context(),
-			db.SetStudentSessionParams{
-				SessionToken: pgtype.Text{String: st, Valid: true},
-				ID:           sid,
-			},
-		)
-		if err != nil {
-			if errors.Is(err, pgx.ErrNoRows) {
-				app.respondHTTPError(
-					r,
-					w,
-					http.StatusUnauthorized,
-					"Unauthorized\nStudent ID not found in database",
-					err,
-					slog.Int64("student_id", sid),
-				)
-				return
-			}
-			app.respondHTTPError(
-				r,
-				w,
-				http.StatusInternalServerError,
-				"Internal Server Error\nCannot set student session token",
-				err,
-				slog.Int64("student_id", sid),
-			)
-			return
-		}
-
-		app.logInfo(r, logMsgAuthStudentBypass, slog.Int64("student_id", sid))
-		http.Redirect(w, r, "/student/", http.StatusSeeOther)
-
+	if preAuthErr != nil {
+		app.respondHTTPError(r, w, http.StatusBadRequest, "Bad Request\nLogin expired or started in another browser; please log in again", preAuthErr)
+		return
+	}
+	if !equalConstantTime(q.Get("state"), preAuth.State) {
+		app.respondHTTPError(r, w, http.StatusBadRequest, "Bad Request\nState mismatch; please log in again", nil)
 		return
 	}
 
-	idts := r.PostFormValue("id_token")
-	if idts == "" {
-		app.respondHTTPError(r, w, http.StatusBadRequest, "Bad Request\nID token expected but not found", nil)
+	code := q.Get("code")
+	if code == "" {
+		app.respondHTTPError(r, w, http.StatusBadRequest, "Bad Request\nAuthorization code expected but not found", nil)
 		return
 	}
 
-	idt, err := jwt.ParseWithClaims(idts, &Claims{}, app.kf.Keyfunc)
+	idts, err := app.exchangeOIDCCode(r.Context(), code, requestAbsoluteURL(r, "/auth"), preAuth.Verifier)
 	if err != nil {
-		app.respondHTTPError(r, w, http.StatusBadRequest, "Bad Request\nUnparsable JWT", err)
+		app.respondHTTPError(r, w, http.StatusBadGateway, "Bad Gateway\nCannot redeem authorization code", err)
 		return
 	}
 
-	claims, ok := idt.Claims.(*Claims)
+	idt, err := jwt.ParseWithClaims(
+		idts,
+		&Claims{},
+		app.kf.Keyfunc,
+		jwt.WithIssuer(app.config.OIDC.Issuer),
+		jwt.WithAudience(app.config.OIDC.Client),
+		jwt.WithExpirationRequired(),
+	)
 
 	switch {
-	case !ok:
-		app.respondHTTPError(r, w, http.StatusBadRequest, "Bad Request\nInvalid JWT claims", nil)
-		return
-	case idt.Valid:
+	case err == nil:
 		break
 	case errors.Is(err, jwt.ErrTokenMalformed):
 		app.respondHTTPError(r, w, http.StatusBadRequest, "Bad Request\nMalformed JWT", err)
@@ -132,116 +98,129 @@ func (app *App) handleAuth(w http.ResponseWriter, r *http.Request) {
 	case errors.Is(err, jwt.ErrTokenNotValidYet):
 		app.respondHTTPError(r, w, http.StatusBadRequest, "Bad Request\nJWT not valid yet", err)
 		return
+	case errors.Is(err, jwt.ErrTokenInvalidIssuer):
+		app.respondHTTPError(r, w, http.StatusBadRequest, "Bad Request\nJWT issued by an unexpected issuer", err)
+		return
+	case errors.Is(err, jwt.ErrTokenInvalidAudience):
+		app.respondHTTPError(r, w, http.StatusBadRequest, "Bad Request\nJWT issued for another client", err)
+		return
 	default:
 		app.respondHTTPError(r, w, http.StatusBadRequest, "Bad Request\nInvalid JWT", err)
 		return
 	}
 
-	claims.Email = strings.ToLower(claims.Email)
-	lp, dp, ok := strings.Cut(claims.Email, "@")
-	if !ok {
-		app.respondHTTPError(r, w, http.StatusBadRequest, "Bad Request\nInvalid email address", nil)
+	claims, ok := idt.Claims.(*Claims)
+	if !ok || !idt.Valid {
+		app.respondHTTPError(r, w, http.StatusBadRequest, "Bad Request\nInvalid JWT claims", nil)
+		return
+	}
+
+	if !equalConstantTime(claims.Nonce, preAuth.Nonce) {
+		app.respondHTTPError(r, w, http.StatusBadRequest, "Bad Request\nNonce mismatch; please log in again", nil)
+		return
+	}
+
+	identity, err := app.identity.identityFromClaims(claims)
+	if err != nil {
+		app.respondHTTPError(r, w, http.StatusBadRequest, "Bad Request\n"+err.Error(), err)
 		return
 	}
-	if dp != "ykpaoschool.cn" && dp != "stu.ykpaoschool.cn" {
+	mapped, err := app.identity.mapIdentity(identity)
+	if err != nil {
 		app.respondHTTPError(
 			r,
 			w,
 			http.StatusUnauthorized,
-			"Unauthorized\nInvalid email address domain-part",
-			nil,
-			slog.String("domain", dp),
-			slog.String("email", claims.Email),
+			"Unauthorized\nYour account is not in a domain allowed to log in",
+			err,
+			slog.String("identity", identity),
 		)
 		return
 	}
 
-	_, isAdmin := app.config.Admins[lp]
-
-	st := rand.Text()
-	tst := ""
-	if isAdmin {
-		tst = "admin:" + st
-	} else {
-		tst = "student:" + st
-	}
+	app.startMappedSession(w, r, identity, mapped)
+}
 
-	cookie := http.Cookie{
-		Name:     "session",
-		Value:    tst,
-		SameSite: http.SameSiteLaxMode,
-		HttpOnly: true,
-		Secure:   true,
-		Expires:  time.Now().Add(72 * time.Hour),
-	}
-	http.SetCookie(w, &cookie)
-
-	if isAdmin {
-		err = app.queries.SetAdminSession(
-			r.Context(),
-			db.SetAdminSessionParams{
-				SessionToken: pgtype.Text{String: st, Valid: true},
-				Username:     lp,
-			},
-		)
-		if err != nil {
+// startMappedSession logs in as what an identity maps to and redirects to the
+// pages of that kind of user. The attributes are added to the login logs.
+func (app *App) startMappedSession(w http.ResponseWriter, r *http.Request, identity string, mapped mappedIdentity, extra ...slog.Attr) {
+	var err error
+	// Staff usernames in the admins table log in as administrators, and
+	// those in the teachers table as teachers; everyone else must be a
+	// student.
+	if mapped.StaffUsername != "" {
+		err = app.startAdminSession(w, r, mapped.StaffUsername)
+		if err == nil {
+			app.logInfo(r, logMsgAuthAdminLogin, append(extra, slog.String("admin_username", mapped.StaffUsername))...)
+			http.Redirect(w, r, "/admin/", http.StatusSeeOther)
+			return
+		}
+		if !errors.Is(err, pgx.ErrNoRows) {
 			app.respondHTTPError(
 				r,
 				w,
 				http.StatusInternalServerError,
-				"Internal Server Error\nCannot set admin session token",
+				"Internal Server Error\nCannot create admin session",
 				err,
-				slog.String("admin_username", lp),
+				slog.String("admin_username", mapped.StaffUsername),
 			)
 			return
 		}
 
-		app.logInfo(r, logMsgAuthAdminLogin, slog.String("admin_username", lp))
-		http.Redirect(w, r, "/admin/", http.StatusSeeOther)
-	} else {
-		sid, err := strconv.ParseInt(strings.TrimLeft(lp, "sS"), 10, 64)
-		if err != nil {
+		err = app.startTeacherSession(w, r, mapped.StaffUsername)
+		if err == nil {
+			app.logInfo(r, logMsgAuthTeacherLogin, append(extra, slog.String("teacher_username", mapped.StaffUsername))...)
+			http.Redirect(w, r, "/teacher/", http.StatusSeeOther)
+			return
+		}
+		if !errors.Is(err, pgx.ErrNoRows) {
 			app.respondHTTPError(
 				r,
 				w,
-				http.StatusUnauthorized,
-				"Unauthorized\nInvalid student ID",
+				http.StatusInternalServerError,
+				"Internal Server Error\nCannot create teacher session",
 				err,
-				slog.String("label", lp),
+				slog.String("teacher_username", mapped.StaffUsername),
 			)
 			return
 		}
-		_, err = app.queries.SetStudentSession(
-			r.Context(),
-			db.SetStudentSessionParams{
-				SessionToken: pgtype.Text{String: st, Valid: true},
-				ID:           sid,
-			},
+	}
+
+	if !mapped.IsStudent {
+		app.respondHTTPError(
+			r,
+			w,
+			http.StatusUnauthorized,
+			"Unauthorized\nYour account is not that of a student, teacher or administrator",
+			nil,
+			slog.String("identity", identity),
 		)
-		if err != nil {
-			if errors.Is(err, pgx.ErrNoRows) {
-				app.respondHTTPError(
-					r,
-					w,
-					http.StatusUnauthorized,
-					"Unauthorized\nStudent ID not found in database",
-					err,
-					slog.Int64("student_id", sid),
-				)
-				return
-			}
+		return
+	}
+	err = app.startStudentSession(w, r, mapped.StudentID)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
 			app.respondHTTPError(
 				r,
 				w,
-				http.StatusInternalServerError,
-				"Internal Server Error\nCannot set student session token",
+				http.StatusUnauthorized,
+				"Unauthorized\nStudent ID not found in database",
 				err,
-				slog.Int64("student_id", sid),
+				slog.Int64("student_id", mapped.StudentID),
 			)
 			return
 		}
-
-		app.logInfo(r, logMsgAuthStudentLogin, slog.Int64("student_id", sid))
-		http.Redirect(w, r, "/student/", http.StatusSeeOther)
+		app.respondHTTPError(
+			r,
+			w,
+			http.StatusInternalServerError,
+			"Internal Server Error\nCannot create student session",
+			err,
+			slog.Int64("student_id", mapped.StudentID),
+		)
+		return
 	}
+
+	app.logInfo(r, logMsgAuthStudentLogin, append(extra, slog.Int64("student_id", mapped.StudentID))...)
+	http.Redirect(w, r, "/student/", http.StatusSeeOther)
 }
@@ -0,0 +1,277 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/MicahParks/keyfunc/v3"
+	"github.com/golang-jwt/jwt/v5"
+)
+
+const (
+	testIdPClient = "cca-test"
+	testIdPKeyID  = "test"
+)
+
+// testIdP is an identity provider that answers every authorization code
+// with the ID token of whatever claims the test sets, and records the token
+// requests it receives.
+type testIdP struct {
+	server *httptest.Server
+	key    *rsa.PrivateKey
+
+	mu       sync.Mutex
+	claims   jwt.MapClaims
+	signer   *rsa.PrivateKey
+	requests []url.Values
+}
+
+func newTestIdP(t *testing.T) *testIdP {
+	t.Helper()
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := &testIdP{key: key, signer: key}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, _ *http.Request) {
+		_ = json.NewEncoder(w).Encode(oidcProvider{
+			Issuer:                p.server.URL,
+			AuthorizationEndpoint: p.server.URL + "/authorize",
+			TokenEndpoint:         p.server.URL + "/token",
+			JWKSURI:               p.server.URL + "/jwks",
+			CodeChallengeMethods:  []string{"S256"},
+		})
+	})
+	mux.HandleFunc("/jwks", func(w http.ResponseWriter, _ *http.Request) {
+		_ = json.NewEncoder(w).Encode(map[string]any{"keys": []map[string]string{{
+			"kty": "RSA",
+			"use": "sig",
+			"alg": "RS256",
+			"kid": testIdPKeyID,
+			"n":   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+			"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+		}}})
+	})
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		if err := r.ParseForm(); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		p.mu.Lock()
+		p.requests = append(p.requests, r.PostForm)
+		token := jwt.NewWithClaims(jwt.SigningMethodRS256, p.claims)
+		token.Header["kid"] = testIdPKeyID
+		idt, err := token.SignedString(p.signer)
+		p.mu.Unlock()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		_ = json.NewEncoder(w).Encode(map[string]string{"id_token": idt})
+	})
+	p.server = httptest.NewServer(mux)
+	t.Cleanup(p.server.Close)
+	return p
+}
+
+// validClaims returns the claims of an ID token that cca accepts for a
+// login with preAuth.
+func (p *testIdP) validClaims(preAuth oidcPreAuth) jwt.MapClaims {
+	return jwt.MapClaims{
+		"iss":            p.server.URL,
+		"aud":            testIdPClient,
+		"sub":            "1",
+		"exp":            time.Now().Add(time.Hour).Unix(),
+		"iat":            time.Now().Unix(),
+		"nonce":          preAuth.Nonce,
+		"email":          "someone@elsewhere.example",
+		"email_verified": true,
+	}
+}
+
+func newTestOIDCApp(t *testing.T, p *testIdP) *App {
+	t.Helper()
+	app := &App{}
+	app.config.OIDC.Client = testIdPClient
+	app.config.OIDC.Issuer = p.server.URL
+	app.config.Identity.Claim = "email"
+	app.config.Identity.Domains = []string{"ykpaoschool.cn"}
+	var err error
+	if app.identity, err = newIdentityMapper(app.config); err != nil {
+		t.Fatal(err)
+	}
+	if app.oidc, err = discoverOIDC(t.Context(), p.server.URL); err != nil {
+		t.Fatal(err)
+	}
+	if app.kf, err = keyfunc.NewDefaultCtx(t.Context(), []string{app.oidc.JWKSURI}); err != nil {
+		t.Fatal(err)
+	}
+	return app
+}
+
+func TestHandleAuthCallback(t *testing.T) {
+	p := newTestIdP(t)
+	app := newTestOIDCApp(t, p)
+	other, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name string
+		// edit changes the callback query or the ID token of an otherwise
+		// valid login.
+		edit          func(q url.Values, claims jwt.MapClaims)
+		signer        *rsa.PrivateKey
+		noCookie      bool
+		wantStatus    int
+		wantBody      string
+		wantRedeemed  bool
+		wantNoRequest bool
+	}{
+		{
+			name:         "accepted",
+			wantStatus:   http.StatusUnauthorized,
+			wantBody:     "not in a domain allowed",
+			wantRedeemed: true,
+		},
+		{
+			name:          "bad state",
+			edit:          func(q url.Values, _ jwt.MapClaims) { q.Set("state", "forged") },
+			wantStatus:    http.StatusBadRequest,
+			wantBody:      "State mismatch",
+			wantNoRequest: true,
+		},
+		{
+			name:          "replayed callback without the cookie",
+			noCookie:      true,
+			wantStatus:    http.StatusBadRequest,
+			wantBody:      "Login expired",
+			wantNoRequest: true,
+		},
+		{
+			name:         "nonce mismatch",
+			edit:         func(_ url.Values, c jwt.MapClaims) { c["nonce"] = "replayed" },
+			wantStatus:   http.StatusBadRequest,
+			wantBody:     "Nonce mismatch",
+			wantRedeemed: true,
+		},
+		{
+			name:         "wrong audience",
+			edit:         func(_ url.Values, c jwt.MapClaims) { c["aud"] = "another-client" },
+			wantStatus:   http.StatusBadRequest,
+			wantBody:     "issued for another client",
+			wantRedeemed: true,
+		},
+		{
+			name:         "wrong issuer",
+			edit:         func(_ url.Values, c jwt.MapClaims) { c["iss"] = "https://evil.example" },
+			wantStatus:   http.StatusBadRequest,
+			wantBody:     "unexpected issuer",
+			wantRedeemed: true,
+		},
+		{
+			name:         "expired",
+			edit:         func(_ url.Values, c jwt.MapClaims) { c["exp"] = time.Now().Add(-time.Hour).Unix() },
+			wantStatus:   http.StatusBadRequest,
+			wantBody:     "JWT expired",
+			wantRedeemed: true,
+		},
+		{
+			name:         "no expiry",
+			edit:         func(_ url.Values, c jwt.MapClaims) { delete(c, "exp") },
+			wantStatus:   http.StatusBadRequest,
+			wantRedeemed: true,
+		},
+		{
+			name:         "unverified email",
+			edit:         func(_ url.Values, c jwt.MapClaims) { c["email_verified"] = false },
+			wantStatus:   http.StatusBadRequest,
+			wantBody:     "not verified",
+			wantRedeemed: true,
+		},
+		{
+			name:         "signed by another key",
+			signer:       other,
+			wantStatus:   http.StatusBadRequest,
+			wantBody:     "Invalid JWT signature",
+			wantRedeemed: true,
+		},
+		{
+			name:          "provider error",
+			edit:          func(q url.Values, _ jwt.MapClaims) { q.Set("error", "access_denied") },
+			wantStatus:    http.StatusBadRequest,
+			wantBody:      "access_denied",
+			wantNoRequest: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			preAuth := newOIDCPreAuth()
+			claims := p.validClaims(preAuth)
+			q := url.Values{"state": {preAuth.State}, "code": {"the-code"}}
+			if tt.edit != nil {
+				tt.edit(q, claims)
+			}
+			p.mu.Lock()
+			p.claims = claims
+			p.signer = p.key
+			if tt.signer != nil {
+				p.signer = tt.signer
+			}
+			p.requests = nil
+			p.mu.Unlock()
+
+			r := httptest.NewRequest(http.MethodGet, "https://cca.example/auth?"+q.Encode(), nil)
+			if !tt.noCookie {
+				r.AddCookie(preAuth.cookie())
+			}
+			w := httptest.NewRecorder()
+			app.handleAuth(w, r)
+
+			if w.Code != tt.wantStatus || !strings.Contains(w.Body.String(), tt.wantBody) {
+				t.Fatalf("got %d %q, want %d containing %q", w.Code, w.Body.String(), tt.wantStatus, tt.wantBody)
+			}
+			cleared := false
+			for _, c := range w.Result().Cookies() {
+				if c.Name == oidcPreAuthCookie && c.MaxAge < 0 {
+					cleared = true
+				}
+			}
+			if !cleared {
+				t.Error("pre-auth cookie not cleared, so the login could be completed again")
+			}
+
+			p.mu.Lock()
+			requests := p.requests
+			p.mu.Unlock()
+			if tt.wantNoRequest && len(requests) != 0 {
+				t.Fatalf("code redeemed %d times, want none", len(requests))
+			}
+			if tt.wantRedeemed {
+				if len(requests) != 1 {
+					t.Fatalf("code redeemed %d times, want once", len(requests))
+				}
+				form := requests[0]
+				if form.Get("code") != "the-code" || form.Get("client_id") != testIdPClient || form.Get("redirect_uri") != "https://cca.example/auth" {
+					t.Errorf("token request %v", form)
+				}
+				if form.Get("code_verifier") != preAuth.Verifier {
+					t.Errorf("code_verifier = %q, want the one from the pre-auth cookie", form.Get("code_verifier"))
+				}
+			}
+		})
+	}
+}
@@ -1,18 +1,21 @@
 package main
 
 import (
-	"crypto/rand"
 	"log/slog"
 	"net/http"
-	"net/url"
 )
 
 func (app *App) handleIndex(w http.ResponseWriter, r *http.Request) {
 	app.logRequestStart(r, "handleIndex")
 	// TODO: Consider rendering a welcome and login page.
+	if app.kf == nil {
+		http.Redirect(w, r, "/auth/dev", http.StatusSeeOther)
+		return
+	}
 	redirectURI := requestAbsoluteURL(r, "/auth")
 
-	target, err := buildOIDCAuthorizeURL(app.config.OIDC.Authorize, app.config.OIDC.Client, redirectURI)
+	preAuth := newOIDCPreAuth()
+	target, err := buildOIDCAuthorizeURL(app.oidc.AuthorizationEndpoint, app.config.OIDC.Client, redirectURI, app.config.OIDC.Scopes, preAuth)
 	if err != nil {
 		app.respondHTTPError(
 			r,
@@ -24,6 +27,7 @@ func (app *App) handleIndex(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	http.SetCookie(w, preAuth.cookie())
 	app.logInfo(r, logMsgAuthOIDCRedirect, slog.String("target", target))
 	http.Redirect(
 		w,
@@ -32,21 +36,3 @@ func (app *App) handleIndex(w http.ResponseWriter, r *http.Request) {
 		http.StatusSeeOther,
 	)
 }
-
-func buildOIDCAuthorizeURL(authorizeEndpoint, clientID, redirectURI string) (string, error) {
-	u, err := url.Parse(authorizeEndpoint)
-	if err != nil {
-		return "", err
-	}
-
-	q := u.Query()
-	q.Set("client_id", clientID)
-	q.Set("response_type", "id_token code")
-	q.Set("redirect_uri", redirectURI)
-	q.Set("response_mode", "form_post")
-	q.Set("scope", "openid profile email User.Read")
-	q.Set("nonce", rand.Text())
-
-	u.RawQuery = q.Encode()
-	return u.String(), nil
-}
@@ -0,0 +1,33 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+)
+
+// handleLogout ends the session of this browser, whether it is a student's
+// or an administrator's, along with any session in which the administrator
+// is viewing the site as a student. It does not log the user out of the
+// identity provider, so visiting / logs them in again without asking.
+func (app *App) handleLogout(w http.ResponseWriter, r *http.Request) {
+	app.logRequestStart(r, "handleLogout")
+	if r.Method != http.MethodPost {
+		app.respondHTTPError(r, w, http.StatusMethodNotAllowed, "Method Not Allowed", nil)
+		return
+	}
+
+	if cookie, err := r.Cookie(sessionCookie); err == nil {
+		if _, st, ok := strings.Cut(cookie.Value, ":"); ok {
+			if err := app.queries.DeleteSessionAndImpersonationsByTokenHash(r.Context(), app.hashSessionToken(st)); err != nil {
+				app.respondHTTPError(r, w, http.StatusInternalServerError, "Internal Server Error\nCannot delete session", err)
+				return
+			}
+		}
+	}
+	clearSessionCookie(w)
+	clearImpersonationCookie(w)
+
+	app.logInfo(r, logMsgAuthLogout)
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	_, _ = w.Write([]byte("Logged out\n"))
+}
@@ -0,0 +1,32 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestLogoutClearsCookies(t *testing.T) {
+	app := &App{}
+	r := httptest.NewRequest(http.MethodPost, "/logout", nil)
+	w := httptest.NewRecorder()
+	app.handleLogout(w, r)
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d", w.Code)
+	}
+
+	cleared := map[string]string{}
+	for _, c := range w.Result().Cookies() {
+		if c.MaxAge < 0 {
+			cleared[c.Name] = c.Path
+		}
+	}
+	// The impersonation cookie is only sent to /student, so it has to be
+	// cleared with that path.
+	want := map[string]string{sessionCookie: "/", impersonationCookie: "/student"}
+	for name, path := range want {
+		if got, ok := cleared[name]; !ok || got != path {
+			t.Errorf("cookie %s cleared with path %q (%v), want %q", name, got, ok, path)
+		}
+	}
+}
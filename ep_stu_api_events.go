@@ -2,13 +2,17 @@ package main
 
 import (
 	"context"
+	"errors"
 	"log/slog"
 	"net/http"
+	"strconv"
 
 	"github.com/coder/websocket"
 )
 
-var upgraderOpts = &websocket.AcceptOptions{}
+var upgraderOpts = &websocket.AcceptOptions{
+	Subprotocols: []string{wsSubprotocolV1},
+}
 
 func (app *App) handleStuAPIEvents(w http.ResponseWriter, r *http.Request, sui *UserInfoStudent) {
 	app.logRequestStart(r, "handleStuAPIEvents", slog.Int64("student_id", sui.ID))
@@ -17,30 +21,106 @@ func (app *App) handleStuAPIEvents(w http.ResponseWriter, r *http.Request, sui *
 		return
 	}
 
+	since, err := eventsSince(r)
+	if err != nil {
+		app.apiError(r, w, http.StatusBadRequest, err.Error(), slog.Int64("student_id", sui.ID))
+		return
+	}
+
 	conn, err := websocket.Accept(w, r, upgraderOpts)
 	if err != nil {
 		app.logError(r, logMsgStudentEventsUpgradeError, slog.Any("error", err))
 		return
 	}
 
-	client := &Client{
-		conn:      conn,
-		send:      make(chan WSMessage, 256),
-		hub:       app.wsHub,
-		studentID: sui.ID,
+	client := newClient(wsTransport{conn: conn}, conn.Subprotocol(), app.wsHub, sui.ID, sui.Grade)
+	if !app.startEventClient(r, client, since) {
+		_ = conn.Close(websocket.StatusInternalError, "")
+		return
 	}
 
-	app.wsHub.register <- client
+	go client.readPump()
+}
 
-	if err := conn.Write(context.Background(), websocket.MessageText, []byte("hello")); err != nil {
-		app.logError(r, logMsgStudentEventsHelloError, slog.Any("error", err))
-		app.wsHub.unregister <- client
-		_ = conn.Close(websocket.StatusInternalError, "")
+// handleStuAPIEventsSSE carries the same events as handleStuAPIEvents as a
+// text/event-stream, for networks where WebSocket upgrades fail. Events are
+// always JSON envelopes, as for the cca.v1 subprotocol.
+func (app *App) handleStuAPIEventsSSE(w http.ResponseWriter, r *http.Request, sui *UserInfoStudent) {
+	app.logRequestStart(r, "handleStuAPIEventsSSE", slog.Int64("student_id", sui.ID))
+	if r.Method != http.MethodGet {
+		app.apiError(r, w, http.StatusMethodNotAllowed, nil)
 		return
 	}
 
+	since, err := eventsSince(r)
+	if err != nil {
+		app.apiError(r, w, http.StatusBadRequest, err.Error(), slog.Int64("student_id", sui.ID))
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	// Ask nginx and similar proxies not to buffer the stream.
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+
+	transport := newSSETransport(w, r)
+	client := newClient(transport, wsSubprotocolV1, app.wsHub, sui.ID, sui.Grade)
+	if !app.startEventClient(r, client, since) {
+		transport.closeNow()
+		return
+	}
+
+	// The response writer is only valid until the handler returns.
+	client.readPump()
+}
+
+var errEventsSince = errors.New("since must be a non-negative integer")
+
+// eventsSince returns the ID of the last logged event the client saw before
+// it was disconnected, or -1 on the first connection. EventSource sends it
+// in Last-Event-ID by itself when it reconnects, which is then newer than
+// the since parameter in its URL.
+func eventsSince(r *http.Request) (int64, error) {
+	s := r.Header.Get("Last-Event-ID")
+	if s == "" {
+		s = r.URL.Query().Get("since")
+	}
+	if s == "" {
+		return -1, nil
+	}
+	since, err := strconv.ParseInt(s, 10, 64)
+	if err != nil || since < 0 {
+		return 0, errEventsSince
+	}
+	return since, nil
+}
+
+// startEventClient registers a client, sends it the hello message and any
+// logged events it missed, and starts writing and pinging. The caller runs
+// readPump.
+func (app *App) startEventClient(r *http.Request, client *Client, since int64) bool {
+	// Registering before reading the event log means no event can fall
+	// between the two; the client ignores any it then receives twice.
+	app.wsHub.register <- client
+
+	newest, missed, err := app.events.replay(r.Context(), client.studentID, since)
+	if err != nil {
+		app.logError(r, logMsgStudentEventsReplayError, slog.Any("error", err))
+		missed = []WSMessage{newWSMessage(WSResync{})}
+	}
+
+	for _, message := range append([]WSMessage{newWSMessage(WSHello{LastEventID: newest})}, missed...) {
+		if err := client.write(context.Background(), message); err != nil {
+			app.logError(r, logMsgStudentEventsHelloError, slog.Any("error", err))
+			app.wsHub.unregister <- client
+			return false
+		}
+	}
+
 	go client.writePump()
-	go client.readPump()
+	go client.pingPump()
 
-	app.logInfo(r, logMsgStudentEventsEstablished, slog.Int64("student_id", sui.ID))
+	app.logInfo(r, logMsgStudentEventsEstablished, slog.Int64("student_id", client.studentID), slog.String("protocol", client.protocol), slog.Int("replayed", len(missed)))
+	return true
 }
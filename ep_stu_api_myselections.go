@@ -26,6 +26,11 @@ func (app *App) handleStuAPIMySelections(w http.ResponseWriter, r *http.Request,
 			return
 		}
 	case http.MethodDelete:
+		release, ok := app.admitStudent(w, r, sui)
+		if !ok {
+			return
+		}
+		defer release()
 		var s string
 		err := json.NewDecoder(r.Body).Decode(&s)
 		if err != nil {
@@ -43,11 +48,15 @@ func (app *App) handleStuAPIMySelections(w http.ResponseWriter, r *http.Request,
 			return
 		}
 		app.logInfo(r, logMsgStudentSelectionsDelete, slog.Int64("student_id", sui.ID), slog.String("operation", "delete_selection"), slog.String("course_id", s))
-		app.broadcastCourseCounts(r, []string{s})
 		if get() {
 			return
 		}
 	case http.MethodPut:
+		release, ok := app.admitStudent(w, r, sui)
+		if !ok {
+			return
+		}
+		defer release()
 		var s string
 		err := json.NewDecoder(r.Body).Decode(&s)
 		if err != nil {
@@ -60,11 +69,11 @@ func (app *App) handleStuAPIMySelections(w http.ResponseWriter, r *http.Request,
 			PSelectionType: "normal",
 		})
 		if err != nil {
+			app.dashboard.RecordFailure(sui.ID, s, err)
 			app.apiError(r, w, http.StatusInternalServerError, err.Error(), slog.String("operation", "new_selection"), slog.Int64("student_id", sui.ID), slog.String("course_id", s))
 			return
 		}
 		app.logInfo(r, logMsgStudentSelectionsCreate, slog.Int64("student_id", sui.ID), slog.String("operation", "new_selection"), slog.String("course_id", s))
-		app.broadcastCourseCounts(r, []string{s})
 		if get() {
 			return
 		}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"encoding/json"
+	"log/slog"
+	"net/http"
+
+	"git.sr.ht/~runxiyu/cca/db"
+)
+
+// handleStuAPINotifications lists the student's notifications, newest
+// first, with read_at null for unread ones. Listing them is what records
+// that they were delivered, except while an administrator is viewing as the
+// student, who then only sees what has already reached the student.
+func (app *App) handleStuAPINotifications(w http.ResponseWriter, r *http.Request, sui *UserInfoStudent) {
+	app.logRequestStart(r, "handleStuAPINotifications", slog.Int64("student_id", sui.ID))
+	if r.Method != http.MethodGet {
+		app.apiError(r, w, http.StatusMethodNotAllowed, nil)
+		return
+	}
+
+	app.writeInbox(w, r, sui)
+}
+
+// handleStuAPINotificationsRead marks the notifications whose IDs are posted
+// as a JSON array as read, and responds with the updated inbox.
+func (app *App) handleStuAPINotificationsRead(w http.ResponseWriter, r *http.Request, sui *UserInfoStudent) {
+	app.logRequestStart(r, "handleStuAPINotificationsRead", slog.Int64("student_id", sui.ID))
+	if r.Method != http.MethodPost {
+		app.apiError(r, w, http.StatusMethodNotAllowed, nil)
+		return
+	}
+	// Read receipts are what administrators see of whether students have
+	// read a notification, so administrators viewing the site as the
+	// student may not record them, even read-write.
+	if sui.Impersonation != nil {
+		app.apiError(r, w, http.StatusForbidden, "administrators may not mark notifications as read for students", slog.Int64("student_id", sui.ID))
+		return
+	}
+
+	var ids []int64
+	if err := json.NewDecoder(r.Body).Decode(&ids); err != nil {
+		app.apiError(r, w, http.StatusBadRequest, err, slog.Int64("student_id", sui.ID))
+		return
+	}
+
+	if err := app.queries.MarkNotificationsRead(r.Context(), db.MarkNotificationsReadParams{
+		StudentID:       sui.ID,
+		NotificationIds: ids,
+	}); err != nil {
+		app.apiError(r, w, http.StatusInternalServerError, err.Error(), slog.Int64("student_id", sui.ID))
+		return
+	}
+	app.logInfo(r, logMsgStudentNotificationsRead, slog.Int64("student_id", sui.ID), slog.Int("count", len(ids)))
+
+	app.writeInbox(w, r, sui)
+}
+
+func (app *App) writeInbox(w http.ResponseWriter, r *http.Request, sui *UserInfoStudent) {
+	if sui.Impersonation == nil {
+		if err := app.queries.RecordNotificationsDelivered(r.Context(), sui.ID); err != nil {
+			app.apiError(r, w, http.StatusInternalServerError, err.Error(), slog.Int64("student_id", sui.ID))
+			return
+		}
+	}
+
+	notifications, err := app.queries.GetNotificationsByStudent(r.Context(), sui.ID)
+	if err != nil {
+		app.apiError(r, w, http.StatusInternalServerError, err.Error(), slog.Int64("student_id", sui.ID))
+		return
+	}
+
+	app.writeJSON(r, w, http.StatusOK, notifications, slog.String("resource", "notifications"), slog.Int64("student_id", sui.ID))
+}
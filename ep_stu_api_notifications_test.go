@@ -0,0 +1,25 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// TestNotificationsReadWhileImpersonating checks that administrators cannot
+// record read receipts for a student, which is rejected before the database
+// is used.
+func TestNotificationsReadWhileImpersonating(t *testing.T) {
+	app := &App{}
+	for _, readOnly := range []bool{true, false} {
+		r := httptest.NewRequest(http.MethodPost, "/student/api/notifications/read", strings.NewReader(`[1, 2]`))
+		w := httptest.NewRecorder()
+		app.handleStuAPINotificationsRead(w, r, &UserInfoStudent{
+			Impersonation: &studentImpersonation{Admin: "admin", ReadOnly: readOnly},
+		})
+		if w.Code != http.StatusForbidden {
+			t.Errorf("read-only %v: status = %d, want %d", readOnly, w.Code, http.StatusForbidden)
+		}
+	}
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"encoding/json"
+	"log/slog"
+	"net/http"
+
+	"github.com/jackc/pgx/v5/pgtype"
+
+	"git.sr.ht/~runxiyu/cca/db"
+)
+
+type studentSession struct {
+	db.GetSessionsByStudentRow
+	// Current is set for the session the list was requested with.
+	Current bool `json:"current"`
+}
+
+// handleStuAPISessions lists the sessions the student is logged in with,
+// most recently used first.
+func (app *App) handleStuAPISessions(w http.ResponseWriter, r *http.Request, sui *UserInfoStudent) {
+	app.logRequestStart(r, "handleStuAPISessions", slog.Int64("student_id", sui.ID))
+	if r.Method != http.MethodGet {
+		app.apiError(r, w, http.StatusMethodNotAllowed, nil)
+		return
+	}
+
+	app.writeStudentSessions(w, r, sui)
+}
+
+// handleStuAPISessionsRevoke ends the student's sessions whose IDs are
+// posted as a JSON array, and responds with the remaining ones. Sessions of
+// other students are ignored.
+func (app *App) handleStuAPISessionsRevoke(w http.ResponseWriter, r *http.Request, sui *UserInfoStudent) {
+	app.logRequestStart(r, "handleStuAPISessionsRevoke", slog.Int64("student_id", sui.ID))
+	if r.Method != http.MethodPost {
+		app.apiError(r, w, http.StatusMethodNotAllowed, nil)
+		return
+	}
+	// Administrators viewing the site as the student may change their
+	// selections but not log them out.
+	if sui.Impersonation != nil {
+		app.apiError(r, w, http.StatusForbidden, "administrators may not end the sessions of students", slog.Int64("student_id", sui.ID))
+		return
+	}
+
+	var ids []int64
+	if err := json.NewDecoder(r.Body).Decode(&ids); err != nil {
+		app.apiError(r, w, http.StatusBadRequest, err, slog.Int64("student_id", sui.ID))
+		return
+	}
+
+	n, err := app.queries.DeleteStudentSessions(r.Context(), db.DeleteStudentSessionsParams{
+		StudentID:  pgtype.Int8{Int64: sui.ID, Valid: true},
+		SessionIds: ids,
+	})
+	if err != nil {
+		app.apiError(r, w, http.StatusInternalServerError, err.Error(), slog.Int64("student_id", sui.ID))
+		return
+	}
+	app.logInfo(r, logMsgStudentSessionsRevoke, slog.Int64("student_id", sui.ID), slog.Int64("count", n))
+
+	app.writeStudentSessions(w, r, sui)
+}
+
+func (app *App) writeStudentSessions(w http.ResponseWriter, r *http.Request, sui *UserInfoStudent) {
+	rows, err := app.queries.GetSessionsByStudent(r.Context(), pgtype.Int8{Int64: sui.ID, Valid: true})
+	if err != nil {
+		app.apiError(r, w, http.StatusInternalServerError, err.Error(), slog.Int64("student_id", sui.ID))
+		return
+	}
+
+	sessions := make([]studentSession, 0, len(rows))
+	for _, row := range rows {
+		sessions = append(sessions, studentSession{
+			GetSessionsByStudentRow: row,
+			Current:                 row.ID == sui.SessionID,
+		})
+	}
+
+	app.writeJSON(r, w, http.StatusOK, sessions, slog.String("resource", "sessions"), slog.Int64("student_id", sui.ID))
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"log/slog"
+	"net/http"
+
+	"git.sr.ht/~runxiyu/cca/db"
+)
+
+// studentSnapshot is everything the SPA keeps locally. Clients fetch it on
+// load and whenever they detect a gap in the event sequence, then apply
+// events on top of it.
+type studentSnapshot struct {
+	User       *UserInfoStudent               `json:"user"`
+	Courses    []db.GetCoursesRow             `json:"courses"`
+	Periods    []string                       `json:"periods"`
+	Categories []string                       `json:"categories"`
+	Grades     []AbsGradesRow                 `json:"grades"`
+	Selections []db.GetSelectionsByStudentRow `json:"selections"`
+	// DevLogin is set when the development login is enabled, so that the
+	// SPA can warn about it.
+	DevLogin bool `json:"dev_login"`
+}
+
+func (app *App) handleStuAPISnapshot(w http.ResponseWriter, r *http.Request, sui *UserInfoStudent) {
+	app.logRequestStart(r, "handleStuAPISnapshot", slog.Int64("student_id", sui.ID))
+	if r.Method != http.MethodGet {
+		app.apiError(r, w, http.StatusMethodNotAllowed, nil)
+		return
+	}
+
+	ctx := r.Context()
+	snapshot := studentSnapshot{User: sui, DevLogin: app.config.DevLogin.InsecureEnable}
+	var err error
+
+	snapshot.Courses, err = app.queries.GetCourses(ctx)
+	if err != nil {
+		app.apiError(r, w, http.StatusInternalServerError, err.Error(), slog.String("resource", wsResourceCourses))
+		return
+	}
+	snapshot.Periods, err = app.queries.GetPeriods(ctx)
+	if err != nil {
+		app.apiError(r, w, http.StatusInternalServerError, err.Error(), slog.String("resource", wsResourcePeriods))
+		return
+	}
+	snapshot.Categories, err = app.queries.GetCategories(ctx)
+	if err != nil {
+		app.apiError(r, w, http.StatusInternalServerError, err.Error(), slog.String("resource", wsResourceCategories))
+		return
+	}
+	snapshot.Grades, err = app.AbsGrades(ctx)
+	if err != nil {
+		app.apiError(r, w, http.StatusInternalServerError, err.Error(), slog.String("resource", wsResourceGrades))
+		return
+	}
+	snapshot.Selections, err = app.queries.GetSelectionsByStudent(ctx, sui.ID)
+	if err != nil {
+		app.apiError(r, w, http.StatusInternalServerError, err.Error(), slog.String("resource", wsResourceSelections))
+		return
+	}
+
+	app.writeJSON(r, w, http.StatusOK, snapshot, slog.Int64("student_id", sui.ID))
+}
@@ -0,0 +1,210 @@
+package main
+
+import (
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"strings"
+
+	"git.sr.ht/~runxiyu/cca/db"
+)
+
+const (
+	timetableModeAtomic     = "atomic"
+	timetableModeBestEffort = "best_effort"
+	// timetableMaxBody and timetableMaxCourses bound the work one request
+	// may cause, as every change runs in its own savepoint.
+	timetableMaxBody    = 64 << 10
+	timetableMaxCourses = 256
+)
+
+type timetableRequest struct {
+	// CourseIDs is required, so that a request that leaves it out or
+	// misspells it cannot drop every selection; send [] to drop them all.
+	CourseIDs *[]string `json:"course_ids"`
+	// Mode is either "atomic" (the default), where any failure rolls back
+	// every change, or "best_effort", where each change is applied on its own.
+	Mode string `json:"mode"`
+}
+
+type timetableResult struct {
+	CourseID string `json:"course_id"`
+	Action   string `json:"action"`
+	OK       bool   `json:"ok"`
+	Error    string `json:"error,omitempty"`
+}
+
+type timetableResponse struct {
+	Applied    bool                           `json:"applied"`
+	Results    []timetableResult              `json:"results"`
+	Selections []db.GetSelectionsByStudentRow `json:"selections"`
+}
+
+func (app *App) handleStuAPITimetable(w http.ResponseWriter, r *http.Request, sui *UserInfoStudent) {
+	app.logRequestStart(r, "handleStuAPITimetable", slog.Int64("student_id", sui.ID))
+	if r.Method != http.MethodPut {
+		app.apiError(r, w, http.StatusMethodNotAllowed, nil)
+		return
+	}
+
+	var req timetableRequest
+	dec := json.NewDecoder(http.MaxBytesReader(w, r.Body, timetableMaxBody))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&req); err != nil {
+		app.apiError(r, w, http.StatusBadRequest, err.Error(), slog.Int64("student_id", sui.ID))
+		return
+	}
+	if req.CourseIDs == nil {
+		app.apiError(r, w, http.StatusBadRequest, "course_ids is required", slog.Int64("student_id", sui.ID))
+		return
+	}
+	if len(*req.CourseIDs) > timetableMaxCourses {
+		app.apiError(r, w, http.StatusBadRequest, "Too many courses", slog.Int64("student_id", sui.ID), slog.Int("courses", len(*req.CourseIDs)))
+		return
+	}
+	switch req.Mode {
+	case "":
+		req.Mode = timetableModeAtomic
+	case timetableModeAtomic, timetableModeBestEffort:
+	default:
+		app.apiError(r, w, http.StatusBadRequest, "Unknown mode "+req.Mode, slog.Int64("student_id", sui.ID))
+		return
+	}
+
+	release, ok := app.admitStudent(w, r, sui)
+	if !ok {
+		return
+	}
+	defer release()
+
+	tx, err := app.pool.Begin(r.Context())
+	if err != nil {
+		app.apiError(r, w, http.StatusInternalServerError, err.Error(), slog.Int64("student_id", sui.ID))
+		return
+	}
+	defer func() {
+		_ = tx.Rollback(r.Context())
+	}()
+
+	current, err := app.queries.WithTx(tx).GetSelectionsByStudent(r.Context(), sui.ID)
+	if err != nil {
+		app.apiError(r, w, http.StatusInternalServerError, err.Error(), slog.Int64("student_id", sui.ID))
+		return
+	}
+
+	currentIDs := make([]string, 0, len(current))
+	for _, selection := range current {
+		currentIDs = append(currentIDs, selection.CourseID)
+	}
+	results := planTimetable(currentIDs, *req.CourseIDs)
+
+	// Every change runs in its own savepoint so that all failures can be
+	// reported, whichever mode is in use.
+	failed := false
+	var changed []string
+	for i := range results {
+		item := &results[i]
+		sp, err := tx.Begin(r.Context())
+		if err != nil {
+			app.apiError(r, w, http.StatusInternalServerError, err.Error(), slog.Int64("student_id", sui.ID))
+			return
+		}
+		qsp := app.queries.WithTx(sp)
+		if item.Action == "drop" {
+			err = qsp.DeleteChoiceByStudentAndCourse(r.Context(), db.DeleteChoiceByStudentAndCourseParams{
+				PStudentID: sui.ID,
+				PCourseID:  item.CourseID,
+			})
+		} else {
+			err = qsp.NewSelection(r.Context(), db.NewSelectionParams{
+				PStudentID:     sui.ID,
+				PCourseID:      item.CourseID,
+				PSelectionType: db.SelectionTypeNormal,
+			})
+		}
+		if err != nil {
+			_ = sp.Rollback(r.Context())
+			if item.Action == "add" {
+				app.dashboard.RecordFailure(sui.ID, item.CourseID, err)
+			}
+			item.Error = err.Error()
+			failed = true
+			continue
+		}
+		if err := sp.Commit(r.Context()); err != nil {
+			app.apiError(r, w, http.StatusInternalServerError, err.Error(), slog.Int64("student_id", sui.ID))
+			return
+		}
+		item.OK = true
+		changed = append(changed, item.CourseID)
+	}
+
+	if failed && req.Mode == timetableModeAtomic {
+		for i := range results {
+			results[i].OK = false
+		}
+		selections, err := app.queries.GetSelectionsByStudent(r.Context(), sui.ID)
+		if err != nil {
+			app.apiError(r, w, http.StatusInternalServerError, err.Error(), slog.Int64("student_id", sui.ID))
+			return
+		}
+		app.apiError(r, w, http.StatusConflict, timetableResponse{
+			Applied:    false,
+			Results:    results,
+			Selections: selections,
+		}, slog.Int64("student_id", sui.ID), slog.String("mode", req.Mode))
+		return
+	}
+
+	if err := tx.Commit(r.Context()); err != nil {
+		app.apiError(r, w, http.StatusInternalServerError, err.Error(), slog.Int64("student_id", sui.ID))
+		return
+	}
+
+	app.logInfo(r, logMsgStudentTimetableApply, slog.Int64("student_id", sui.ID), slog.String("mode", req.Mode), slog.Int("changes", len(changed)), slog.Int("failures", len(results)-len(changed)))
+
+	selections, err := app.queries.GetSelectionsByStudent(r.Context(), sui.ID)
+	if err != nil {
+		app.apiError(r, w, http.StatusInternalServerError, err.Error(), slog.Int64("student_id", sui.ID))
+		return
+	}
+	app.writeJSON(r, w, http.StatusOK, timetableResponse{
+		Applied:    true,
+		Results:    results,
+		Selections: selections,
+	}, slog.Int64("student_id", sui.ID))
+}
+
+// planTimetable returns the changes that turn the current selections into
+// the desired ones, with blank and repeated desired IDs ignored. Drops go
+// first so that a new course may take over a freed period.
+func planTimetable(current, desired []string) []timetableResult {
+	want := make(map[string]struct{}, len(desired))
+	var wantOrder []string
+	for _, raw := range desired {
+		id := strings.TrimSpace(raw)
+		if id == "" {
+			continue
+		}
+		if _, ok := want[id]; ok {
+			continue
+		}
+		want[id] = struct{}{}
+		wantOrder = append(wantOrder, id)
+	}
+
+	have := make(map[string]struct{}, len(current))
+	results := []timetableResult{}
+	for _, id := range current {
+		have[id] = struct{}{}
+		if _, ok := want[id]; !ok {
+			results = append(results, timetableResult{CourseID: id, Action: "drop"})
+		}
+	}
+	for _, id := range wantOrder {
+		if _, ok := have[id]; !ok {
+			results = append(results, timetableResult{CourseID: id, Action: "add"})
+		}
+	}
+	return results
+}
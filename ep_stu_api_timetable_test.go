@@ -0,0 +1,66 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestPlanTimetable(t *testing.T) {
+	tests := []struct {
+		name    string
+		current []string
+		desired []string
+		want    []timetableResult
+	}{
+		{"unchanged", []string{"a", "b"}, []string{"b", "a"}, []timetableResult{}},
+		{"drop all", []string{"a", "b"}, []string{}, []timetableResult{
+			{CourseID: "a", Action: "drop"},
+			{CourseID: "b", Action: "drop"},
+		}},
+		{"drops before adds", []string{"a"}, []string{"c", "b"}, []timetableResult{
+			{CourseID: "a", Action: "drop"},
+			{CourseID: "c", Action: "add"},
+			{CourseID: "b", Action: "add"},
+		}},
+		{"blank and repeated", []string{"a"}, []string{" a ", "", "b", "b", "  "}, []timetableResult{
+			{CourseID: "b", Action: "add"},
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := planTimetable(tt.current, tt.desired); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("planTimetable(%v, %v) = %v, want %v", tt.current, tt.desired, got, tt.want)
+			}
+		})
+	}
+}
+
+// TestTimetableRejectsBadRequests checks requests that are rejected before
+// any selection is touched.
+func TestTimetableRejectsBadRequests(t *testing.T) {
+	app := &App{}
+	tests := []struct {
+		name string
+		body string
+	}{
+		{"empty object", `{}`},
+		{"null course_ids", `{"course_ids": null}`},
+		{"misspelled key", `{"course_id": ["a"]}`},
+		{"unknown mode", `{"course_ids": [], "mode": "all_or_nothing"}`},
+		{"too many courses", `{"course_ids": [` + strings.Repeat(`"a",`, timetableMaxCourses) + `"a"]}`},
+		{"too large", `{"course_ids": ["` + strings.Repeat("a", timetableMaxBody) + `"]}`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodPut, "/student/api/timetable", strings.NewReader(tt.body))
+			w := httptest.NewRecorder()
+			app.handleStuAPITimetable(w, r, &UserInfoStudent{})
+			if w.Code != http.StatusBadRequest {
+				t.Errorf("status = %d, want %d", w.Code, http.StatusBadRequest)
+			}
+		})
+	}
+}
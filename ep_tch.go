@@ -0,0 +1,200 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/coder/websocket"
+	"github.com/jackc/pgx/v5/pgtype"
+
+	"git.sr.ht/~runxiyu/cca/db"
+)
+
+// handleTch lists the courses of the teacher with their rosters.
+func (app *App) handleTch(w http.ResponseWriter, r *http.Request, tui *UserInfoTeacher) {
+	app.logRequestStart(r, "handleTch", slog.String("teacher_username", tui.Username))
+	if r.Method != http.MethodGet {
+		app.respondHTTPError(r, w, http.StatusMethodNotAllowed, "Method Not Allowed", nil, slog.String("teacher_username", tui.Username))
+		return
+	}
+
+	courses, err := app.queries.GetTeacherCourses(r.Context(), tui.ID)
+	if err != nil {
+		app.respondHTTPError(r, w, http.StatusInternalServerError, "Internal Server Error\n"+err.Error(), err, slog.String("teacher_username", tui.Username))
+		return
+	}
+	rows, err := app.queries.GetTeacherRosters(r.Context(), db.GetTeacherRostersParams{TeacherID: tui.ID})
+	if err != nil {
+		app.respondHTTPError(r, w, http.StatusInternalServerError, "Internal Server Error\n"+err.Error(), err, slog.String("teacher_username", tui.Username))
+		return
+	}
+	rosters := make(map[string][]db.GetTeacherRostersRow, len(courses))
+	for _, row := range rows {
+		rosters[row.CourseID] = append(rosters[row.CourseID], row)
+	}
+
+	if err := app.tchRenderTemplate(w, r, "courses", struct {
+		Name    string
+		Courses []db.GetTeacherCoursesRow
+		Rosters map[string][]db.GetTeacherRostersRow
+	}{
+		Name:    tui.Name,
+		Courses: courses,
+		Rosters: rosters,
+	}, slog.String("teacher_username", tui.Username)); err != nil {
+		app.respondHTTPError(r, w, http.StatusInternalServerError, "Internal Server Error\nfailed rendering template", err, slog.String("teacher_username", tui.Username))
+	}
+}
+
+// handleTchRoster downloads the roster of one of the teacher's courses, or
+// of all of them if no course is given.
+func (app *App) handleTchRoster(w http.ResponseWriter, r *http.Request, tui *UserInfoTeacher) {
+	app.logRequestStart(r, "handleTchRoster", slog.String("teacher_username", tui.Username))
+	if r.Method != http.MethodGet {
+		app.respondHTTPError(r, w, http.StatusMethodNotAllowed, "Method Not Allowed", nil, slog.String("teacher_username", tui.Username))
+		return
+	}
+
+	courseID := strings.TrimSpace(r.URL.Query().Get("course"))
+	filename := "roster.csv"
+	if courseID != "" {
+		courses, err := app.queries.GetTeacherCourses(r.Context(), tui.ID)
+		if err != nil {
+			app.respondHTTPError(r, w, http.StatusInternalServerError, "Internal Server Error\n"+err.Error(), err, slog.String("teacher_username", tui.Username))
+			return
+		}
+		if !slices.ContainsFunc(courses, func(c db.GetTeacherCoursesRow) bool { return c.ID == courseID }) {
+			app.respondHTTPError(r, w, http.StatusNotFound, "Not Found\nYou do not teach this course", nil, slog.String("teacher_username", tui.Username), slog.String("course_id", courseID))
+			return
+		}
+		filename = "roster-" + courseID + ".csv"
+	}
+
+	rows, err := app.queries.GetTeacherRosters(r.Context(), db.GetTeacherRostersParams{
+		TeacherID: tui.ID,
+		CourseID:  pgtype.Text{String: courseID, Valid: courseID != ""},
+	})
+	if err != nil {
+		app.respondHTTPError(r, w, http.StatusInternalServerError, "Internal Server Error\n"+err.Error(), err, slog.String("teacher_username", tui.Username))
+		return
+	}
+
+	var buf bytes.Buffer
+	buf.WriteString("\uFEFF") // Excel BOM
+	csvWriter := csv.NewWriter(&buf)
+	if err := csvWriter.Write([]string{"course_id", "student_id", "student_name", "grade", "legal_sex", "selection_type"}); err != nil {
+		app.respondHTTPError(r, w, http.StatusInternalServerError, "Internal Server Error\n"+err.Error(), err, slog.String("teacher_username", tui.Username))
+		return
+	}
+	for _, row := range rows {
+		if err := csvWriter.Write([]string{
+			row.CourseID,
+			strconv.FormatInt(row.StudentID, 10),
+			row.StudentName,
+			row.Grade,
+			string(row.LegalSex),
+			string(row.SelectionType),
+		}); err != nil {
+			app.respondHTTPError(r, w, http.StatusInternalServerError, "Internal Server Error\n"+err.Error(), err, slog.String("teacher_username", tui.Username))
+			return
+		}
+	}
+	csvWriter.Flush()
+	if err := csvWriter.Error(); err != nil {
+		app.respondHTTPError(r, w, http.StatusInternalServerError, "Internal Server Error\n"+err.Error(), err, slog.String("teacher_username", tui.Username))
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/csv")
+	w.Header().Set("Content-Disposition", "attachment; filename=\""+strings.ReplaceAll(filename, "\"", "")+"\"")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(http.StatusOK)
+	if _, err := w.Write(buf.Bytes()); err != nil {
+		app.logWarn(r, logMsgHTTPResponseError, slog.Any("error", err), slog.String("teacher_username", tui.Username))
+	}
+	app.logInfo(r, logMsgTeacherRosterExport, slog.String("teacher_username", tui.Username), slog.String("course_id", courseID), slog.Int("row_count", len(rows)))
+}
+
+// teacherCounts is what the teacher page receives over its WebSocket.
+type teacherCounts struct {
+	Time    time.Time         `json:"ts"`
+	Courses []dashboardCourse `json:"courses"`
+}
+
+// handleTchEvents streams the enrollment of the teacher's courses as JSON
+// text messages, as often as the admin dashboard is updated. The counts come
+// from the dashboard, so they cost no queries.
+func (app *App) handleTchEvents(w http.ResponseWriter, r *http.Request, tui *UserInfoTeacher) {
+	app.logRequestStart(r, "handleTchEvents", slog.String("teacher_username", tui.Username))
+	if r.Method != http.MethodGet {
+		app.apiError(r, w, http.StatusMethodNotAllowed, nil, slog.String("teacher_username", tui.Username))
+		return
+	}
+
+	courses, err := app.queries.GetTeacherCourses(r.Context(), tui.ID)
+	if err != nil {
+		app.respondHTTPError(r, w, http.StatusInternalServerError, "Internal Server Error\n"+err.Error(), err, slog.String("teacher_username", tui.Username))
+		return
+	}
+	ids := make([]string, 0, len(courses))
+	for _, course := range courses {
+		ids = append(ids, course.ID)
+	}
+
+	conn, err := websocket.Accept(w, r, nil)
+	if err != nil {
+		app.logError(r, logMsgTeacherEventsUpgradeError, slog.Any("error", err))
+		return
+	}
+	defer func() {
+		_ = conn.CloseNow()
+	}()
+	app.logInfo(r, logMsgTeacherEventsEstablished, slog.String("teacher_username", tui.Username))
+
+	ctx := conn.CloseRead(context.Background())
+
+	// Only the timing of dashboard snapshots is used, not their contents.
+	snapshots, unsubscribe := app.dashboard.Subscribe()
+	defer unsubscribe()
+
+	var ping <-chan time.Time
+	if app.config.WebSocket.PingInterval > 0 {
+		ticker := time.NewTicker(app.config.WebSocket.PingInterval)
+		defer ticker.Stop()
+		ping = ticker.C
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-snapshots:
+			data, err := json.Marshal(teacherCounts{Time: time.Now(), Courses: app.dashboard.Courses(ids)})
+			if err != nil {
+				app.logError(r, logMsgAdminDashboardError, slog.Any("error", err))
+				return
+			}
+			writeCtx, cancel := app.wsHub.withWriteTimeout(ctx)
+			err = conn.Write(writeCtx, websocket.MessageText, data)
+			cancel()
+			if err != nil {
+				return
+			}
+		case <-ping:
+			pingCtx, cancel := app.wsHub.withWriteTimeout(ctx)
+			err := conn.Ping(pingCtx)
+			cancel()
+			if err != nil {
+				return
+			}
+		}
+	}
+}
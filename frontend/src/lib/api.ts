@@ -1,4 +1,11 @@
-import type { Category, Choice, Course, Period, Student } from "../types"
+import type {
+	Category,
+	Choice,
+	InboxNotification,
+	Period,
+	Snapshot,
+	StudentSession,
+} from "../types"
 
 type HTTPMethod = "PUT" | "DELETE"
 
@@ -35,39 +42,24 @@ function handleRedirect(response: Response): void {
 	}
 }
 
-export async function fetchUser(): Promise<Student> {
-	const user = await getJSON<Student>("/student/api/user_info")
-	return user
-}
-
-export async function fetchCourses(): Promise<Course[]> {
-	const data = await getJSON<Course[] | null>("/student/api/courses")
-	const list = asArray(data)
-	return list
-}
-
-export async function fetchPeriods(): Promise<Period[]> {
-	const data = await getJSON<Period[] | null>("/student/api/periods")
-	const list = asArray(data)
-	const normalized = list.map((entry) =>
-		typeof entry === "string" ? { id: entry } : entry,
+export function asIDList<T extends { id: string }>(
+	value: (T | string)[] | null | undefined,
+): T[] {
+	return asArray(value).map((entry) =>
+		typeof entry === "string" ? ({ id: entry } as T) : entry,
 	)
-	return normalized
 }
 
-export async function fetchCategories(): Promise<Category[]> {
-	const data = await getJSON<Category[] | null>("/student/api/categories")
-	const list = asArray(data)
-	const normalized = list.map((entry) =>
-		typeof entry === "string" ? { id: entry } : entry,
-	)
-	return normalized
-}
-
-export async function fetchSelections(): Promise<Choice[]> {
-	const data = await getJSON<Choice[] | null>("/student/api/my_selections")
-	const list = asArray(data)
-	return list
+export async function fetchSnapshot(): Promise<Snapshot> {
+	const data = await getJSON<Snapshot>("/student/api/snapshot")
+	return {
+		user: data.user,
+		courses: asArray(data.courses),
+		periods: asIDList<Period>(data.periods),
+		categories: asIDList<Category>(data.categories),
+		grades: asArray(data.grades),
+		selections: asArray(data.selections),
+	}
 }
 
 export async function mutateSelection(
@@ -82,3 +74,41 @@ export async function mutateSelection(
 	const list = asArray(data)
 	return list
 }
+
+export async function fetchNotifications(): Promise<InboxNotification[]> {
+	const data = await getJSON<InboxNotification[] | null>(
+		"/student/api/notifications",
+	)
+	return asArray(data)
+}
+
+export async function markNotificationsRead(
+	ids: number[],
+): Promise<InboxNotification[]> {
+	const data = await getJSON<InboxNotification[] | null>(
+		"/student/api/notifications/read",
+		{
+			method: "POST",
+			headers: jsonHeaders,
+			body: JSON.stringify(ids),
+		},
+	)
+	return asArray(data)
+}
+
+export async function fetchSessions(): Promise<StudentSession[]> {
+	const data = await getJSON<StudentSession[] | null>("/student/api/sessions")
+	return asArray(data)
+}
+
+export async function revokeSessions(ids: number[]): Promise<StudentSession[]> {
+	const data = await getJSON<StudentSession[] | null>(
+		"/student/api/sessions/revoke",
+		{
+			method: "POST",
+			headers: jsonHeaders,
+			body: JSON.stringify(ids),
+		},
+	)
+	return asArray(data)
+}
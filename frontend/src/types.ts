@@ -29,7 +29,11 @@ export interface Period {
 export interface Admin {
 	id: number
 	username: string
-	session_token: string | null
+}
+
+export interface StudentImpersonation {
+	admin: string
+	read_only: boolean
 }
 
 export interface Student {
@@ -37,7 +41,8 @@ export interface Student {
 	name: string
 	grade: string
 	legal_sex: LegalSex
-	session_token: string | null
+	// Set when an administrator is viewing the site as the student.
+	impersonation?: StudentImpersonation
 }
 
 export interface Course {
@@ -53,9 +58,69 @@ export interface Course {
 	category_id: string
 }
 
+export interface InboxNotification {
+	id: number
+	text: string
+	created_at: string
+	read_at: string | null
+}
+
+export interface StudentSession {
+	id: number
+	created_at: string
+	expires_at: string
+	last_seen_at: string
+	user_agent: string
+	ip: string
+	current: boolean
+}
+
 export interface Choice {
 	student_id: number
 	course_id: string
 	period: string
 	selection_type: SelectionType
 }
+
+export type WSResource =
+	| "selections"
+	| "courses"
+	| "grades"
+	| "categories"
+	| "periods"
+
+interface WSEnvelope<T extends string, P> {
+	v: 1
+	type: T
+	payload: P
+	ts: string
+	seq: number
+	id?: number
+}
+
+export type WSEvent =
+	| WSEnvelope<"hello", { last_event_id: number }>
+	| WSEnvelope<"notify", { notification_id?: number; text: string }>
+	| WSEnvelope<
+			"course_counts",
+			{ counts: { course_id: string; current_students: number }[] }
+	  >
+	| WSEnvelope<"invalidate", { resource: WSResource }>
+	| WSEnvelope<"selections", { selections: Choice[] }>
+	| WSEnvelope<"courses", { courses: Course[] }>
+	| WSEnvelope<"grades", { grades: GradeRequirement[] }>
+	| WSEnvelope<"categories", { categories: string[] }>
+	| WSEnvelope<"periods", { periods: string[] }>
+	| WSEnvelope<"resync", Record<string, never>>
+	| WSEnvelope<"queue_position", { position: number }>
+	| WSEnvelope<"queue_admitted", Record<string, never>>
+
+export interface Snapshot {
+	user: Student
+	courses: Course[]
+	periods: Period[]
+	categories: Category[]
+	grades: GradeRequirement[]
+	selections: Choice[]
+	dev_login: boolean
+}
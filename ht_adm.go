@@ -1,6 +1,7 @@
 package main
 
 import (
+	"bytes"
 	"fmt"
 	"html/template"
 	"log/slog"
@@ -8,51 +9,96 @@ import (
 	"path/filepath"
 )
 
-func (app *App) admLoadTemplates() error {
-	pages, err := filepath.Glob("admin_templates/pages/*.tmpl")
+// loadTemplates parses each page in dir/pages together with dir/base.tmpl
+// and the partials, keyed by the page's file name.
+func loadTemplates(dir string) (map[string]*template.Template, error) {
+	pages, err := filepath.Glob(filepath.Join(dir, "pages", "*.tmpl"))
 	if err != nil {
-		return fmt.Errorf("load admin admin_templates: %w", err)
+		return nil, fmt.Errorf("load %s: %w", dir, err)
 	}
 
-	app.admTmpl = make(map[string]*template.Template)
+	tmpl := make(map[string]*template.Template)
 
 	for _, page := range pages {
 		name := filepath.Base(page)
-		base := "admin_templates/base.tmpl"
+		base := filepath.Join(dir, "base.tmpl")
 		t, err := template.New("").ParseFiles(base, page)
 		if err != nil {
-			return fmt.Errorf("parse admin_templates %#v and %#v: %w", base, page, err)
+			return nil, fmt.Errorf("parse %#v and %#v: %w", base, page, err)
 		}
 		_, err = t.ParseGlob("admin_templates/partials/*.tmpl")
 		if err != nil {
-			return fmt.Errorf("parse partials while loading %#v: %w", page, err)
+			return nil, fmt.Errorf("parse partials while loading %#v: %w", page, err)
 		}
-		app.admTmpl[name] = t
-		// fmt.Printf("base=%s, page=%s, name=%s\n", base, page, name)
+		tmpl[name] = t
 	}
 
-	return nil
+	return tmpl, nil
+}
+
+func (app *App) admLoadTemplates() error {
+	var err error
+	app.admTmpl, err = loadTemplates("admin_templates")
+	return err
+}
+
+func (app *App) tchLoadTemplates() error {
+	var err error
+	app.tchTmpl, err = loadTemplates("teacher_templates")
+	return err
+}
+
+func (app *App) loginLoadTemplates() error {
+	var err error
+	app.loginTmpl, err = loadTemplates("login_templates")
+	return err
 }
 
 func (app *App) admRenderTemplate(w http.ResponseWriter, r *http.Request, name string, data any, extra ...slog.Attr) error {
-	t, ok := app.admTmpl[name+".tmpl"]
+	return app.renderTemplate(w, r, app.admTmpl, name, data, extra...)
+}
+
+func (app *App) tchRenderTemplate(w http.ResponseWriter, r *http.Request, name string, data any, extra ...slog.Attr) error {
+	return app.renderTemplate(w, r, app.tchTmpl, name, data, extra...)
+}
+
+func (app *App) loginRenderTemplate(w http.ResponseWriter, r *http.Request, name string, data any, extra ...slog.Attr) error {
+	return app.renderTemplate(w, r, app.loginTmpl, name, data, extra...)
+}
+
+// renderTemplate renders a page into a buffer first, so that nothing is
+// written if it fails and the CSRF token of admin requests can be added to
+// its forms.
+func (app *App) renderTemplate(w http.ResponseWriter, r *http.Request, tmpl map[string]*template.Template, name string, data any, extra ...slog.Attr) error {
+	t, ok := tmpl[name+".tmpl"]
 	if !ok {
 		err := fmt.Errorf("unknown template %s", name)
 		app.logError(r, logMsgTemplatesRenderMissing, slog.String("template", name))
 		return err
 	}
 
-	if err := t.ExecuteTemplate(w, "base", struct {
+	var buf bytes.Buffer
+	if err := t.ExecuteTemplate(&buf, "base", struct {
 		ActiveTab string
+		DevLogin  bool
 		Data      any
 	}{
 		ActiveTab: name,
+		DevLogin:  app.config.DevLogin.InsecureEnable,
 		Data:      data,
 	}); err != nil {
 		app.logError(r, logMsgTemplatesRenderError, append(extra, slog.String("template", name), slog.Any("error", err))...)
 		return err
 	}
 
+	page := buf.Bytes()
+	if token := csrfTokenFromContext(r.Context()); token != "" {
+		page = injectCSRF(page, token)
+	}
+	if _, err := w.Write(page); err != nil {
+		app.logWarn(r, logMsgHTTPResponseError, append(extra, slog.Any("error", err))...)
+	}
+
 	app.logInfo(r, logMsgTemplatesRenderSuccess, append(extra, slog.String("template", name))...)
 	return nil
 }
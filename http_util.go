@@ -1,10 +1,21 @@
 package main
 
 import (
+	"net"
 	"net/http"
 	"strings"
 )
 
+// remoteHost returns the address the request came from. It does not trust
+// X-Forwarded-For, as we recommend against reverse proxies.
+func remoteHost(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
 func requestAbsoluteURL(r *http.Request, path string) string {
 	scheme := inferScheme(r)
 	host := inferHost(r)
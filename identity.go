@@ -0,0 +1,154 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+var (
+	errIdentityMissing = errors.New("identity claim missing from ID token")
+	errIdentityDomain  = errors.New("identity is not in an allowed domain")
+	// errIdentityUnverified is returned for email addresses that the
+	// identity provider says it has not verified, as anyone could
+	// otherwise claim an address in an allowed domain.
+	errIdentityUnverified = errors.New("email address in ID token is not verified")
+)
+
+// identityMapper turns the identity of a user, e.g. their email address, into
+// the username of a staff member or the ID of a student, as configured in the
+// identity block.
+type identityMapper struct {
+	claim          string
+	domains        []string
+	studentPattern *regexp.Regexp
+	staffPattern   *regexp.Regexp
+	studentLookup  map[string]int64
+	staffLookup    map[string]string
+}
+
+// mappedIdentity is what an identity may log in as. A staff username is
+// looked up in the admins and then teachers tables before the student ID is
+// tried.
+type mappedIdentity struct {
+	StaffUsername string
+	StudentID     int64
+	IsStudent     bool
+}
+
+func newIdentityMapper(config Config) (*identityMapper, error) {
+	cfg := config.Identity
+	m := &identityMapper{
+		claim:         cfg.Claim,
+		studentLookup: make(map[string]int64, len(cfg.StudentLookup)),
+		staffLookup:   make(map[string]string, len(cfg.StaffLookup)),
+	}
+	if m.claim == "" {
+		return nil, errors.New("identity.claim must not be empty")
+	}
+	for _, domain := range cfg.Domains {
+		m.domains = append(m.domains, strings.ToLower(domain))
+	}
+
+	var err error
+	if m.studentPattern, err = compileIdentityPattern(cfg.StudentPattern); err != nil {
+		return nil, fmt.Errorf("identity.student_pattern: %w", err)
+	}
+	if m.staffPattern, err = compileIdentityPattern(cfg.StaffPattern); err != nil {
+		return nil, fmt.Errorf("identity.staff_pattern: %w", err)
+	}
+
+	for identity, sid := range cfg.StudentLookup {
+		m.studentLookup[strings.ToLower(identity)] = sid
+	}
+	for identity, username := range cfg.StaffLookup {
+		m.staffLookup[strings.ToLower(identity)] = strings.ToLower(username)
+	}
+	return m, nil
+}
+
+// compileIdentityPattern compiles a pattern with exactly one capture group,
+// or returns nil for an empty pattern, which matches nothing.
+func compileIdentityPattern(pattern string) (*regexp.Regexp, error) {
+	if pattern == "" {
+		return nil, nil
+	}
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return nil, err
+	}
+	if re.NumSubexp() != 1 {
+		return nil, fmt.Errorf("pattern %q must have exactly one capture group", pattern)
+	}
+	return re, nil
+}
+
+// identityFromClaims returns the configured claim of an ID token, lowercased.
+// If the claim is email, the token must not have an email_verified claim
+// other than true. Providers that do not send email_verified at all are
+// trusted to only issue verified addresses.
+func (m *identityMapper) identityFromClaims(claims *Claims) (string, error) {
+	identity, ok := claims.all[m.claim].(string)
+	if !ok || identity == "" {
+		return "", fmt.Errorf("%w: %s", errIdentityMissing, m.claim)
+	}
+	if m.claim == "email" {
+		// Some providers send the claim as a string.
+		switch verified, ok := claims.all["email_verified"]; {
+		case !ok, verified == true, verified == "true":
+		default:
+			return "", errIdentityUnverified
+		}
+	}
+	return strings.ToLower(identity), nil
+}
+
+// mapIdentity returns what a lowercased identity may log in as. Lookup tables
+// take precedence over patterns. Identities outside the allowed domains are
+// rejected with errIdentityDomain; if no domains are configured, any identity
+// is mapped.
+func (m *identityMapper) mapIdentity(identity string) (mappedIdentity, error) {
+	if len(m.domains) > 0 {
+		_, domain, ok := strings.Cut(identity, "@")
+		if !ok || !slices.Contains(m.domains, domain) {
+			return mappedIdentity{}, errIdentityDomain
+		}
+	}
+
+	var mapped mappedIdentity
+	if username, ok := m.staffLookup[identity]; ok {
+		mapped.StaffUsername = username
+	} else if m.staffPattern != nil {
+		if match := m.staffPattern.FindStringSubmatch(identity); match != nil {
+			mapped.StaffUsername = match[1]
+		}
+	}
+
+	if sid, ok := m.studentLookup[identity]; ok {
+		mapped.StudentID, mapped.IsStudent = sid, true
+	} else if m.studentPattern != nil {
+		if match := m.studentPattern.FindStringSubmatch(identity); match != nil {
+			sid, err := strconv.ParseInt(match[1], 10, 64)
+			if err == nil {
+				mapped.StudentID, mapped.IsStudent = sid, true
+			}
+		}
+	}
+
+	return mapped, nil
+}
+
+// UnmarshalJSON decodes the claims that are used directly into their fields
+// and keeps all of them for identityMapper, which may be configured to use
+// any claim.
+func (c *Claims) UnmarshalJSON(data []byte) error {
+	type plain Claims
+	if err := json.Unmarshal(data, (*plain)(c)); err != nil {
+		return err
+	}
+	return json.Unmarshal(data, &c.all)
+}
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"errors"
+	"testing"
+)
+
+// TestShippedIdentityPatterns maps identities with the identity block of
+// the shipped configuration.
+func TestShippedIdentityPatterns(t *testing.T) {
+	config, err := loadConfig("cca.scfgs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	m, err := newIdentityMapper(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		identity string
+		want     mappedIdentity
+		wantErr  error
+	}{
+		{"s12345@stu.ykpaoschool.cn", mappedIdentity{StudentID: 12345, IsStudent: true}, nil},
+		{"12345@stu.ykpaoschool.cn", mappedIdentity{StudentID: 12345, IsStudent: true}, nil},
+		{"ed.chapman@ykpaoschool.cn", mappedIdentity{StaffUsername: "ed.chapman"}, nil},
+		// A student address is never a staff username, and a staff
+		// address is never a student ID.
+		{"admin@stu.ykpaoschool.cn", mappedIdentity{}, nil},
+		{"s12345@ykpaoschool.cn", mappedIdentity{StaffUsername: "s12345"}, nil},
+		{"ed.chapman@ykpaoschool.cn.evil.example", mappedIdentity{}, errIdentityDomain},
+		{"ed.chapman@evil.example", mappedIdentity{}, errIdentityDomain},
+		{"ed.chapman", mappedIdentity{}, errIdentityDomain},
+	}
+	for _, tt := range tests {
+		t.Run(tt.identity, func(t *testing.T) {
+			got, err := m.mapIdentity(tt.identity)
+			if !errors.Is(err, tt.wantErr) || got != tt.want {
+				t.Errorf("mapIdentity(%q) = %+v, %v, want %+v, %v", tt.identity, got, err, tt.want, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestMapIdentity(t *testing.T) {
+	var config Config
+	config.Identity.Claim = "upn"
+	config.Identity.StudentPattern = `^s([0-9]+)@school\.example$`
+	config.Identity.StaffPattern = `^([a-z.]+)@school\.example$`
+	config.Identity.StudentLookup = map[string]int64{"Alice@Elsewhere.example": 7, "s1@school.example": 2}
+	config.Identity.StaffLookup = map[string]string{"Head@Elsewhere.example": "Principal"}
+	m, err := newIdentityMapper(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name     string
+		identity string
+		want     mappedIdentity
+	}{
+		{"student pattern", "s42@school.example", mappedIdentity{StudentID: 42, IsStudent: true}},
+		{"staff pattern", "ed.chapman@school.example", mappedIdentity{StaffUsername: "ed.chapman"}},
+		{"student lookup", "alice@elsewhere.example", mappedIdentity{StudentID: 7, IsStudent: true}},
+		{"lookup before pattern", "s1@school.example", mappedIdentity{StudentID: 2, IsStudent: true}},
+		{"staff lookup", "head@elsewhere.example", mappedIdentity{StaffUsername: "principal"}},
+		{"no domains", "bob@elsewhere.example", mappedIdentity{}},
+		{"student ID too large", "s99999999999999999999@school.example", mappedIdentity{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := m.mapIdentity(tt.identity)
+			if err != nil || got != tt.want {
+				t.Errorf("mapIdentity(%q) = %+v, %v, want %+v", tt.identity, got, err, tt.want)
+			}
+		})
+	}
+}
+
+func TestNewIdentityMapperRejectsBadConfig(t *testing.T) {
+	tests := []struct {
+		name   string
+		modify func(config *Config)
+	}{
+		{"no claim", func(c *Config) { c.Identity.Claim = "" }},
+		{"no capture group", func(c *Config) { c.Identity.StudentPattern = `^[0-9]+@` }},
+		{"two capture groups", func(c *Config) { c.Identity.StaffPattern = `^(a)(b)@` }},
+		{"invalid pattern", func(c *Config) { c.Identity.StaffPattern = `^(` }},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var config Config
+			config.Identity.Claim = "email"
+			tt.modify(&config)
+			if _, err := newIdentityMapper(config); err == nil {
+				t.Error("accepted")
+			}
+		})
+	}
+}
+
+func TestIdentityFromClaims(t *testing.T) {
+	tests := []struct {
+		name    string
+		claim   string
+		json    string
+		want    string
+		wantErr error
+	}{
+		{"verified", "email", `{"email": "Ed@School.example", "email_verified": true}`, "ed@school.example", nil},
+		{"verified as a string", "email", `{"email": "ed@school.example", "email_verified": "true"}`, "ed@school.example", nil},
+		{"verification not sent", "email", `{"email": "ed@school.example"}`, "ed@school.example", nil},
+		{"unverified", "email", `{"email": "ed@school.example", "email_verified": false}`, "", errIdentityUnverified},
+		{"unverified as a string", "email", `{"email": "ed@school.example", "email_verified": "false"}`, "", errIdentityUnverified},
+		{"verification null", "email", `{"email": "ed@school.example", "email_verified": null}`, "", errIdentityUnverified},
+		{"verification malformed", "email", `{"email": "ed@school.example", "email_verified": 1}`, "", errIdentityUnverified},
+		{"other claim ignores email_verified", "upn", `{"upn": "ed@school.example", "email_verified": false}`, "ed@school.example", nil},
+		{"missing", "email", `{"upn": "ed@school.example"}`, "", errIdentityMissing},
+		{"empty", "email", `{"email": ""}`, "", errIdentityMissing},
+		{"not a string", "upn", `{"upn": ["ed@school.example"]}`, "", errIdentityMissing},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var config Config
+			config.Identity.Claim = tt.claim
+			m, err := newIdentityMapper(config)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var claims Claims
+			if err := claims.UnmarshalJSON([]byte(tt.json)); err != nil {
+				t.Fatal(err)
+			}
+			got, err := m.identityFromClaims(&claims)
+			if !errors.Is(err, tt.wantErr) || got != tt.want {
+				t.Errorf("identityFromClaims(%s) = %q, %v, want %q, %v", tt.json, got, err, tt.want, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,160 @@
+package main
+
+import (
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgtype"
+
+	"git.sr.ht/~runxiyu/cca/db"
+)
+
+const (
+	// impersonationCookie is separate from sessionCookie and only sent to
+	// the student SPA, so the administrator stays logged in to the admin
+	// pages while viewing the site as a student.
+	impersonationCookie = "impersonation"
+	// impersonationLifetime is how long an administrator may view the site
+	// as a student before having to start again. Impersonation sessions
+	// are not renewed.
+	impersonationLifetime = time.Hour
+)
+
+// studentImpersonation is set on UserInfoStudent when an administrator is
+// viewing the site as the student.
+type studentImpersonation struct {
+	Admin    string `json:"admin"`
+	ReadOnly bool   `json:"read_only"`
+}
+
+func setImpersonationCookie(w http.ResponseWriter, value string, expires time.Time) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     impersonationCookie,
+		Value:    value,
+		Path:     "/student",
+		SameSite: http.SameSiteLaxMode,
+		HttpOnly: true,
+		Secure:   true,
+		Expires:  expires,
+	})
+}
+
+func clearImpersonationCookie(w http.ResponseWriter) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     impersonationCookie,
+		Path:     "/student",
+		SameSite: http.SameSiteLaxMode,
+		HttpOnly: true,
+		Secure:   true,
+		MaxAge:   -1,
+	})
+}
+
+// authenticateImpersonation returns the student an administrator is viewing
+// the site as, or nil if the request has no valid impersonation cookie.
+func (app *App) authenticateImpersonation(w http.ResponseWriter, r *http.Request) (*UserInfoStudent, error) {
+	cookie, err := r.Cookie(impersonationCookie)
+	if err != nil {
+		return nil, nil
+	}
+	u, err := app.queries.GetStudentByImpersonationSession(r.Context(), app.hashSessionToken(cookie.Value))
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			// Expired or ended elsewhere; fall back to the session
+			// cookie from now on.
+			clearImpersonationCookie(w)
+			return nil, nil
+		}
+		return nil, fmt.Errorf("fetch student by impersonation session: %w", err)
+	}
+	return &UserInfoStudent{
+		Student:   u.Student,
+		SessionID: u.SessionID,
+		Impersonation: &studentImpersonation{
+			Admin:    u.Impersonator,
+			ReadOnly: u.ReadOnly,
+		},
+	}, nil
+}
+
+// handleAdmImpersonate opens the student SPA as a student in this browser.
+// Read-only impersonation only needs adminPermView; read-write
+// impersonation changes selections as the student, so it needs
+// adminPermSelections and the student must be in the administrator's
+// grades.
+func (app *App) handleAdmImpersonate(w http.ResponseWriter, r *http.Request, aui *UserInfoAdmin) {
+	app.logRequestStart(r, "handleAdmImpersonate", slog.String("admin_username", aui.Username))
+	if r.Method != http.MethodPost {
+		app.respondHTTPError(r, w, http.StatusMethodNotAllowed, "Method Not Allowed", nil, slog.String("admin_username", aui.Username))
+		return
+	}
+
+	studentID, err := strconv.ParseInt(strings.TrimLeft(strings.TrimSpace(r.FormValue("student_id")), "sS"), 10, 64)
+	if err != nil {
+		app.respondHTTPError(r, w, http.StatusBadRequest, "Bad Request\nStudent ID must be a number", err, slog.String("admin_username", aui.Username))
+		return
+	}
+
+	readOnly := r.FormValue("mode") != "read_write"
+	if !readOnly {
+		if !aui.can(adminPermSelections) {
+			app.respondHTTPError(r, w, http.StatusForbidden, "Forbidden\nYour role does not allow changing selections, so you may only view as a student read-only", nil, slog.String("admin_username", aui.Username))
+			return
+		}
+		if !app.requireStudentScope(w, r, aui, []int64{studentID}) {
+			return
+		}
+	}
+
+	token := rand.Text()
+	expires := time.Now().Add(impersonationLifetime)
+	if _, err := app.queries.NewImpersonationSession(r.Context(), db.NewImpersonationSessionParams{
+		TokenHash:      app.hashSessionToken(token),
+		StudentID:      studentID,
+		ImpersonatorID: pgtype.Int8{Int64: aui.ID, Valid: true},
+		ReadOnly:       readOnly,
+		ExpiresAt:      pgtype.Timestamptz{Time: expires, Valid: true},
+		UserAgent:      r.UserAgent(),
+		Ip:             remoteHost(r),
+	}); err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			app.respondHTTPError(r, w, http.StatusNotFound, "Not Found\nNo such student", err, slog.String("admin_username", aui.Username), slog.Int64("student_id", studentID))
+			return
+		}
+		app.respondHTTPError(r, w, http.StatusInternalServerError, "Internal Server Error\n"+err.Error(), err, slog.String("admin_username", aui.Username), slog.Int64("student_id", studentID))
+		return
+	}
+	setImpersonationCookie(w, token, expires)
+
+	app.logInfo(r, logMsgAdminImpersonationStart, slog.String("admin_username", aui.Username), slog.Int64("student_id", studentID), slog.Bool("read_only", readOnly))
+	http.Redirect(w, r, "/student/", http.StatusSeeOther)
+}
+
+// handleStuImpersonationEnd stops viewing the site as a student and returns
+// to the admin pages. It is under /student so that the impersonation
+// cookie is sent with it.
+func (app *App) handleStuImpersonationEnd(w http.ResponseWriter, r *http.Request) {
+	app.logRequestStart(r, "handleStuImpersonationEnd")
+	if r.Method != http.MethodPost {
+		app.respondHTTPError(r, w, http.StatusMethodNotAllowed, "Method Not Allowed", nil)
+		return
+	}
+
+	if cookie, err := r.Cookie(impersonationCookie); err == nil {
+		if err := app.queries.DeleteSessionByTokenHash(r.Context(), app.hashSessionToken(cookie.Value)); err != nil {
+			app.respondHTTPError(r, w, http.StatusInternalServerError, "Internal Server Error\n"+err.Error(), err)
+			return
+		}
+	}
+	clearImpersonationCookie(w)
+
+	app.logInfo(r, logMsgAdminImpersonationEnd)
+	http.Redirect(w, r, "/admin/students", http.StatusSeeOther)
+}
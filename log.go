@@ -3,7 +3,6 @@ package main
 import (
 	"encoding/json"
 	"log/slog"
-	"net"
 	"net/http"
 	"strings"
 )
@@ -17,10 +16,7 @@ func attrsToArgs(attrs []slog.Attr) []any {
 }
 
 func requestAttrs(r *http.Request) []slog.Attr {
-	host, _, err := net.SplitHostPort(r.RemoteAddr)
-	if err != nil {
-		host = r.RemoteAddr
-	}
+	host := remoteHost(r)
 
 	attrs := []slog.Attr{
 		slog.String("method", r.Method),
@@ -11,15 +11,38 @@ const (
 	logMsgHTTPServerTLSHandshake            = "http.server.tls_handshake_error"
 	logMsgHTTPServerServeFailure            = "http.server.serve_failure"
 	logMsgAuthOIDCRedirect                  = "auth.oidc.redirect_authorize"               //#nosec:G101
-	logMsgAuthStudentBypass                 = "auth.student.bypass"                        //#nosec:G101
+	logMsgAuthDevLogin                      = "auth.dev_login"                             //#nosec:G101
 	logMsgAuthStudentLogin                  = "auth.student.login"                         //#nosec:G101
+	logMsgAuthSessionCleanupError           = "auth.session.cleanup_error"                 //#nosec:G101
+	logMsgAuthSessionRenewError             = "auth.session.renew_error"                   //#nosec:G101
+	logMsgAuthAPITokenTouchError            = "auth.api_token.touch_error"                 //#nosec:G101
+	logMsgAuthImpersonationWrite            = "auth.impersonation.write"                   //#nosec:G101
+	logMsgAuthLogout                        = "auth.logout"                                //#nosec:G101
 	logMsgAuthAdminLogin                    = "auth.admin.login"                           //#nosec:G101
+	logMsgAuthTeacherLogin                  = "auth.teacher.login"                         //#nosec:G101
 	logMsgAuthMiddlewareStudent             = "auth.middleware.student_only.authenticated" //#nosec:G101
 	logMsgAuthMiddlewareAdmin               = "auth.middleware.admin_only.authenticated"   //#nosec:G101
+	logMsgAuthMiddlewareTeacher             = "auth.middleware.teacher_only.authenticated" //#nosec:G101
 	logMsgTemplatesRenderMissing            = "templates.render.missing"
 	logMsgTemplatesRenderError              = "templates.render.error"
 	logMsgTemplatesRenderSuccess            = "templates.render"
+	logMsgAdminDashboardError               = "admin.dashboard.error"
+	logMsgAdminDashboardUpgradeError        = "admin.dashboard.upgrade_error"
+	logMsgAdminDashboardEstablished         = "admin.dashboard.websocket_established"
 	logMsgAdminNotificationsSend            = "admin.notifications.broadcast"
+	logMsgAdminNotificationsSchedule        = "admin.notifications.schedule"
+	logMsgAdminNotificationsScheduleEdit    = "admin.notifications.schedule_edit"
+	logMsgAdminNotificationsScheduleCancel  = "admin.notifications.schedule_cancel"
+	logMsgAdminImpersonationStart           = "admin.impersonation.start"
+	logMsgAdminImpersonationEnd             = "admin.impersonation.end"
+	logMsgAdminSessionsRevoke               = "admin.sessions.revoke"
+	logMsgAdminAPITokensCreate              = "admin.api_tokens.create"
+	logMsgAdminAPITokensRevoke              = "admin.api_tokens.revoke"
+	logMsgAdminAdminsCreate                 = "admin.admins.create"
+	logMsgAdminAdminsUpdate                 = "admin.admins.update"
+	logMsgAdminAdminsDelete                 = "admin.admins.delete"
+	logMsgNotifySchedulerSent               = "notifications.scheduler.sent"
+	logMsgNotifySchedulerError              = "notifications.scheduler.error"
 	logMsgAdminCategoriesCreate             = "admin.categories.create"
 	logMsgAdminCategoriesDelete             = "admin.categories.delete"
 	logMsgAdminPeriodsCreate                = "admin.periods.create"
@@ -44,27 +67,52 @@ const (
 	logMsgAdminSelectionsDelete             = "admin.selections.delete"
 	logMsgAdminSelectionsImport             = "admin.selections.import"
 	logMsgAdminSelectionsExport             = "admin.selections.export"
+	logMsgAdminTeachersCreate               = "admin.teachers.create"
+	logMsgAdminTeachersUpdate               = "admin.teachers.update"
+	logMsgAdminTeachersDelete               = "admin.teachers.delete"
+	logMsgTeacherRosterExport               = "teacher.roster.export"
+	logMsgTeacherEventsUpgradeError         = "teacher.events.upgrade_error"
+	logMsgTeacherEventsEstablished          = "teacher.events.websocket_established"
 	logMsgStudentPlaceholderWrite           = "student.placeholder.write_error"
 	logMsgStudentInfoEncodeError            = "student.info.encode_error"
 	logMsgStudentSelectionsCreate           = "student.api.selections.create"
 	logMsgStudentSelectionsDelete           = "student.api.selections.delete"
+	logMsgStudentTimetableApply             = "student.api.timetable.apply"
+	logMsgStudentSessionsRevoke             = "student.api.sessions.revoke"
+	logMsgStudentNotificationsRead          = "student.api.notifications.read"
 	logMsgStudentEventsUpgradeError         = "student.api.events.upgrade_error"
 	logMsgStudentEventsHelloError           = "student.api.events.hello_write_error"
+	logMsgStudentEventsReplayError          = "student.api.events.replay_error"
 	logMsgStudentEventsEstablished          = "student.api.events.websocket_established"
+	logMsgAdmissionQueued                   = "admission.queued"
+	logMsgAdmissionAdmitted                 = "admission.admitted"
+	logMsgAdmissionAbandoned                = "admission.abandoned"
 	logMsgWebsocketClientRegistered         = "websocket.client.registered"
 	logMsgWebsocketClientUnregistered       = "websocket.client.unregistered"
 	logMsgWebsocketBroadcastAll             = "websocket.broadcast.all"
 	logMsgWebsocketBroadcastTargeted        = "websocket.broadcast.targeted"
 	logMsgWebsocketDropSlowClient           = "websocket.broadcast.drop_slow_client"
-	logMsgWebsocketDropTargetedSlowClient   = "websocket.broadcast.targeted_drop_slow_client"
+	logMsgWebsocketEncodeError              = "websocket.encode.error"
+	logMsgWebsocketClusterPublishError      = "websocket.cluster.publish_error"
+	logMsgWebsocketClusterDecodeError       = "websocket.cluster.decode_error"
+	logMsgWebsocketClusterDrop              = "websocket.cluster.drop"
+	logMsgPostgresListening                 = "postgres.listen.listening"
+	logMsgPostgresListenError               = "postgres.listen.error"
+	logMsgChangesDispatchError              = "changes.dispatch.error"
+	logMsgChangesDispatched                 = "changes.dispatch.done"
+	logMsgEventLogPruneError                = "websocket.event_log.prune_error"
+	logMsgWebsocketPingFailed               = "websocket.ping.failed"
 	logMsgWebsocketWriteError               = "websocket.write.error"
 	logMsgWebsocketReadError                = "websocket.read.error"
-	logMsgStartupConfigLoad                 = "startup.config.load"     //#nosec:G101
-	logMsgStartupDBConnect                  = "startup.db.connect"      //#nosec:G101
-	logMsgStartupJWKSFetch                  = "startup.jwks.fetch"      //#nosec:G101
-	logMsgStartupTemplatesLoad              = "startup.templates.load"  //#nosec:G101
-	logMsgStartupWebsocketSetup             = "startup.websocket.setup" //#nosec:G101
-	logMsgStartupRoutesRegister             = "startup.routes.register" //#nosec:G101
-	logMsgStartupListenerStart              = "startup.listener.start"  //#nosec:G101
-	logMsgStartupServing                    = "startup.server.serve"    //#nosec:G101
+	logMsgStartupConfigLoad                 = "startup.config.load"       //#nosec:G101
+	logMsgStartupDBConnect                  = "startup.db.connect"        //#nosec:G101
+	logMsgStartupSessionKeyLoad             = "startup.session_key.load"  //#nosec:G101
+	logMsgStartupDevLogin                   = "startup.dev_login.enabled" //#nosec:G101
+	logMsgStartupOIDCDiscover               = "startup.oidc.discover"     //#nosec:G101
+	logMsgStartupJWKSFetch                  = "startup.jwks.fetch"        //#nosec:G101
+	logMsgStartupTemplatesLoad              = "startup.templates.load"    //#nosec:G101
+	logMsgStartupWebsocketSetup             = "startup.websocket.setup"   //#nosec:G101
+	logMsgStartupRoutesRegister             = "startup.routes.register"   //#nosec:G101
+	logMsgStartupListenerStart              = "startup.listener.start"    //#nosec:G101
+	logMsgStartupServing                    = "startup.server.serve"      //#nosec:G101
 )
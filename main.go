@@ -10,6 +10,7 @@ import (
 	"net"
 	"net/http"
 	"os"
+	"slices"
 	"strings"
 	"time"
 
@@ -41,6 +42,11 @@ func main() {
 		log.Fatalln(err)
 	}
 
+	app.identity, err = newIdentityMapper(app.config)
+	if err != nil {
+		log.Fatalln(err)
+	}
+
 	// Database
 	slog.Info(logMsgStartupDBConnect)
 	dbCfg := app.config.Database
@@ -78,67 +84,145 @@ func main() {
 	if err != nil {
 		log.Fatalln(err)
 	}
-	if version != 1 {
+	if version != 11 {
 		log.Fatalln("Bad schema version")
 	}
 
-	// JWKS
-	slog.Info(logMsgStartupJWKSFetch, slog.String("jwks", app.config.OIDC.JWKS))
-	app.kf, err = keyfunc.NewDefault([]string{app.config.OIDC.JWKS})
+	// Session key
+	slog.Info(logMsgStartupSessionKeyLoad, slog.String("key_file", app.config.Session.KeyFile))
+	app.sessionKey, err = loadSessionKey(app.config.Session.KeyFile)
 	if err != nil {
 		log.Fatalln(err)
 	}
 
+	// OIDC; the identity provider may only be left out when the
+	// development login is enabled.
+	if app.config.DevLogin.InsecureEnable {
+		slog.Warn(
+			logMsgStartupDevLogin,
+			slog.Bool("loopback", app.config.DevLogin.Loopback),
+			slog.Any("students", app.config.DevLogin.Students),
+			slog.Any("staff", app.config.DevLogin.Staff),
+		)
+	}
+	if app.config.OIDC.Issuer != "" || !app.config.DevLogin.InsecureEnable {
+		if !slices.Contains(app.config.OIDC.Scopes, "openid") {
+			log.Fatalln("oidc.scopes must include openid")
+		}
+		slog.Info(logMsgStartupOIDCDiscover, slog.String("issuer", app.config.OIDC.Issuer))
+		app.oidc, err = discoverOIDC(ctx, app.config.OIDC.Issuer)
+		if err != nil {
+			log.Fatalln(err)
+		}
+		slog.Info(logMsgStartupJWKSFetch, slog.String("jwks", app.oidc.JWKSURI))
+		app.kf, err = keyfunc.NewDefault([]string{app.oidc.JWKSURI})
+		if err != nil {
+			log.Fatalln(err)
+		}
+	}
+
 	// Templates
 	slog.Info(logMsgStartupTemplatesLoad)
 	err = app.admLoadTemplates()
 	if err != nil {
 		log.Fatalln(err)
 	}
+	err = app.tchLoadTemplates()
+	if err != nil {
+		log.Fatalln(err)
+	}
+	err = app.loginLoadTemplates()
+	if err != nil {
+		log.Fatalln(err)
+	}
 
 	// WebSocket hub
 	slog.Info(logMsgStartupWebsocketSetup)
-	app.wsHub = NewWebSocketHub()
+	app.wsHub = NewWebSocketHub(app.config.WebSocket.PingInterval, app.config.WebSocket.WriteTimeout, app.config.WebSocket.MaxPerStudent)
+	app.dashboard = NewAdminDashboard(app.queries, app.wsHub)
+	app.wsHub.observe = app.dashboard.observe
 	go app.wsHub.Run()
+	go app.dashboard.Run(context.Background())
+	if app.config.WebSocket.Cluster {
+		app.wsHub.cluster, err = NewWSCluster(app.wsHub, app.pool, app.queries)
+		if err != nil {
+			log.Fatalln(err)
+		}
+		go app.wsHub.cluster.Run(context.Background())
+	}
+	app.courseCounts = NewCourseCountBatcher(app.config.WebSocket.CountInterval, app.queries, app.wsHub)
+	go app.courseCounts.Run(context.Background())
+	app.changes = NewChangeDispatcher(app.pool, app.queries, app.wsHub, app.courseCounts, app.AbsGrades)
+	go app.changes.Run(context.Background())
+	app.events = NewEventLog(app.queries, app.wsHub, app.config.WebSocket.ReplayEvents)
+	app.scheduler = NewNotificationScheduler(app.pool, app.queries, app.events)
+	go app.scheduler.Run(context.Background())
+	app.admission = NewAdmissionQueue(app.config.Admission.MaxActive, app.config.Admission.MaxWait, app.wsHub)
 
 	// Router
 	slog.Info(logMsgStartupRoutesRegister)
 	mux := http.NewServeMux()
 	mux.HandleFunc("/{$}", app.handleIndex)
 	mux.HandleFunc("/auth", app.handleAuth)
+	if app.config.DevLogin.InsecureEnable {
+		mux.HandleFunc("/auth/dev", app.handleAuthDev)
+	}
+	mux.HandleFunc("/logout", app.handleLogout)
 	mux.Handle("/admin/static/", http.StripPrefix("/admin/static/", http.FileServer(http.Dir("admin_static"))))
-	mux.HandleFunc("/admin/{$}", app.adminOnly("handleAdm", app.handleAdm))
-	mux.HandleFunc("/admin/notify", app.adminOnly("handleAdmNotify", app.handleAdmNotify))
-	mux.HandleFunc("/admin/periods", app.adminOnly("handleAdmPeriods", app.handleAdmPeriods))
-	mux.HandleFunc("/admin/periods/new", app.adminOnly("handleAdmPeriodsNew", app.handleAdmPeriodsNew))
-	mux.HandleFunc("/admin/periods/delete", app.adminOnly("handleAdmPeriodsDelete", app.handleAdmPeriodsDelete))
-	mux.HandleFunc("/admin/categories", app.adminOnly("handleAdmCategories", app.handleAdmCategories))
-	mux.HandleFunc("/admin/categories/new", app.adminOnly("handleAdmCategoriesNew", app.handleAdmCategoriesNew))
-	mux.HandleFunc("/admin/categories/delete", app.adminOnly("handleAdmCategoriesDelete", app.handleAdmCategoriesDelete))
-	mux.HandleFunc("/admin/grades", app.adminOnly("handleAdmGrades", app.handleAdmGrades))
-	mux.HandleFunc("/admin/grades/new", app.adminOnly("handleAdmGradesNew", app.handleAdmGradesNew))
-	mux.HandleFunc("/admin/grades/edit", app.adminOnly("handleAdmGradesEdit", app.handleAdmGradesEdit))
-	mux.HandleFunc("/admin/grades/bulk-enabled-update", app.adminOnly("handleAdmGradesBulkEnabledUpdate", app.handleAdmGradesBulkEnabledUpdate))
-	mux.HandleFunc("/admin/grades/delete", app.adminOnly("handleAdmGradesDelete", app.handleAdmGradesDelete))
-	mux.HandleFunc("/admin/grades/new-requirement-group", app.adminOnly("handleAdmGradesNewRequirementGroup", app.handleAdmGradesNewRequirementGroup))
-	mux.HandleFunc("/admin/grades/delete-requirement-group", app.adminOnly("handleAdmGradesDeleteRequirementGroup", app.handleAdmGradesDeleteRequirementGroup))
-	mux.HandleFunc("/admin/courses", app.adminOnly("handleAdmCourses", app.handleAdmCourses))
-	mux.HandleFunc("/admin/courses/new", app.adminOnly("handleAdmCoursesNew", app.handleAdmCoursesNew))
-	mux.HandleFunc("/admin/courses/edit", app.adminOnly("handleAdmCoursesEdit", app.handleAdmCoursesEdit))
-	mux.HandleFunc("/admin/courses/delete", app.adminOnly("handleAdmCoursesDelete", app.handleAdmCoursesDelete))
-	mux.HandleFunc("/admin/courses/import", app.adminOnly("handleAdmCoursesImport", app.handleAdmCoursesImport))
-	mux.HandleFunc("/admin/students", app.adminOnly("handleAdmStudents", app.handleAdmStudents))
-	mux.HandleFunc("/admin/students/new", app.adminOnly("handleAdmStudentsNew", app.handleAdmStudentsNew))
-	mux.HandleFunc("/admin/students/edit", app.adminOnly("handleAdmStudentsEdit", app.handleAdmStudentsEdit))
-	mux.HandleFunc("/admin/students/delete", app.adminOnly("handleAdmStudentsDelete", app.handleAdmStudentsDelete))
-	mux.HandleFunc("/admin/students/import", app.adminOnly("handleAdmStudentsImport", app.handleAdmStudentsImport))
-	mux.HandleFunc("/admin/selections", app.adminOnly("handleAdmSelections", app.handleAdmSelections))
-	mux.HandleFunc("/admin/selections/export", app.adminOnly("handleAdmSelectionsExport", app.handleAdmSelectionsExport))
-	mux.HandleFunc("/admin/selections/new", app.adminOnly("handleAdmSelectionsNew", app.handleAdmSelectionsNew))
-	mux.HandleFunc("/admin/selections/edit", app.adminOnly("handleAdmSelectionsEdit", app.handleAdmSelectionsEdit))
-	mux.HandleFunc("/admin/selections/delete", app.adminOnly("handleAdmSelectionsDelete", app.handleAdmSelectionsDelete))
-	mux.HandleFunc("/admin/selections/import", app.adminOnly("handleAdmSelectionsImport", app.handleAdmSelectionsImport))
+	mux.HandleFunc("/admin/{$}", app.adminOnly("handleAdm", adminPermView, app.handleAdm))
+	mux.HandleFunc("/admin/dashboard", app.adminOnly("handleAdmDashboard", adminPermView, app.handleAdmDashboard))
+	mux.HandleFunc("/admin/api/dashboard/events", app.adminOnly("handleAdmDashboardEvents", adminPermView, app.handleAdmDashboardEvents))
+	mux.HandleFunc("/admin/notify", app.adminOnly("handleAdmNotify", adminPermView, app.handleAdmNotify))
+	mux.HandleFunc("/admin/notify/scheduled/edit", app.adminOnly("handleAdmNotifyScheduledEdit", adminPermNotify, app.handleAdmNotifyScheduledEdit))
+	mux.HandleFunc("/admin/notify/scheduled/cancel", app.adminOnly("handleAdmNotifyScheduledCancel", adminPermNotify, app.handleAdmNotifyScheduledCancel))
+	mux.HandleFunc("/admin/impersonate", app.adminOnly("handleAdmImpersonate", adminPermView, app.handleAdmImpersonate))
+	mux.HandleFunc("/admin/sessions", app.adminOnly("handleAdmSessions", adminPermAccounts, app.handleAdmSessions))
+	mux.HandleFunc("/admin/sessions/revoke", app.adminOnly("handleAdmSessionsRevoke", adminPermAccounts, app.handleAdmSessionsRevoke))
+	mux.HandleFunc("/admin/admins", app.adminOnly("handleAdmAdmins", adminPermAccounts, app.handleAdmAdmins))
+	mux.HandleFunc("/admin/admins/new", app.adminOnly("handleAdmAdminsNew", adminPermAccounts, app.handleAdmAdminsNew))
+	mux.HandleFunc("/admin/admins/edit", app.adminOnly("handleAdmAdminsEdit", adminPermAccounts, app.handleAdmAdminsEdit))
+	mux.HandleFunc("/admin/admins/delete", app.adminOnly("handleAdmAdminsDelete", adminPermAccounts, app.handleAdmAdminsDelete))
+	mux.HandleFunc("/admin/tokens", app.adminOnly("handleAdmTokens", adminPermView, app.handleAdmTokens))
+	mux.HandleFunc("/admin/tokens/new", app.adminOnly("handleAdmTokensNew", adminPermView, app.handleAdmTokensNew))
+	mux.HandleFunc("/admin/tokens/revoke", app.adminOnly("handleAdmTokensRevoke", adminPermView, app.handleAdmTokensRevoke))
+	mux.HandleFunc("/admin/teachers", app.adminOnly("handleAdmTeachers", adminPermView, app.handleAdmTeachers))
+	mux.HandleFunc("/admin/teachers/new", app.adminOnly("handleAdmTeachersNew", adminPermSetup, app.handleAdmTeachersNew))
+	mux.HandleFunc("/admin/teachers/edit", app.adminOnly("handleAdmTeachersEdit", adminPermSetup, app.handleAdmTeachersEdit))
+	mux.HandleFunc("/admin/teachers/delete", app.adminOnly("handleAdmTeachersDelete", adminPermSetup, app.handleAdmTeachersDelete))
+	mux.HandleFunc("/admin/periods", app.adminOnly("handleAdmPeriods", adminPermView, app.handleAdmPeriods))
+	mux.HandleFunc("/admin/periods/new", app.adminOnly("handleAdmPeriodsNew", adminPermSetup, app.handleAdmPeriodsNew))
+	mux.HandleFunc("/admin/periods/delete", app.adminOnly("handleAdmPeriodsDelete", adminPermSetup, app.handleAdmPeriodsDelete))
+	mux.HandleFunc("/admin/categories", app.adminOnly("handleAdmCategories", adminPermView, app.handleAdmCategories))
+	mux.HandleFunc("/admin/categories/new", app.adminOnly("handleAdmCategoriesNew", adminPermSetup, app.handleAdmCategoriesNew))
+	mux.HandleFunc("/admin/categories/delete", app.adminOnly("handleAdmCategoriesDelete", adminPermSetup, app.handleAdmCategoriesDelete))
+	mux.HandleFunc("/admin/grades", app.adminOnly("handleAdmGrades", adminPermView, app.handleAdmGrades))
+	mux.HandleFunc("/admin/grades/new", app.adminOnly("handleAdmGradesNew", adminPermSetup, app.handleAdmGradesNew))
+	mux.HandleFunc("/admin/grades/edit", app.adminOnly("handleAdmGradesEdit", adminPermSetup, app.handleAdmGradesEdit))
+	mux.HandleFunc("/admin/grades/bulk-enabled-update", app.adminOnly("handleAdmGradesBulkEnabledUpdate", adminPermSetup, app.handleAdmGradesBulkEnabledUpdate))
+	mux.HandleFunc("/admin/grades/delete", app.adminOnly("handleAdmGradesDelete", adminPermSetup, app.handleAdmGradesDelete))
+	mux.HandleFunc("/admin/grades/new-requirement-group", app.adminOnly("handleAdmGradesNewRequirementGroup", adminPermSetup, app.handleAdmGradesNewRequirementGroup))
+	mux.HandleFunc("/admin/grades/delete-requirement-group", app.adminOnly("handleAdmGradesDeleteRequirementGroup", adminPermSetup, app.handleAdmGradesDeleteRequirementGroup))
+	mux.HandleFunc("/admin/courses", app.adminOnly("handleAdmCourses", adminPermView, app.handleAdmCourses))
+	mux.HandleFunc("/admin/courses/new", app.adminOnly("handleAdmCoursesNew", adminPermSetup, app.handleAdmCoursesNew))
+	mux.HandleFunc("/admin/courses/edit", app.adminOnly("handleAdmCoursesEdit", adminPermSetup, app.handleAdmCoursesEdit))
+	mux.HandleFunc("/admin/courses/delete", app.adminOnly("handleAdmCoursesDelete", adminPermSetup, app.handleAdmCoursesDelete))
+	mux.HandleFunc("/admin/courses/import", app.adminOnly("handleAdmCoursesImport", adminPermSetup, app.handleAdmCoursesImport))
+	mux.HandleFunc("/admin/students", app.adminOnly("handleAdmStudents", adminPermView, app.handleAdmStudents))
+	mux.HandleFunc("/admin/students/new", app.adminOnly("handleAdmStudentsNew", adminPermSetup, app.handleAdmStudentsNew))
+	mux.HandleFunc("/admin/students/edit", app.adminOnly("handleAdmStudentsEdit", adminPermSetup, app.handleAdmStudentsEdit))
+	mux.HandleFunc("/admin/students/delete", app.adminOnly("handleAdmStudentsDelete", adminPermSetup, app.handleAdmStudentsDelete))
+	mux.HandleFunc("/admin/students/import", app.adminOnly("handleAdmStudentsImport", adminPermSetup, app.handleAdmStudentsImport))
+	mux.HandleFunc("/admin/selections", app.adminOnly("handleAdmSelections", adminPermView, app.handleAdmSelections))
+	mux.HandleFunc("/admin/selections/export", app.adminOnly("handleAdmSelectionsExport", adminPermView, app.handleAdmSelectionsExport))
+	mux.HandleFunc("/admin/selections/new", app.adminOnly("handleAdmSelectionsNew", adminPermSelections, app.handleAdmSelectionsNew))
+	mux.HandleFunc("/admin/selections/edit", app.adminOnly("handleAdmSelectionsEdit", adminPermSelections, app.handleAdmSelectionsEdit))
+	mux.HandleFunc("/admin/selections/delete", app.adminOnly("handleAdmSelectionsDelete", adminPermSelections, app.handleAdmSelectionsDelete))
+	mux.HandleFunc("/admin/selections/import", app.adminOnly("handleAdmSelectionsImport", adminPermSelections, app.handleAdmSelectionsImport))
+	mux.HandleFunc("/teacher/{$}", app.teacherOnly("handleTch", app.handleTch))
+	mux.HandleFunc("/teacher/roster.csv", app.teacherOnly("handleTchRoster", app.handleTchRoster))
+	mux.HandleFunc("/teacher/api/events", app.teacherOnly("handleTchEvents", app.handleTchEvents))
 	mux.HandleFunc("/student", app.studentOnly("handleStu", app.handleStu))
+	mux.HandleFunc("/student/impersonation/end", app.handleStuImpersonationEnd)
 	mux.Handle("/student/assets/", http.StripPrefix("/student/assets/", http.FileServer(http.Dir("frontend/dist/assets/"))))
 	mux.HandleFunc("/student/", app.studentOnlyPlain("studentFrontend", func(w http.ResponseWriter, r *http.Request) {
 		if !strings.HasPrefix(r.URL.Path, "/student/assets/") {
@@ -147,12 +231,22 @@ func main() {
 		}
 	}))
 	mux.HandleFunc("/student/api/events", app.studentOnly("handleStuAPIEvents", app.handleStuAPIEvents))
+	mux.HandleFunc("/student/api/events/sse", app.studentOnly("handleStuAPIEventsSSE", app.handleStuAPIEventsSSE))
+	mux.HandleFunc("/student/api/events/schema.json", app.studentOnlyPlain("studentEventsSchema", func(w http.ResponseWriter, r *http.Request) {
+		http.ServeFile(w, r, "./ws_schema.json")
+	}))
+	mux.HandleFunc("/student/api/snapshot", app.studentOnly("handleStuAPISnapshot", app.handleStuAPISnapshot))
 	mux.HandleFunc("/student/api/user_info", app.studentOnly("handleStuAPIInfo", app.handleStuAPIInfo))
 	mux.HandleFunc("/student/api/courses", app.studentOnly("handleStuAPICourses", app.handleStuAPICourses))
 	mux.HandleFunc("/student/api/periods", app.studentOnly("handleStuAPIPeriods", app.handleStuAPIPeriods))
 	mux.HandleFunc("/student/api/categories", app.studentOnly("handleStuAPICategories", app.handleStuAPICategories))
 	mux.HandleFunc("/student/api/grades", app.studentOnly("handleStuAPIGrades", app.handleStuAPIGrades))
 	mux.HandleFunc("/student/api/my_selections", app.studentOnly("handleStuAPIMySelections", app.handleStuAPIMySelections))
+	mux.HandleFunc("/student/api/timetable", app.studentOnly("handleStuAPITimetable", app.handleStuAPITimetable))
+	mux.HandleFunc("/student/api/notifications", app.studentOnly("handleStuAPINotifications", app.handleStuAPINotifications))
+	mux.HandleFunc("/student/api/notifications/read", app.studentOnly("handleStuAPINotificationsRead", app.handleStuAPINotificationsRead))
+	mux.HandleFunc("/student/api/sessions", app.studentOnly("handleStuAPISessions", app.handleStuAPISessions))
+	mux.HandleFunc("/student/api/sessions/revoke", app.studentOnly("handleStuAPISessionsRevoke", app.handleStuAPISessionsRevoke))
 
 	// Listen and serve
 	slog.Info(logMsgStartupListenerStart, slog.String("transport", app.config.Listen.Transport), slog.String("address", app.config.Listen.Address), slog.String("network", app.config.Listen.Network))
@@ -9,23 +9,55 @@ import (
 
 	"git.sr.ht/~runxiyu/cca/db"
 	"github.com/jackc/pgx/v5"
-	"github.com/jackc/pgx/v5/pgtype"
 )
 
 type UserInfo interface {
 	isUserInfo()
 }
 
-type UserInfoStudent db.GetStudentBySessionRow
+type UserInfoStudent struct {
+	db.Student
+	// SessionID is the session the request was made with.
+	SessionID int64 `json:"-"`
+	// Impersonation is set if an administrator is viewing the site as the
+	// student.
+	Impersonation *studentImpersonation `json:"impersonation,omitempty"`
+}
 
 func (u *UserInfoStudent) isUserInfo() {}
 
-type UserInfoAdmin db.GetAdminBySessionRow
+type UserInfoAdmin struct {
+	db.Admin
+	// Grades are the grades the administrator is responsible for, which
+	// only matter if AllGrades is false.
+	Grades    []string `json:"-"`
+	SessionID int64    `json:"-"`
+	// APIToken is set instead of SessionID if the request was made with an
+	// API token.
+	APIToken *apiTokenInfo `json:"-"`
+}
 
 func (u *UserInfoAdmin) isUserInfo() {}
 
-func (app *App) authenticateRequest(r *http.Request) (UserInfo, error) {
-	cookie, err := r.Cookie("session")
+type UserInfoTeacher struct {
+	db.Teacher
+	SessionID int64 `json:"-"`
+}
+
+func (u *UserInfoTeacher) isUserInfo() {}
+
+// authenticateRequest authenticates with an API token if the request has an
+// Authorization: Bearer header, and with the session cookie otherwise.
+func (app *App) authenticateRequest(w http.ResponseWriter, r *http.Request) (UserInfo, error) {
+	if token, ok := bearerToken(r); ok {
+		aui, err := app.authenticateAPIToken(r, token)
+		if aui == nil {
+			return nil, err
+		}
+		return aui, nil
+	}
+
+	cookie, err := r.Cookie(sessionCookie)
 	if err != nil {
 		return nil, fmt.Errorf("fetch cookie: %w", err)
 	}
@@ -37,37 +69,35 @@ func (app *App) authenticateRequest(r *http.Request) (UserInfo, error) {
 
 	switch ty {
 	case "student":
-		u, err := app.queries.GetStudentBySession(
-			r.Context(),
-			pgtype.Text{
-				String: st,
-				Valid:  true,
-			},
-		)
+		u, err := app.queries.GetStudentBySession(r.Context(), app.hashSessionToken(st))
 		if err != nil {
 			if errors.Is(err, pgx.ErrNoRows) {
 				return nil, nil
 			}
 			return nil, fmt.Errorf("fetch student by session: %w", err)
 		}
-		uu := UserInfoStudent(u)
-		return &uu, nil
+		app.renewSession(w, r, cookie, u.SessionID, u.LastSeenAt.Time)
+		return &UserInfoStudent{Student: u.Student, SessionID: u.SessionID}, nil
 	case "admin":
-		u, err := app.queries.GetAdminBySession(
-			r.Context(),
-			pgtype.Text{
-				String: st,
-				Valid:  true,
-			},
-		)
+		u, err := app.queries.GetAdminBySession(r.Context(), app.hashSessionToken(st))
 		if err != nil {
 			if errors.Is(err, pgx.ErrNoRows) {
 				return nil, nil
 			}
 			return nil, fmt.Errorf("fetch fetching admin by session: %w", err)
 		}
-		uu := UserInfoAdmin(u)
-		return &uu, nil
+		app.renewSession(w, r, cookie, u.SessionID, u.LastSeenAt.Time)
+		return &UserInfoAdmin{Admin: u.Admin, Grades: u.Grades, SessionID: u.SessionID}, nil
+	case "teacher":
+		u, err := app.queries.GetTeacherBySession(r.Context(), app.hashSessionToken(st))
+		if err != nil {
+			if errors.Is(err, pgx.ErrNoRows) {
+				return nil, nil
+			}
+			return nil, fmt.Errorf("fetch teacher by session: %w", err)
+		}
+		app.renewSession(w, r, cookie, u.SessionID, u.LastSeenAt.Time)
+		return &UserInfoTeacher{Teacher: u.Teacher, SessionID: u.SessionID}, nil
 	default:
 		return nil, fmt.Errorf("malformed session cookie contains unknown session type")
 	}
@@ -82,7 +112,33 @@ func (app *App) studentOnlyPlain(handlerName string, handler func(w http.Respons
 func (app *App) studentOnly(handlerName string, handler func(w http.ResponseWriter, r *http.Request, sui *UserInfoStudent)) func(w http.ResponseWriter, r *http.Request) {
 	return func(w http.ResponseWriter, r *http.Request) {
 		app.logRequestStart(r, handlerName, slog.String("middleware", "studentOnly"))
-		ui, err := app.authenticateRequest(r)
+		sui, err := app.authenticateImpersonation(w, r)
+		if err != nil {
+			app.respondHTTPError(r, w, http.StatusInternalServerError, "Internal Server Error\n"+err.Error(), err)
+			return
+		}
+		if sui != nil {
+			attrs := []slog.Attr{
+				slog.Int64("student_id", sui.ID),
+				slog.String("admin_username", sui.Impersonation.Admin),
+				slog.Bool("read_only", sui.Impersonation.ReadOnly),
+				slog.String("method", r.Method),
+			}
+			if r.Method != http.MethodGet && r.Method != http.MethodHead {
+				if sui.Impersonation.ReadOnly {
+					app.apiError(r, w, http.StatusForbidden, "You are viewing as this student read-only", attrs...)
+					return
+				}
+				// Every change made as a student is logged with the
+				// administrator who made it.
+				app.logInfo(r, logMsgAuthImpersonationWrite, attrs...)
+			}
+			app.logInfo(r, logMsgAuthMiddlewareStudent, append(attrs, slog.String("middleware", "studentOnly"))...)
+			handler(w, r, sui)
+			return
+		}
+
+		ui, err := app.authenticateRequest(w, r)
 		if err != nil {
 			http.Redirect(w, r, "/", http.StatusSeeOther)
 			return
@@ -97,20 +153,64 @@ func (app *App) studentOnly(handlerName string, handler func(w http.ResponseWrit
 	}
 }
 
-func (app *App) adminOnly(handlerName string, handler func(w http.ResponseWriter, r *http.Request, aui *UserInfoAdmin)) func(w http.ResponseWriter, r *http.Request) {
+// adminOnly also checks that the administrator's role, and the scopes of the
+// API token if one was used, have permission, which is the least a route
+// needs. Requests with an API token are answered with 401 instead of being
+// redirected to log in, and need no CSRF token as they carry no cookie.
+func (app *App) adminOnly(handlerName string, permission adminPermission, handler func(w http.ResponseWriter, r *http.Request, aui *UserInfoAdmin)) func(w http.ResponseWriter, r *http.Request) {
 	return func(w http.ResponseWriter, r *http.Request) {
 		app.logRequestStart(r, handlerName, slog.String("middleware", "adminOnly"))
-		ui, err := app.authenticateRequest(r)
+		ui, err := app.authenticateRequest(w, r)
+		aui, ok := ui.(*UserInfoAdmin)
+		if err != nil || !ok {
+			if _, bearer := bearerToken(r); bearer {
+				w.Header().Set("WWW-Authenticate", `Bearer realm="cca"`)
+				app.respondHTTPError(r, w, http.StatusUnauthorized, "Unauthorized\nInvalid or expired API token", err)
+				return
+			}
+			http.Redirect(w, r, "/", http.StatusSeeOther)
+			return
+		}
+		if !aui.can(permission) {
+			if aui.APIToken != nil {
+				app.respondHTTPError(r, w, http.StatusForbidden, "Forbidden\nYour role or the scopes of this API token do not allow this", nil,
+					slog.String("admin_username", aui.Username), slog.String("role", string(aui.Role)), slog.Int64("api_token_id", aui.APIToken.ID))
+				return
+			}
+			app.respondHTTPError(r, w, http.StatusForbidden, "Forbidden\nYour role does not allow this", nil,
+				slog.String("admin_username", aui.Username), slog.String("role", string(aui.Role)))
+			return
+		}
+		if aui.APIToken != nil {
+			app.logInfo(r, logMsgAuthMiddlewareAdmin, slog.String("middleware", "adminOnly"), slog.String("admin_username", aui.Username), slog.Int64("api_token_id", aui.APIToken.ID))
+			handler(w, r, aui)
+			return
+		}
+		if r.Method != http.MethodGet && r.Method != http.MethodHead && !app.checkCSRF(r, aui.SessionID) {
+			app.respondHTTPError(r, w, http.StatusForbidden, "Forbidden\nMissing or invalid CSRF token; reload the page and try again", nil,
+				slog.String("admin_username", aui.Username))
+			return
+		}
+		r = withCSRFToken(r, app.csrfToken(aui.SessionID))
+		app.logInfo(r, logMsgAuthMiddlewareAdmin, slog.String("middleware", "adminOnly"), slog.String("admin_username", aui.Username))
+		handler(w, r, aui)
+	}
+}
+
+func (app *App) teacherOnly(handlerName string, handler func(w http.ResponseWriter, r *http.Request, tui *UserInfoTeacher)) func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		app.logRequestStart(r, handlerName, slog.String("middleware", "teacherOnly"))
+		ui, err := app.authenticateRequest(w, r)
 		if err != nil {
 			http.Redirect(w, r, "/", http.StatusSeeOther)
 			return
 		}
-		aui, ok := ui.(*UserInfoAdmin)
+		tui, ok := ui.(*UserInfoTeacher)
 		if !ok {
 			http.Redirect(w, r, "/", http.StatusSeeOther)
 			return
 		}
-		app.logInfo(r, logMsgAuthMiddlewareAdmin, slog.String("middleware", "adminOnly"), slog.String("admin_username", aui.Username))
-		handler(w, r, aui)
+		app.logInfo(r, logMsgAuthMiddlewareTeacher, slog.String("middleware", "teacherOnly"), slog.String("teacher_username", tui.Username))
+		handler(w, r, tui)
 	}
 }
@@ -0,0 +1,226 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgtype"
+	"github.com/jackc/pgx/v5/pgxpool"
+
+	"git.sr.ht/~runxiyu/cca/db"
+)
+
+// NotificationScheduler sends scheduled notifications when they are due.
+// Schedules are kept in the database and claimed with SKIP LOCKED, so they
+// survive restarts and each is sent by one instance only.
+type NotificationScheduler struct {
+	pool    *pgxpool.Pool
+	queries *db.Queries
+	events  *EventLog
+
+	mu          sync.Mutex
+	sentTotal   int64
+	errorsTotal int64
+	lastSent    time.Time
+}
+
+type NotificationSchedulerStats struct {
+	SentTotal   int64
+	ErrorsTotal int64
+	LastSent    time.Time
+}
+
+const (
+	notifySchedulerInterval = 15 * time.Second
+	notifySchedulerBatch    = 20
+	// notifySchedulerRetryDelay is how long a scheduled notification that
+	// failed to send waits before it is tried again, so that it neither
+	// holds up the others nor is retried on every tick.
+	notifySchedulerRetryDelay = 5 * time.Minute
+)
+
+func NewNotificationScheduler(pool *pgxpool.Pool, queries *db.Queries, events *EventLog) *NotificationScheduler {
+	return &NotificationScheduler{
+		pool:    pool,
+		queries: queries,
+		events:  events,
+	}
+}
+
+func (s *NotificationScheduler) Run(ctx context.Context) {
+	ticker := time.NewTicker(notifySchedulerInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		for {
+			n, err := s.runDue(ctx)
+			if err != nil {
+				s.mu.Lock()
+				s.errorsTotal++
+				s.mu.Unlock()
+				slog.Error(logMsgNotifySchedulerError, slog.Any("error", err))
+				break
+			}
+			if n < notifySchedulerBatch {
+				break
+			}
+		}
+	}
+}
+
+type scheduledSend struct {
+	scheduledID    int64
+	notificationID int64
+	studentIDs     []int64
+	recipients     int
+	text           string
+}
+
+// runDue sends one batch of due notifications and returns how many were
+// claimed. Recipients are chosen now rather than when the notification was
+// scheduled. Each notification is sent in its own savepoint; one that fails
+// is rolled back, logged and retried after notifySchedulerRetryDelay, while
+// the rest of the batch goes ahead.
+func (s *NotificationScheduler) runDue(ctx context.Context) (int, error) {
+	tx, err := s.pool.Begin(ctx)
+	if err != nil {
+		return 0, err
+	}
+	defer func() {
+		_ = tx.Rollback(ctx)
+	}()
+	qtx := s.queries.WithTx(tx)
+
+	rows, err := qtx.ClaimDueScheduledNotifications(ctx, notifySchedulerBatch)
+	if err != nil {
+		return 0, fmt.Errorf("claim scheduled notifications: %w", err)
+	}
+
+	now := time.Now()
+	var sends []scheduledSend
+	for _, row := range rows {
+		sp, err := tx.Begin(ctx)
+		if err != nil {
+			return 0, err
+		}
+		send, err := runScheduled(ctx, s.queries.WithTx(sp), row, now)
+		if err == nil {
+			err = sp.Commit(ctx)
+		}
+		if err != nil {
+			_ = sp.Rollback(ctx)
+			s.mu.Lock()
+			s.errorsTotal++
+			s.mu.Unlock()
+			slog.Error(logMsgNotifySchedulerError, slog.Int64("scheduled_id", row.ID), slog.Any("error", err))
+			if err := qtx.FinishScheduledNotificationRun(ctx, db.FinishScheduledNotificationRunParams{
+				ID:     row.ID,
+				SendAt: pgtype.Timestamptz{Time: now.Add(notifySchedulerRetryDelay), Valid: true},
+				Status: "pending",
+				Sent:   false,
+			}); err != nil {
+				return 0, fmt.Errorf("postpone scheduled notification %d: %w", row.ID, err)
+			}
+			continue
+		}
+		if send != nil {
+			sends = append(sends, *send)
+		}
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return 0, err
+	}
+
+	for _, send := range sends {
+		// The notification is already stored, so a failure here only
+		// delays it until the recipients next load the page.
+		if err := s.events.Publish(ctx, send.studentIDs, WSNotify{NotificationID: send.notificationID, Text: send.text}); err != nil {
+			slog.Error(logMsgNotifySchedulerError, slog.Int64("scheduled_id", send.scheduledID), slog.Any("error", err))
+		}
+		slog.Info(logMsgNotifySchedulerSent, slog.Int64("scheduled_id", send.scheduledID), slog.Int64("notification_id", send.notificationID), slog.Int("recipients", send.recipients))
+	}
+
+	if len(sends) > 0 {
+		s.mu.Lock()
+		s.sentTotal += int64(len(sends))
+		s.lastSent = now
+		s.mu.Unlock()
+	}
+	return len(rows), nil
+}
+
+// runScheduled stores one due notification, unless no student matches its
+// recipients, and moves it on to its next run. It returns what to publish
+// once the batch is committed, or nil if nothing was sent.
+func runScheduled(ctx context.Context, q *db.Queries, row db.ScheduledNotification, now time.Time) (*scheduledSend, error) {
+	form := scheduledNotifyForm(row)
+	studentIDs, _, recipients, err := notifyRecipients(ctx, q, form)
+	if err != nil {
+		return nil, fmt.Errorf("resolve recipients of scheduled notification %d: %w", row.ID, err)
+	}
+
+	var send *scheduledSend
+	sent := recipients > 0
+	if sent {
+		notificationID, err := newNotification(ctx, q, row.Sender, form, studentIDs, recipients)
+		if err != nil {
+			return nil, fmt.Errorf("store scheduled notification %d: %w", row.ID, err)
+		}
+		send = &scheduledSend{
+			scheduledID:    row.ID,
+			notificationID: notificationID,
+			studentIDs:     studentIDs,
+			recipients:     recipients,
+			text:           row.Text,
+		}
+	}
+
+	next, status := nextScheduledRun(row, sent, now)
+	if err := q.FinishScheduledNotificationRun(ctx, db.FinishScheduledNotificationRunParams{
+		ID:     row.ID,
+		SendAt: pgtype.Timestamptz{Time: next, Valid: true},
+		Status: status,
+		Sent:   sent,
+	}); err != nil {
+		return nil, fmt.Errorf("update scheduled notification %d: %w", row.ID, err)
+	}
+	return send, nil
+}
+
+// nextScheduledRun returns when a scheduled notification that was just due
+// is sent next, and its new status. A recurring notification stops once no
+// student matches its recipients. Runs missed while no instance was running
+// are skipped rather than sent in a burst.
+func nextScheduledRun(row db.ScheduledNotification, sent bool, now time.Time) (time.Time, string) {
+	next := row.SendAt.Time
+	if !row.RepeatSeconds.Valid || !sent {
+		return next, "done"
+	}
+	interval := time.Duration(row.RepeatSeconds.Int64) * time.Second
+	if !next.After(now) {
+		next = next.Add((now.Sub(next)/interval + 1) * interval)
+	}
+	if row.RepeatUntil.Valid && next.After(row.RepeatUntil.Time) {
+		return next, "done"
+	}
+	return next, "pending"
+}
+
+func (s *NotificationScheduler) Stats() NotificationSchedulerStats {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return NotificationSchedulerStats{
+		SentTotal:   s.sentTotal,
+		ErrorsTotal: s.errorsTotal,
+		LastSent:    s.lastSent,
+	}
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgtype"
+
+	"git.sr.ht/~runxiyu/cca/db"
+)
+
+func TestNextScheduledRun(t *testing.T) {
+	now := time.Date(2026, 3, 2, 12, 0, 0, 0, time.UTC)
+	at := func(d time.Duration) pgtype.Timestamptz {
+		return pgtype.Timestamptz{Time: now.Add(d), Valid: true}
+	}
+	hourly := pgtype.Int8{Int64: 3600, Valid: true}
+
+	tests := []struct {
+		name       string
+		row        db.ScheduledNotification
+		sent       bool
+		wantNext   time.Time
+		wantStatus string
+	}{
+		{"once", db.ScheduledNotification{SendAt: at(-time.Minute)}, true, now.Add(-time.Minute), "done"},
+		{"repeating", db.ScheduledNotification{SendAt: at(-time.Minute), RepeatSeconds: hourly}, true, now.Add(59 * time.Minute), "pending"},
+		{"missed runs skipped", db.ScheduledNotification{SendAt: at(-150 * time.Minute), RepeatSeconds: hourly}, true, now.Add(30 * time.Minute), "pending"},
+		{"due exactly now", db.ScheduledNotification{SendAt: at(0), RepeatSeconds: hourly}, true, now.Add(time.Hour), "pending"},
+		{"no recipients", db.ScheduledNotification{SendAt: at(-time.Minute), RepeatSeconds: hourly}, false, now.Add(-time.Minute), "done"},
+		{"past repeat_until", db.ScheduledNotification{SendAt: at(-time.Minute), RepeatSeconds: hourly, RepeatUntil: at(30 * time.Minute)}, true, now.Add(59 * time.Minute), "done"},
+		{"before repeat_until", db.ScheduledNotification{SendAt: at(-time.Minute), RepeatSeconds: hourly, RepeatUntil: at(2 * time.Hour)}, true, now.Add(59 * time.Minute), "pending"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			next, status := nextScheduledRun(tt.row, tt.sent, now)
+			if !next.Equal(tt.wantNext) || status != tt.wantStatus {
+				t.Errorf("nextScheduledRun() = %v, %q, want %v, %q", next, status, tt.wantNext, tt.wantStatus)
+			}
+		})
+	}
+}
+
+// TestRunDueContinuesAfterFailure checks that a scheduled notification that
+// fails is postponed without holding up the one behind it.
+func TestRunDueContinuesAfterFailure(t *testing.T) {
+	pool := testPool(t)
+	ctx := t.Context()
+	q := db.New(pool)
+	hub := NewWebSocketHub(0, 0, 0)
+	go hub.Run()
+	s := NewNotificationScheduler(pool, q, NewEventLog(q, hub, 100))
+
+	newScheduled := func(target string, sendAt time.Time) int64 {
+		id, err := q.NewScheduledNotification(ctx, db.NewScheduledNotificationParams{
+			Text:      "test",
+			Sender:    "test",
+			Target:    target,
+			Grades:    []string{},
+			CourseIds: []string{},
+			Periods:   []string{},
+			SendAt:    pgtype.Timestamptz{Time: sendAt, Valid: true},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() {
+			_, _ = q.CancelScheduledNotification(ctx, db.CancelScheduledNotificationParams{ID: id})
+		})
+		return id
+	}
+	broken := newScheduled("no such target", time.Now().Add(-2*time.Hour))
+	good := newScheduled(notifyTargetAll, time.Now().Add(-time.Hour))
+
+	start := time.Now()
+	if _, err := s.runDue(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if s.Stats().ErrorsTotal == 0 {
+		t.Fatal("failure not counted")
+	}
+
+	pending, err := q.GetPendingScheduledNotifications(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, row := range pending {
+		switch row.ID {
+		case good:
+			t.Fatal("notification behind a failing one was not sent")
+		case broken:
+			if row.SendAt.Time.Before(start.Add(notifySchedulerRetryDelay)) {
+				t.Fatalf("failed notification retried at %v, before the retry delay", row.SendAt.Time)
+			}
+			return
+		}
+	}
+	t.Fatal("failed notification is no longer pending")
+}
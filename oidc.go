@@ -0,0 +1,210 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"time"
+)
+
+// The pre-auth cookie binds the state, nonce and PKCE verifier of a login to
+// the browser that started it. It is only sent to /auth and only lives as
+// long as a login may reasonably take.
+const (
+	oidcPreAuthCookie   = "oidc_preauth"
+	oidcPreAuthLifetime = 10 * time.Minute
+)
+
+var oidcHTTPClient = &http.Client{Timeout: 10 * time.Second}
+
+// oidcProvider is the part of the discovery document of an OpenID Connect
+// provider that we use.
+type oidcProvider struct {
+	Issuer                string   `json:"issuer"`
+	AuthorizationEndpoint string   `json:"authorization_endpoint"`
+	TokenEndpoint         string   `json:"token_endpoint"`
+	JWKSURI               string   `json:"jwks_uri"`
+	CodeChallengeMethods  []string `json:"code_challenge_methods_supported"`
+}
+
+// discoverOIDC fetches the discovery document of an issuer. As required by
+// OpenID Connect Discovery, the issuer in the document must be identical to
+// the one it was fetched from, as ID tokens are checked against it.
+func discoverOIDC(ctx context.Context, issuer string) (oidcProvider, error) {
+	configURL := strings.TrimRight(issuer, "/") + "/.well-known/openid-configuration"
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, configURL, nil)
+	if err != nil {
+		return oidcProvider{}, fmt.Errorf("discover OIDC provider: %w", err)
+	}
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := oidcHTTPClient.Do(req)
+	if err != nil {
+		return oidcProvider{}, fmt.Errorf("discover OIDC provider: %w", err)
+	}
+	defer func() {
+		_ = resp.Body.Close()
+	}()
+	if resp.StatusCode != http.StatusOK {
+		return oidcProvider{}, fmt.Errorf("discover OIDC provider: %s returned status %d", configURL, resp.StatusCode)
+	}
+
+	var p oidcProvider
+	if err := json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(&p); err != nil {
+		return oidcProvider{}, fmt.Errorf("discover OIDC provider: parse %s: %w", configURL, err)
+	}
+	switch {
+	case p.Issuer != issuer:
+		return oidcProvider{}, fmt.Errorf("discover OIDC provider: %s is for issuer %q, not %q", configURL, p.Issuer, issuer)
+	case p.AuthorizationEndpoint == "" || p.TokenEndpoint == "" || p.JWKSURI == "":
+		return oidcProvider{}, fmt.Errorf("discover OIDC provider: %s lacks the authorization, token or JWKS endpoint", configURL)
+	case p.CodeChallengeMethods != nil && !slices.Contains(p.CodeChallengeMethods, "S256"):
+		// Providers that do not list their PKCE methods are assumed
+		// to support S256.
+		return oidcProvider{}, fmt.Errorf("discover OIDC provider: %s does not support PKCE with S256", configURL)
+	}
+	return p, nil
+}
+
+type oidcPreAuth struct {
+	State    string
+	Nonce    string
+	Verifier string
+}
+
+func newOIDCPreAuth() oidcPreAuth {
+	return oidcPreAuth{
+		State: rand.Text(),
+		Nonce: rand.Text(),
+		// RFC 7636 requires at least 43 characters.
+		Verifier: rand.Text() + rand.Text(),
+	}
+}
+
+// rand.Text never contains dots.
+func (p oidcPreAuth) cookie() *http.Cookie {
+	return &http.Cookie{
+		Name:     oidcPreAuthCookie,
+		Value:    p.State + "." + p.Nonce + "." + p.Verifier,
+		Path:     "/auth",
+		SameSite: http.SameSiteLaxMode,
+		HttpOnly: true,
+		Secure:   true,
+		MaxAge:   int(oidcPreAuthLifetime / time.Second),
+	}
+}
+
+var errOIDCPreAuthMissing = errors.New("missing or malformed pre-auth cookie")
+
+func oidcPreAuthFromRequest(r *http.Request) (oidcPreAuth, error) {
+	cookie, err := r.Cookie(oidcPreAuthCookie)
+	if err != nil {
+		return oidcPreAuth{}, errOIDCPreAuthMissing
+	}
+	parts := strings.Split(cookie.Value, ".")
+	if len(parts) != 3 || parts[0] == "" || parts[1] == "" || parts[2] == "" {
+		return oidcPreAuth{}, errOIDCPreAuthMissing
+	}
+	return oidcPreAuth{State: parts[0], Nonce: parts[1], Verifier: parts[2]}, nil
+}
+
+// clearOIDCPreAuth removes the pre-auth cookie so that each login can only
+// be completed once.
+func clearOIDCPreAuth(w http.ResponseWriter) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     oidcPreAuthCookie,
+		Path:     "/auth",
+		SameSite: http.SameSiteLaxMode,
+		HttpOnly: true,
+		Secure:   true,
+		MaxAge:   -1,
+	})
+}
+
+func oidcCodeChallenge(verifier string) string {
+	sum := sha256.Sum256([]byte(verifier))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+func equalConstantTime(a, b string) bool {
+	return subtle.ConstantTimeCompare([]byte(a), []byte(b)) == 1
+}
+
+func buildOIDCAuthorizeURL(authorizeEndpoint, clientID, redirectURI string, scopes []string, preAuth oidcPreAuth) (string, error) {
+	u, err := url.Parse(authorizeEndpoint)
+	if err != nil {
+		return "", err
+	}
+
+	q := u.Query()
+	q.Set("client_id", clientID)
+	q.Set("response_type", "code")
+	q.Set("redirect_uri", redirectURI)
+	q.Set("response_mode", "query")
+	q.Set("scope", strings.Join(scopes, " "))
+	q.Set("state", preAuth.State)
+	q.Set("nonce", preAuth.Nonce)
+	q.Set("code_challenge", oidcCodeChallenge(preAuth.Verifier))
+	q.Set("code_challenge_method", "S256")
+
+	u.RawQuery = q.Encode()
+	return u.String(), nil
+}
+
+type oidcTokenResponse struct {
+	IDToken          string `json:"id_token"`
+	Error            string `json:"error"`
+	ErrorDescription string `json:"error_description"`
+}
+
+// exchangeOIDCCode redeems an authorization code at the token endpoint and
+// returns the ID token. The redirect URI must be the one the code was
+// requested with.
+func (app *App) exchangeOIDCCode(ctx context.Context, code, redirectURI, verifier string) (string, error) {
+	form := url.Values{}
+	form.Set("grant_type", "authorization_code")
+	form.Set("code", code)
+	form.Set("redirect_uri", redirectURI)
+	form.Set("client_id", app.config.OIDC.Client)
+	form.Set("code_verifier", verifier)
+	if app.config.OIDC.Secret != "" {
+		form.Set("client_secret", app.config.OIDC.Secret)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, app.oidc.TokenEndpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := oidcHTTPClient.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer func() {
+		_ = resp.Body.Close()
+	}()
+
+	var token oidcTokenResponse
+	if err := json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(&token); err != nil {
+		return "", fmt.Errorf("token endpoint returned status %d and unparsable body: %w", resp.StatusCode, err)
+	}
+	if resp.StatusCode != http.StatusOK || token.Error != "" {
+		return "", fmt.Errorf("token endpoint returned status %d: %s: %s", resp.StatusCode, token.Error, token.ErrorDescription)
+	}
+	if token.IDToken == "" {
+		return "", errors.New("token endpoint returned no ID token")
+	}
+	return token.IDToken, nil
+}
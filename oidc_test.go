@@ -0,0 +1,172 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func TestDiscoverOIDC(t *testing.T) {
+	tests := []struct {
+		name     string
+		status   int
+		document func(issuer string) any
+		wantErr  string
+	}{
+		{"valid", http.StatusOK, func(issuer string) any {
+			return testDiscoveryDocument(issuer)
+		}, ""},
+		{"methods not listed", http.StatusOK, func(issuer string) any {
+			d := testDiscoveryDocument(issuer)
+			delete(d, "code_challenge_methods_supported")
+			return d
+		}, ""},
+		{"issuer mismatch", http.StatusOK, func(issuer string) any {
+			d := testDiscoveryDocument(issuer)
+			d["issuer"] = "https://evil.example"
+			return d
+		}, "is for issuer"},
+		{"issuer with trailing slash", http.StatusOK, func(issuer string) any {
+			d := testDiscoveryDocument(issuer)
+			d["issuer"] = issuer + "/"
+			return d
+		}, "is for issuer"},
+		{"missing jwks_uri", http.StatusOK, func(issuer string) any {
+			d := testDiscoveryDocument(issuer)
+			delete(d, "jwks_uri")
+			return d
+		}, "lacks"},
+		{"missing token endpoint", http.StatusOK, func(issuer string) any {
+			d := testDiscoveryDocument(issuer)
+			delete(d, "token_endpoint")
+			return d
+		}, "lacks"},
+		{"plain PKCE only", http.StatusOK, func(issuer string) any {
+			d := testDiscoveryDocument(issuer)
+			d["code_challenge_methods_supported"] = []string{"plain"}
+			return d
+		}, "does not support PKCE"},
+		{"not found", http.StatusNotFound, func(issuer string) any {
+			return testDiscoveryDocument(issuer)
+		}, "returned status 404"},
+		{"not JSON", http.StatusOK, func(string) any {
+			return "<html>"
+		}, "parse"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var issuer string
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if r.URL.Path != "/.well-known/openid-configuration" {
+					http.NotFound(w, r)
+					return
+				}
+				w.WriteHeader(tt.status)
+				if s, ok := tt.document(issuer).(string); ok {
+					_, _ = w.Write([]byte(s))
+					return
+				}
+				_ = json.NewEncoder(w).Encode(tt.document(issuer))
+			}))
+			defer srv.Close()
+			issuer = srv.URL
+
+			p, err := discoverOIDC(t.Context(), issuer)
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Fatal(err)
+				}
+				if p.Issuer != issuer || p.TokenEndpoint != issuer+"/token" || p.JWKSURI != issuer+"/jwks" {
+					t.Fatalf("discovered %+v", p)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Fatalf("error = %v, want one containing %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func testDiscoveryDocument(issuer string) map[string]any {
+	return map[string]any{
+		"issuer":                           issuer,
+		"authorization_endpoint":           issuer + "/authorize",
+		"token_endpoint":                   issuer + "/token",
+		"jwks_uri":                         issuer + "/jwks",
+		"code_challenge_methods_supported": []string{"plain", "S256"},
+	}
+}
+
+func TestOIDCPreAuthFromRequest(t *testing.T) {
+	preAuth := newOIDCPreAuth()
+	if len(preAuth.Verifier) < 43 {
+		t.Errorf("verifier has %d characters, RFC 7636 requires at least 43", len(preAuth.Verifier))
+	}
+	tests := []struct {
+		name  string
+		value string
+		want  bool
+	}{
+		{"round trip", preAuth.cookie().Value, true},
+		{"two parts", preAuth.State + "." + preAuth.Nonce, false},
+		{"four parts", preAuth.cookie().Value + ".x", false},
+		{"empty nonce", preAuth.State + ".." + preAuth.Verifier, false},
+		{"empty", "", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/auth", nil)
+			r.AddCookie(&http.Cookie{Name: oidcPreAuthCookie, Value: tt.value})
+			got, err := oidcPreAuthFromRequest(r)
+			if (err == nil) != tt.want {
+				t.Fatalf("error = %v", err)
+			}
+			if tt.want && got != preAuth {
+				t.Fatalf("got %+v, want %+v", got, preAuth)
+			}
+		})
+	}
+}
+
+func TestBuildOIDCAuthorizeURL(t *testing.T) {
+	preAuth := newOIDCPreAuth()
+	target, err := buildOIDCAuthorizeURL("https://idp.example/authorize?tenant=x", "client", "https://cca.example/auth", []string{"openid", "email"}, preAuth)
+	if err != nil {
+		t.Fatal(err)
+	}
+	u, err := url.Parse(target)
+	if err != nil {
+		t.Fatal(err)
+	}
+	q := u.Query()
+	want := map[string]string{
+		"tenant":                "x",
+		"client_id":             "client",
+		"response_type":         "code",
+		"redirect_uri":          "https://cca.example/auth",
+		"scope":                 "openid email",
+		"state":                 preAuth.State,
+		"nonce":                 preAuth.Nonce,
+		"code_challenge":        oidcCodeChallenge(preAuth.Verifier),
+		"code_challenge_method": "S256",
+	}
+	for k, v := range want {
+		if q.Get(k) != v {
+			t.Errorf("%s = %q, want %q", k, q.Get(k), v)
+		}
+	}
+	if q.Has("code_verifier") {
+		t.Error("authorization request leaks the code verifier")
+	}
+}
+
+func TestOIDCCodeChallenge(t *testing.T) {
+	// The example from RFC 7636, appendix B.
+	if got := oidcCodeChallenge("dBjftJeZ4CVP-mB92K27uhbUJU1p1r_wW1gFWFOEjXk"); got != "E9Melhoa2OwvFrEMTJguCHaoeK1t8URWbuGJSstw-cM" {
+		t.Errorf("oidcCodeChallenge = %q", got)
+	}
+}
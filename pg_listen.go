@@ -0,0 +1,70 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"sync/atomic"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+const (
+	listenMinBackoff = time.Second
+	listenMaxBackoff = 30 * time.Second
+)
+
+// listenForever keeps a dedicated connection listening on channel until ctx
+// is done, reconnecting with exponential backoff, and reports in listening
+// whether it is currently connected. onListening is called after every
+// successful LISTEN; notifications sent while disconnected are lost, so
+// callers use it to catch up.
+func listenForever(ctx context.Context, pool *pgxpool.Pool, channel string, listening *atomic.Bool, onListening func(), onNotification func(payload string)) {
+	backoff := listenMinBackoff
+	for {
+		err := listen(ctx, pool, channel, func() {
+			backoff = listenMinBackoff
+			listening.Store(true)
+			slog.Info(logMsgPostgresListening, slog.String("channel", channel))
+			onListening()
+		}, onNotification)
+		listening.Store(false)
+		if ctx.Err() != nil {
+			return
+		}
+		slog.Error(logMsgPostgresListenError, slog.String("channel", channel), slog.Any("error", err), slog.Duration("retry_in", backoff))
+
+		timer := time.NewTimer(backoff)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case <-timer.C:
+		}
+		backoff = min(backoff*2, listenMaxBackoff)
+	}
+}
+
+func listen(ctx context.Context, pool *pgxpool.Pool, channel string, onListening func(), onNotification func(payload string)) error {
+	conn, err := pgx.ConnectConfig(ctx, pool.Config().ConnConfig.Copy())
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = conn.Close(context.Background())
+	}()
+
+	if _, err := conn.Exec(ctx, "LISTEN "+pgx.Identifier{channel}.Sanitize()); err != nil {
+		return err
+	}
+	onListening()
+
+	for {
+		notification, err := conn.WaitForNotification(ctx)
+		if err != nil {
+			return err
+		}
+		onNotification(notification.Payload)
+	}
+}
@@ -4,27 +4,291 @@
 SELECT version
 FROM schema_version;
 
--- name: SetAdminSession :exec
-INSERT INTO admins (username, session_token)
-VALUES ($2, $1)
-ON CONFLICT (username)
-DO UPDATE SET session_token = EXCLUDED.session_token;
+-- name: NewStudentSession :one
+INSERT INTO sessions (token_hash, student_id, expires_at, user_agent, ip)
+SELECT sqlc.arg(token_hash), id, sqlc.arg(expires_at), sqlc.arg(user_agent), sqlc.arg(ip)
+FROM students
+WHERE id = sqlc.arg(student_id)
+RETURNING id;
 
--- name: SetStudentSession :one
-UPDATE students
-SET session_token = $1
-WHERE id = $2
+-- name: NewAdminSession :one
+INSERT INTO sessions (token_hash, admin_id, expires_at, user_agent, ip)
+SELECT sqlc.arg(token_hash), id, sqlc.arg(expires_at), sqlc.arg(user_agent), sqlc.arg(ip)
+FROM admins
+WHERE username = sqlc.arg(username)
 RETURNING id;
 
--- name: GetStudentBySession :one
-SELECT id, name, grade, legal_sex
+-- name: NewTeacherSession :one
+INSERT INTO sessions (token_hash, teacher_id, expires_at, user_agent, ip)
+SELECT sqlc.arg(token_hash), id, sqlc.arg(expires_at), sqlc.arg(user_agent), sqlc.arg(ip)
+FROM teachers
+WHERE username = sqlc.arg(username)
+RETURNING id;
+
+-- name: NewImpersonationSession :one
+INSERT INTO sessions (token_hash, student_id, impersonator_id, read_only, expires_at, user_agent, ip)
+SELECT sqlc.arg(token_hash), id, sqlc.arg(impersonator_id), sqlc.arg(read_only), sqlc.arg(expires_at), sqlc.arg(user_agent), sqlc.arg(ip)
 FROM students
-WHERE session_token = $1;
+WHERE id = sqlc.arg(student_id)
+RETURNING id;
+
+-- name: GetStudentBySession :one
+SELECT sqlc.embed(students), sessions.id AS session_id, sessions.last_seen_at
+FROM sessions
+JOIN students ON students.id = sessions.student_id
+WHERE sessions.token_hash = $1
+	AND sessions.expires_at > now()
+	AND sessions.impersonator_id IS NULL;
+
+-- name: GetStudentByImpersonationSession :one
+SELECT
+	sqlc.embed(students),
+	sessions.id AS session_id,
+	sessions.read_only,
+	admins.username AS impersonator
+FROM sessions
+JOIN students ON students.id = sessions.student_id
+JOIN admins ON admins.id = sessions.impersonator_id
+WHERE sessions.token_hash = $1 AND sessions.expires_at > now();
 
 -- name: GetAdminBySession :one
-SELECT id, username
+SELECT
+	sqlc.embed(admins),
+	ARRAY(
+		SELECT grade FROM admin_grades
+		WHERE admin_id = admins.id
+		ORDER BY grade
+	)::TEXT[] AS grades,
+	sessions.id AS session_id,
+	sessions.last_seen_at
+FROM sessions
+JOIN admins ON admins.id = sessions.admin_id
+WHERE sessions.token_hash = $1 AND sessions.expires_at > now();
+
+-- name: GetTeacherBySession :one
+SELECT sqlc.embed(teachers), sessions.id AS session_id, sessions.last_seen_at
+FROM sessions
+JOIN teachers ON teachers.id = sessions.teacher_id
+WHERE sessions.token_hash = $1 AND sessions.expires_at > now();
+
+-- name: RenewSession :exec
+UPDATE sessions
+SET last_seen_at = now(), expires_at = $2
+WHERE id = $1;
+
+-- name: DeleteSessionByTokenHash :exec
+DELETE FROM sessions
+WHERE token_hash = $1;
+
+-- name: DeleteSessionAndImpersonationsByTokenHash :exec
+DELETE FROM sessions
+WHERE token_hash = $1
+	OR impersonator_id = (SELECT admin_id FROM sessions WHERE token_hash = $1);
+
+-- name: DeleteExpiredSessions :exec
+DELETE FROM sessions
+WHERE expires_at <= now();
+
+-- name: GetSessionsByStudent :many
+SELECT id, created_at, expires_at, last_seen_at, user_agent, ip
+FROM sessions
+WHERE student_id = $1 AND expires_at > now() AND impersonator_id IS NULL
+ORDER BY last_seen_at DESC;
+
+-- name: DeleteStudentSessions :execrows
+DELETE FROM sessions
+WHERE student_id = sqlc.arg(student_id)
+	AND id = ANY(sqlc.arg(session_ids)::BIGINT[])
+	AND impersonator_id IS NULL;
+
+-- name: GetSessions :many
+SELECT
+	sessions.id,
+	sessions.student_id,
+	students.name AS student_name,
+	admins.username AS admin_username,
+	teachers.username AS teacher_username,
+	impersonators.username AS impersonator_username,
+	sessions.read_only,
+	sessions.created_at,
+	sessions.expires_at,
+	sessions.last_seen_at,
+	sessions.user_agent,
+	sessions.ip
+FROM sessions
+LEFT JOIN students ON students.id = sessions.student_id
+LEFT JOIN admins ON admins.id = sessions.admin_id
+LEFT JOIN teachers ON teachers.id = sessions.teacher_id
+LEFT JOIN admins AS impersonators ON impersonators.id = sessions.impersonator_id
+WHERE sessions.expires_at > now()
+	AND (sqlc.narg(student_id)::BIGINT IS NULL OR sessions.student_id = sqlc.narg(student_id))
+ORDER BY sessions.last_seen_at DESC
+LIMIT sqlc.arg(max_sessions);
+
+-- name: DeleteSession :execrows
+DELETE FROM sessions
+WHERE id = $1;
+
+-- name: DeleteSessionsOfStudent :execrows
+DELETE FROM sessions
+WHERE student_id = $1;
+
+---- API Tokens
+
+-- name: NewAPIToken :one
+INSERT INTO api_tokens (admin_id, name, token_hash, scopes, expires_at)
+VALUES ($1, $2, $3, $4, $5)
+RETURNING id;
+
+-- name: GetAdminByAPIToken :one
+SELECT
+	sqlc.embed(admins),
+	ARRAY(
+		SELECT grade FROM admin_grades
+		WHERE admin_id = admins.id
+		ORDER BY grade
+	)::TEXT[] AS grades,
+	api_tokens.id AS token_id,
+	api_tokens.scopes,
+	api_tokens.last_used_at
+FROM api_tokens
+JOIN admins ON admins.id = api_tokens.admin_id
+WHERE api_tokens.token_hash = $1 AND api_tokens.expires_at > now();
+
+-- name: TouchAPIToken :exec
+UPDATE api_tokens
+SET last_used_at = now(), last_used_ip = $2
+WHERE id = $1;
+
+-- name: GetAPITokens :many
+SELECT
+	api_tokens.id,
+	admins.username AS admin_username,
+	api_tokens.name,
+	api_tokens.scopes,
+	api_tokens.created_at,
+	api_tokens.expires_at,
+	(api_tokens.expires_at <= now())::BOOLEAN AS expired,
+	api_tokens.last_used_at,
+	api_tokens.last_used_ip
+FROM api_tokens
+JOIN admins ON admins.id = api_tokens.admin_id
+WHERE sqlc.narg(admin_id)::BIGINT IS NULL OR api_tokens.admin_id = sqlc.narg(admin_id)
+ORDER BY api_tokens.created_at DESC;
+
+-- name: DeleteAPIToken :execrows
+DELETE FROM api_tokens
+WHERE id = sqlc.arg(id)
+	AND (sqlc.narg(admin_id)::BIGINT IS NULL OR admin_id = sqlc.narg(admin_id));
+
+---- Administrators
+
+-- name: GetAdmins :many
+SELECT
+	admins.id,
+	admins.username,
+	admins.role,
+	admins.all_grades,
+	ARRAY(
+		SELECT grade FROM admin_grades
+		WHERE admin_id = admins.id
+		ORDER BY grade
+	)::TEXT[] AS grades
 FROM admins
-WHERE session_token = $1;
+ORDER BY admins.username;
+
+-- name: NewAdmin :one
+INSERT INTO admins (username, role, all_grades)
+VALUES ($1, $2, $3)
+RETURNING id;
+
+-- name: UpdateAdmin :execrows
+UPDATE admins
+SET role = $2, all_grades = $3
+WHERE id = $1;
+
+-- name: DeleteAdmin :execrows
+DELETE FROM admins
+WHERE id = $1;
+
+-- name: DeleteAdminGrades :exec
+DELETE FROM admin_grades
+WHERE admin_id = $1;
+
+-- name: AddAdminGrades :exec
+INSERT INTO admin_grades (admin_id, grade)
+SELECT sqlc.arg(admin_id), unnest(sqlc.arg(grades)::TEXT[]);
+
+-- name: GetStudentIDsOutsideGrades :many
+SELECT id
+FROM students
+WHERE id = ANY(sqlc.arg(student_ids)::BIGINT[])
+	AND NOT (grade = ANY(sqlc.arg(grades)::TEXT[]))
+ORDER BY id;
+
+---- Teachers
+
+-- name: GetTeachers :many
+SELECT
+	teachers.id,
+	teachers.username,
+	teachers.name,
+	ARRAY(
+		SELECT course_id FROM course_teachers
+		WHERE teacher_id = teachers.id
+		ORDER BY course_id
+	)::TEXT[] AS course_ids
+FROM teachers
+ORDER BY teachers.username;
+
+-- name: NewTeacher :exec
+INSERT INTO teachers (username, name)
+VALUES ($1, $2);
+
+-- name: UpdateTeacher :execrows
+UPDATE teachers
+SET name = $2
+WHERE id = $1;
+
+-- name: DeleteTeacher :execrows
+DELETE FROM teachers
+WHERE id = $1;
+
+-- name: DeleteTeacherCourses :exec
+DELETE FROM course_teachers
+WHERE teacher_id = $1;
+
+-- name: AddTeacherCourses :exec
+INSERT INTO course_teachers (teacher_id, course_id)
+SELECT sqlc.arg(teacher_id), unnest(sqlc.arg(course_ids)::TEXT[]);
+
+-- name: GetTeacherCourses :many
+SELECT
+	courses.id,
+	courses.name,
+	courses.period,
+	courses.max_students,
+	courses.location,
+	(SELECT COUNT(*) FROM choices ch WHERE ch.course_id = courses.id) AS current_students
+FROM courses
+JOIN course_teachers ON course_teachers.course_id = courses.id
+WHERE course_teachers.teacher_id = $1
+ORDER BY courses.period, courses.id;
+
+-- name: GetTeacherRosters :many
+-- Rosters of every course of the teacher, or only of course_id if given.
+SELECT
+	v.course_id,
+	v.student_id,
+	v.student_name,
+	v.grade,
+	v.legal_sex,
+	v.selection_type
+FROM v_export_selections v
+JOIN course_teachers ct ON ct.course_id = v.course_id
+WHERE ct.teacher_id = sqlc.arg(teacher_id)
+	AND (sqlc.narg(course_id)::TEXT IS NULL OR v.course_id = sqlc.narg(course_id))
+ORDER BY v.course_id, v.grade, v.student_name, v.student_id;
 
 ---- Categories
 
@@ -195,7 +459,7 @@ WHERE id = $1;
 ---- Students
 
 -- name: GetStudents :many
-SELECT id, name, grade, legal_sex, session_token
+SELECT id, name, grade, legal_sex
 FROM students
 ORDER BY id;
 
@@ -217,6 +481,46 @@ WHERE id = $1;
 DELETE FROM students
 WHERE id = $1;
 
+-- name: CountStudents :one
+SELECT COUNT(*)
+FROM students;
+
+-- name: GetStudentIDsByGrades :many
+SELECT id
+FROM students
+WHERE grade = ANY($1::text[])
+ORDER BY id;
+
+-- name: GetStudentIDsByCourses :many
+SELECT DISTINCT student_id
+FROM choices
+WHERE course_id = ANY($1::text[])
+ORDER BY student_id;
+
+-- Students in enabled grades who have no selection in at least one of the
+-- given periods.
+-- name: GetStudentIDsMissingPeriods :many
+SELECT s.id
+FROM students s
+JOIN grades g ON g.grade = s.grade
+WHERE g.enabled
+	AND EXISTS (
+		SELECT 1
+		FROM unnest($1::text[]) AS p(period)
+		WHERE NOT EXISTS (
+			SELECT 1
+			FROM choices ch
+			WHERE ch.student_id = s.id AND ch.period = p.period
+		)
+	)
+ORDER BY s.id;
+
+-- name: GetExistingStudentIDs :many
+SELECT id
+FROM students
+WHERE id = ANY($1::bigint[])
+ORDER BY id;
+
 ---- Selections
 
 -- name: GetSelections :many
@@ -272,11 +576,158 @@ FROM choices
 WHERE student_id = $1;
 
 
--- name: GetSelectionCourseByStudentAndPeriod :one
-SELECT course_id
+-- name: GetSelectionsByStudents :many
+SELECT student_id, course_id, period, selection_type
 FROM choices
-WHERE student_id = $1 AND period = $2;
+WHERE student_id = ANY($1::bigint[])
+ORDER BY student_id, period;
 
 
 -- name: DeleteChoiceByStudentAndCourse :exec
 SELECT delete_choice($1, $2);
+
+---- Notifications
+
+-- name: NewNotification :one
+INSERT INTO notifications (text, sender, target, student_ids, recipients)
+VALUES ($1, $2, $3, $4, $5)
+RETURNING id, created_at;
+
+-- Receipts are created for every notification addressed to the student that
+-- has not reached their inbox yet.
+-- name: RecordNotificationsDelivered :exec
+INSERT INTO notification_receipts (notification_id, student_id)
+SELECT n.id, sqlc.arg(student_id)::bigint
+FROM notifications n
+WHERE n.student_ids IS NULL OR sqlc.arg(student_id)::bigint = ANY(n.student_ids)
+ON CONFLICT DO NOTHING;
+
+-- name: GetNotificationsByStudent :many
+SELECT n.id, n.text, n.created_at, r.read_at
+FROM notifications n
+JOIN notification_receipts r ON r.notification_id = n.id
+WHERE r.student_id = $1
+ORDER BY n.id DESC;
+
+-- name: MarkNotificationsRead :exec
+INSERT INTO notification_receipts (notification_id, student_id, read_at)
+SELECT n.id, sqlc.arg(student_id)::bigint, now()
+FROM notifications n
+WHERE n.id = ANY(sqlc.arg(notification_ids)::bigint[])
+	AND (n.student_ids IS NULL OR sqlc.arg(student_id)::bigint = ANY(n.student_ids))
+ON CONFLICT (notification_id, student_id) DO UPDATE
+SET read_at = COALESCE(notification_receipts.read_at, EXCLUDED.read_at);
+
+-- name: GetNotificationHistory :many
+SELECT
+	n.id,
+	n.text,
+	n.sender,
+	n.target,
+	n.recipients,
+	n.created_at,
+	COUNT(r.student_id) AS delivered,
+	COUNT(r.read_at) AS read
+FROM notifications n
+LEFT JOIN notification_receipts r ON r.notification_id = n.id
+GROUP BY n.id
+ORDER BY n.id DESC
+LIMIT $1;
+
+-- name: NewScheduledNotification :one
+INSERT INTO scheduled_notifications (
+	text, sender, target, grades, course_ids, periods, ids,
+	send_at, repeat_seconds, repeat_until
+)
+VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10)
+RETURNING id;
+
+-- name: GetPendingScheduledNotifications :many
+SELECT *
+FROM scheduled_notifications
+WHERE status = 'pending'
+ORDER BY send_at, id;
+
+-- name: ClaimDueScheduledNotifications :many
+SELECT *
+FROM scheduled_notifications
+WHERE status = 'pending' AND send_at <= now()
+ORDER BY send_at, id
+LIMIT $1
+FOR UPDATE SKIP LOCKED;
+
+-- name: FinishScheduledNotificationRun :exec
+UPDATE scheduled_notifications
+SET
+	send_at = $2,
+	status = $3,
+	runs = runs + CASE WHEN sqlc.arg(sent)::boolean THEN 1 ELSE 0 END
+WHERE id = $1;
+
+-- name: UpdateScheduledNotification :execrows
+-- A sender limits the update to notifications scheduled by that sender.
+UPDATE scheduled_notifications
+SET
+	text = sqlc.arg(text),
+	send_at = sqlc.arg(send_at),
+	repeat_seconds = sqlc.narg(repeat_seconds),
+	repeat_until = sqlc.narg(repeat_until)
+WHERE id = sqlc.arg(id)
+	AND status = 'pending'
+	AND (sqlc.narg(sender)::TEXT IS NULL OR sender = sqlc.narg(sender));
+
+-- name: CancelScheduledNotification :execrows
+UPDATE scheduled_notifications
+SET status = 'cancelled'
+WHERE id = sqlc.arg(id)
+	AND status = 'pending'
+	AND (sqlc.narg(sender)::TEXT IS NULL OR sender = sqlc.narg(sender));
+
+---- Change capture
+
+-- name: ClaimChanges :many
+DELETE FROM change_outbox
+WHERE id IN (
+	SELECT id
+	FROM change_outbox
+	ORDER BY id
+	LIMIT $1
+	FOR UPDATE SKIP LOCKED
+)
+RETURNING id, resource, key;
+
+-- name: AppendEvent :one
+INSERT INTO ws_events (type, payload, student_ids)
+VALUES ($1, $2, $3)
+RETURNING id, created_at;
+
+-- name: PruneEvents :exec
+DELETE FROM ws_events
+WHERE id <= (
+	SELECT id
+	FROM ws_events
+	ORDER BY id DESC
+	OFFSET $1
+	LIMIT 1
+);
+
+-- name: GetEventLogBounds :one
+SELECT
+	COALESCE(MIN(id), 0)::bigint AS oldest,
+	COALESCE(MAX(id), 0)::bigint AS newest
+FROM ws_events;
+
+-- name: GetEventByID :one
+SELECT type, payload, student_ids
+FROM ws_events
+WHERE id = $1;
+
+-- name: GetEventsSince :many
+SELECT id, type, payload, created_at
+FROM ws_events
+WHERE id > $1
+	AND (student_ids IS NULL OR sqlc.arg(student_id)::bigint = ANY(student_ids))
+ORDER BY id;
+
+-- name: PublishWSEvent :exec
+SELECT pg_notify('cca_ws', $1::text);
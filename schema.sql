@@ -15,7 +15,7 @@ CREATE TABLE schema_version (
 	singleton BOOLEAN PRIMARY KEY DEFAULT TRUE CHECK (singleton),
 	version BIGINT NOT NULL CHECK (version > 0)
 );
-INSERT INTO schema_version (version) VALUES (1);
+INSERT INTO schema_version (version) VALUES (11);
 
 -- This is the 'gender' field from PowerSchool, but it is more accurately
 -- described as legal sex.
@@ -79,17 +79,37 @@ CREATE TABLE periods (
 	id TEXT PRIMARY KEY CHECK (btrim(id) <> '')
 );
 
--- Administrators are managed separately from students.
+-- Superusers may do everything, including managing administrators.
+-- Coordinators may manage selections and send notifications, and auditors
+-- may only look. Everyone may view every admin page.
+CREATE TYPE admin_role AS ENUM ('superuser', 'coordinator', 'auditor');
+
+-- Administrators are managed separately from students, by superusers from
+-- the admins page. Unless all_grades is set, an administrator may only
+-- change selections of, and notify, students in their admin_grades.
 CREATE TABLE admins (
 	id BIGSERIAL PRIMARY KEY,
-	-- Note: admin usernames are case-sensitive!
+	-- Note: admin usernames are case-sensitive! They are matched against
+	-- the lowercased local-part of the email address users log in with.
+	username TEXT NOT NULL UNIQUE CHECK (btrim(username) <> ''),
+	role admin_role NOT NULL,
+	all_grades BOOLEAN NOT NULL DEFAULT TRUE
+);
+
+CREATE TABLE admin_grades (
+	admin_id BIGINT NOT NULL REFERENCES admins(id) ON DELETE CASCADE,
+	grade TEXT NOT NULL REFERENCES grades(grade) ON UPDATE CASCADE ON DELETE CASCADE,
+	PRIMARY KEY (admin_id, grade)
+);
+
+-- Teachers log in like administrators do, by username, and may only view
+-- the courses they are linked to in course_teachers.
+CREATE TABLE teachers (
+	id BIGSERIAL PRIMARY KEY,
 	username TEXT NOT NULL UNIQUE CHECK (btrim(username) <> ''),
-	-- Each administrator may have a maximum of one session at a time.
-	session_token TEXT UNIQUE
+	name TEXT NOT NULL
 );
 
--- Although students use OpenID Connect for authentication, we still use our
--- own session tokens.
 CREATE TABLE students (
 	id BIGINT PRIMARY KEY,
 	-- If there's a blank student name, let's just let it be.
@@ -97,11 +117,50 @@ CREATE TABLE students (
 	-- it in a way that requires it to be unique or usable or anything.
 	name TEXT NOT NULL,
 	grade TEXT NOT NULL REFERENCES grades(grade) ON UPDATE RESTRICT ON DELETE RESTRICT,
-	legal_sex legal_sex NOT NULL,
-	session_token TEXT UNIQUE
+	legal_sex legal_sex NOT NULL
 );
 
--- TODO: Expiry!
+-- Although users log in with OpenID Connect, we still use our own session
+-- tokens. Each user may have several sessions, one for each browser they
+-- logged in with. Sessions are renewed while they are used, so expires_at
+-- moves forward; expired sessions are deleted when someone next logs in.
+-- Only an HMAC of each token, keyed with session.key_file from the
+-- configuration, is stored, so the table alone is not enough to log in as
+-- anyone.
+CREATE TABLE sessions (
+	id BIGSERIAL PRIMARY KEY,
+	token_hash BYTEA NOT NULL UNIQUE,
+	student_id BIGINT REFERENCES students(id) ON DELETE CASCADE,
+	admin_id BIGINT REFERENCES admins(id) ON DELETE CASCADE,
+	teacher_id BIGINT REFERENCES teachers(id) ON DELETE CASCADE,
+	-- Set for sessions in which an administrator views the student SPA as
+	-- student_id. They use their own cookie and are never renewed.
+	impersonator_id BIGINT REFERENCES admins(id) ON DELETE CASCADE,
+	read_only BOOLEAN NOT NULL DEFAULT FALSE,
+	created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+	expires_at TIMESTAMPTZ NOT NULL,
+	last_seen_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+	user_agent TEXT NOT NULL,
+	ip TEXT NOT NULL,
+	CHECK (num_nonnulls(student_id, admin_id, teacher_id) = 1),
+	CHECK (impersonator_id IS NULL OR student_id IS NOT NULL)
+);
+
+-- API tokens let administrators use the admin endpoints from scripts, with
+-- an Authorization: Bearer header instead of a session cookie. Like session
+-- tokens, only their HMAC is stored. Scopes are names of the permissions the
+-- token allows, and are further limited by the role of its administrator.
+CREATE TABLE api_tokens (
+	id BIGSERIAL PRIMARY KEY,
+	admin_id BIGINT NOT NULL REFERENCES admins(id) ON DELETE CASCADE,
+	name TEXT NOT NULL CHECK (btrim(name) <> ''),
+	token_hash BYTEA NOT NULL UNIQUE,
+	scopes TEXT[] NOT NULL CHECK (scopes <@ ARRAY['view', 'selections', 'notify', 'setup']::TEXT[]),
+	created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+	expires_at TIMESTAMPTZ NOT NULL,
+	last_used_at TIMESTAMPTZ,
+	last_used_ip TEXT
+);
 
 -- Courses
 CREATE TABLE courses (
@@ -128,6 +187,14 @@ CREATE TABLE course_allowed_legal_sexes (
 	PRIMARY KEY (course_id, legal_sex)
 );
 
+-- courses.teacher is only a name to display; this is what gives teachers
+-- access to a course's roster. A course may have several teachers.
+CREATE TABLE course_teachers (
+	course_id TEXT NOT NULL REFERENCES courses(id) ON UPDATE CASCADE ON DELETE CASCADE,
+	teacher_id BIGINT NOT NULL REFERENCES teachers(id) ON DELETE CASCADE,
+	PRIMARY KEY (course_id, teacher_id)
+);
+
 -- Allowed grades. If none are present then we assume that all grades are
 -- allowed for this course.
 CREATE TABLE course_allowed_grades (
@@ -391,6 +458,158 @@ $$;
 
 -- TODO: trigger for deletion of choices when forced?
 
+-- Change capture. Triggers record what changed in change_outbox and wake the
+-- application's dispatcher, which sends the new state to the affected
+-- students. Doing this in the database means that every way of changing the
+-- data, including manual SQL, reaches connected clients.
+--
+-- resource is one of 'selections' (key is the student ID), 'course_counts'
+-- (key is the course ID), 'student' (key is the student ID), or 'courses',
+-- 'grades', 'categories', or 'periods' (key is empty).
+CREATE TABLE change_outbox (
+	id BIGSERIAL PRIMARY KEY,
+	resource TEXT NOT NULL,
+	key TEXT NOT NULL DEFAULT '',
+	created_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+CREATE FUNCTION record_choice_change()
+RETURNS trigger
+LANGUAGE plpgsql
+AS $$
+BEGIN
+	IF TG_OP IN ('UPDATE', 'DELETE') THEN
+		INSERT INTO change_outbox (resource, key)
+		VALUES ('selections', OLD.student_id::text), ('course_counts', OLD.course_id);
+	END IF;
+	IF TG_OP IN ('INSERT', 'UPDATE') THEN
+		INSERT INTO change_outbox (resource, key)
+		VALUES ('selections', NEW.student_id::text), ('course_counts', NEW.course_id);
+	END IF;
+	-- Identical notifications within a transaction are delivered once.
+	PERFORM pg_notify('cca_outbox', '');
+	RETURN NULL;
+END
+$$;
+CREATE TRIGGER trg_choices_outbox
+AFTER INSERT OR UPDATE OR DELETE ON choices
+FOR EACH ROW
+EXECUTE FUNCTION record_choice_change();
+
+CREATE FUNCTION record_student_change()
+RETURNS trigger
+LANGUAGE plpgsql
+AS $$
+BEGIN
+	INSERT INTO change_outbox (resource, key)
+	VALUES ('student', OLD.id::text);
+	PERFORM pg_notify('cca_outbox', '');
+	RETURN NULL;
+END
+$$;
+CREATE TRIGGER trg_students_outbox
+AFTER UPDATE OF name, grade, legal_sex OR DELETE ON students
+FOR EACH ROW
+EXECUTE FUNCTION record_student_change();
+
+-- Events that clients must not miss, such as notifications, are kept here so
+-- that a client reconnecting after a network interruption can receive the
+-- events it missed. The server only keeps the most recent events; a client
+-- that has been away for longer is told to resynchronize instead.
+-- student_ids is NULL for events sent to every student.
+CREATE TABLE ws_events (
+	id BIGSERIAL PRIMARY KEY,
+	type TEXT NOT NULL,
+	payload JSONB NOT NULL,
+	student_ids BIGINT[],
+	created_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+-- Notifications sent by administrators, kept so that students who were
+-- offline can read them later in their inbox. student_ids is NULL for
+-- notifications to every student; target describes how the recipients were
+-- chosen and recipients is how many there were when it was sent.
+CREATE TABLE notifications (
+	id BIGSERIAL PRIMARY KEY,
+	text TEXT NOT NULL,
+	sender TEXT NOT NULL,
+	target TEXT NOT NULL,
+	student_ids BIGINT[],
+	recipients BIGINT NOT NULL,
+	created_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+-- A receipt is created when a notification reaches a student's inbox, and
+-- read_at is set when the student marks it as read.
+CREATE TABLE notification_receipts (
+	notification_id BIGINT NOT NULL REFERENCES notifications(id) ON UPDATE CASCADE ON DELETE CASCADE,
+	student_id BIGINT NOT NULL REFERENCES students(id) ON UPDATE CASCADE ON DELETE CASCADE,
+	delivered_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+	read_at TIMESTAMPTZ,
+	PRIMARY KEY (notification_id, student_id)
+);
+
+-- Notifications to be sent later, kept with the recipient selection from the
+-- notify page so that recipients are chosen when each one is sent. Recurring
+-- notifications (repeat_seconds set) are sent again every repeat_seconds
+-- until repeat_until, or until no student matches their recipients any more,
+-- which for students missing a selection means once they have all chosen.
+CREATE TABLE scheduled_notifications (
+	id BIGSERIAL PRIMARY KEY,
+	text TEXT NOT NULL,
+	sender TEXT NOT NULL,
+	target TEXT NOT NULL,
+	grades TEXT[] NOT NULL DEFAULT '{}',
+	course_ids TEXT[] NOT NULL DEFAULT '{}',
+	periods TEXT[] NOT NULL DEFAULT '{}',
+	ids TEXT NOT NULL DEFAULT '',
+	send_at TIMESTAMPTZ NOT NULL,
+	repeat_seconds BIGINT CHECK (repeat_seconds > 0),
+	repeat_until TIMESTAMPTZ,
+	status TEXT NOT NULL DEFAULT 'pending' CHECK (status IN ('pending', 'done', 'cancelled')),
+	runs BIGINT NOT NULL DEFAULT 0,
+	created_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+CREATE INDEX idx_scheduled_notifications_pending
+	ON scheduled_notifications (send_at) WHERE status = 'pending';
+
+-- The resource is passed as the trigger argument.
+CREATE FUNCTION record_table_change()
+RETURNS trigger
+LANGUAGE plpgsql
+AS $$
+BEGIN
+	INSERT INTO change_outbox (resource)
+	VALUES (TG_ARGV[0]);
+	PERFORM pg_notify('cca_outbox', '');
+	RETURN NULL;
+END
+$$;
+CREATE TRIGGER trg_courses_outbox
+AFTER INSERT OR UPDATE OR DELETE OR TRUNCATE ON courses
+FOR EACH STATEMENT
+EXECUTE FUNCTION record_table_change('courses');
+CREATE TRIGGER trg_grades_outbox
+AFTER INSERT OR UPDATE OR DELETE OR TRUNCATE ON grades
+FOR EACH STATEMENT
+EXECUTE FUNCTION record_table_change('grades');
+CREATE TRIGGER trg_grade_requirement_groups_outbox
+AFTER INSERT OR UPDATE OR DELETE OR TRUNCATE ON grade_requirement_groups
+FOR EACH STATEMENT
+EXECUTE FUNCTION record_table_change('grades');
+CREATE TRIGGER trg_grade_requirement_group_categories_outbox
+AFTER INSERT OR UPDATE OR DELETE OR TRUNCATE ON grade_requirement_group_categories
+FOR EACH STATEMENT
+EXECUTE FUNCTION record_table_change('grades');
+CREATE TRIGGER trg_categories_outbox
+AFTER INSERT OR UPDATE OR DELETE OR TRUNCATE ON categories
+FOR EACH STATEMENT
+EXECUTE FUNCTION record_table_change('categories');
+CREATE TRIGGER trg_periods_outbox
+AFTER INSERT OR UPDATE OR DELETE OR TRUNCATE ON periods
+FOR EACH STATEMENT
+EXECUTE FUNCTION record_table_change('periods');
+
 
 -- Views
 
@@ -428,7 +647,15 @@ CREATE INDEX IF NOT EXISTS idx_grade_requirement_groups_grade
 	ON grade_requirement_groups (grade);
 CREATE INDEX IF NOT EXISTS idx_gr_req_group_categories_category
 	ON grade_requirement_group_categories (category_id);
-CREATE INDEX IF NOT EXISTS idx_students_session_token 
-	ON students (session_token);
-CREATE INDEX IF NOT EXISTS idx_admins_session_token 
-	ON admins (session_token);
+CREATE INDEX IF NOT EXISTS idx_sessions_student_id
+	ON sessions (student_id);
+CREATE INDEX IF NOT EXISTS idx_sessions_admin_id
+	ON sessions (admin_id);
+CREATE INDEX IF NOT EXISTS idx_sessions_teacher_id
+	ON sessions (teacher_id);
+CREATE INDEX IF NOT EXISTS idx_api_tokens_admin_id
+	ON api_tokens (admin_id);
+CREATE INDEX IF NOT EXISTS idx_course_teachers_teacher_id
+	ON course_teachers (teacher_id);
+CREATE INDEX IF NOT EXISTS idx_sessions_expires_at
+	ON sessions (expires_at);
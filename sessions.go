@@ -0,0 +1,158 @@
+package main
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgtype"
+
+	"git.sr.ht/~runxiyu/cca/db"
+)
+
+const (
+	sessionCookie = "session"
+	// sessionRenewInterval is how long a session is used before it is
+	// renewed, so that not every request writes to the database.
+	sessionRenewInterval = time.Minute
+	sessionKeyMinLength  = 32
+)
+
+func loadSessionKey(path string) ([]byte, error) {
+	key, err := os.ReadFile(path) //#nosec G304
+	if err != nil {
+		return nil, fmt.Errorf("read session key: %w", err)
+	}
+	key = bytes.TrimSpace(key)
+	if len(key) < sessionKeyMinLength {
+		return nil, fmt.Errorf("session key in %s must be at least %d bytes", path, sessionKeyMinLength)
+	}
+	return key, nil
+}
+
+// hashSessionToken returns what is stored in place of a session token. It
+// is keyed so that the database alone cannot be used to forge sessions.
+func (app *App) hashSessionToken(token string) []byte {
+	mac := hmac.New(sha256.New, app.sessionKey)
+	mac.Write([]byte(token))
+	return mac.Sum(nil)
+}
+
+func setSessionCookie(w http.ResponseWriter, value string, expires time.Time) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     sessionCookie,
+		Value:    value,
+		Path:     "/",
+		SameSite: http.SameSiteLaxMode,
+		HttpOnly: true,
+		Secure:   true,
+		Expires:  expires,
+	})
+}
+
+func clearSessionCookie(w http.ResponseWriter) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     sessionCookie,
+		Path:     "/",
+		SameSite: http.SameSiteLaxMode,
+		HttpOnly: true,
+		Secure:   true,
+		MaxAge:   -1,
+	})
+}
+
+func (app *App) sessionExpiry() time.Time {
+	return time.Now().Add(app.config.Session.Lifetime)
+}
+
+// startStudentSession logs the student in on this browser. It returns
+// pgx.ErrNoRows if there is no such student.
+func (app *App) startStudentSession(w http.ResponseWriter, r *http.Request, studentID int64) error {
+	app.deleteExpiredSessions(r)
+
+	token := rand.Text()
+	expires := app.sessionExpiry()
+	if _, err := app.queries.NewStudentSession(r.Context(), db.NewStudentSessionParams{
+		TokenHash: app.hashSessionToken(token),
+		ExpiresAt: pgtype.Timestamptz{Time: expires, Valid: true},
+		UserAgent: r.UserAgent(),
+		Ip:        remoteHost(r),
+		StudentID: studentID,
+	}); err != nil {
+		return err
+	}
+	setSessionCookie(w, "student:"+token, expires)
+	return nil
+}
+
+// startAdminSession logs the administrator in on this browser. It returns
+// pgx.ErrNoRows if there is no such administrator.
+func (app *App) startAdminSession(w http.ResponseWriter, r *http.Request, username string) error {
+	app.deleteExpiredSessions(r)
+
+	token := rand.Text()
+	expires := app.sessionExpiry()
+	if _, err := app.queries.NewAdminSession(r.Context(), db.NewAdminSessionParams{
+		Username:  username,
+		TokenHash: app.hashSessionToken(token),
+		ExpiresAt: pgtype.Timestamptz{Time: expires, Valid: true},
+		UserAgent: r.UserAgent(),
+		Ip:        remoteHost(r),
+	}); err != nil {
+		return err
+	}
+	setSessionCookie(w, "admin:"+token, expires)
+	return nil
+}
+
+// startTeacherSession logs the teacher in on this browser. It returns
+// pgx.ErrNoRows if there is no such teacher.
+func (app *App) startTeacherSession(w http.ResponseWriter, r *http.Request, username string) error {
+	app.deleteExpiredSessions(r)
+
+	token := rand.Text()
+	expires := app.sessionExpiry()
+	if _, err := app.queries.NewTeacherSession(r.Context(), db.NewTeacherSessionParams{
+		Username:  username,
+		TokenHash: app.hashSessionToken(token),
+		ExpiresAt: pgtype.Timestamptz{Time: expires, Valid: true},
+		UserAgent: r.UserAgent(),
+		Ip:        remoteHost(r),
+	}); err != nil {
+		return err
+	}
+	setSessionCookie(w, "teacher:"+token, expires)
+	return nil
+}
+
+// deleteExpiredSessions runs on every login, which is often enough to keep
+// the table small. Failing to clean up does not stop the login.
+func (app *App) deleteExpiredSessions(r *http.Request) {
+	if err := app.queries.DeleteExpiredSessions(r.Context()); err != nil {
+		app.logWarn(r, logMsgAuthSessionCleanupError, slog.Any("error", err))
+	}
+}
+
+// renewSession moves the expiry of a session that is in use forward, at
+// most once every sessionRenewInterval. A failed renewal is only logged, as
+// the session is still valid until it expires.
+func (app *App) renewSession(w http.ResponseWriter, r *http.Request, cookie *http.Cookie, sessionID int64, lastSeen time.Time) {
+	if time.Since(lastSeen) < sessionRenewInterval {
+		return
+	}
+	expires := app.sessionExpiry()
+	if err := app.queries.RenewSession(r.Context(), db.RenewSessionParams{
+		ID:        sessionID,
+		ExpiresAt: pgtype.Timestamptz{Time: expires, Valid: true},
+	}); err != nil {
+		app.logWarn(r, logMsgAuthSessionRenewError, slog.Int64("session_id", sessionID), slog.Any("error", err))
+		return
+	}
+	setSessionCookie(w, cookie.Value, expires)
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestHashSessionToken(t *testing.T) {
+	key := []byte(strings.Repeat("k", sessionKeyMinLength))
+	app := &App{sessionKey: key}
+	otherApp := &App{sessionKey: []byte(strings.Repeat("o", sessionKeyMinLength))}
+
+	// HMAC-SHA256 of "token" under key, so that changing how tokens are
+	// hashed, which logs everyone out, does not go unnoticed.
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte("token"))
+	want := mac.Sum(nil)
+
+	tests := []struct {
+		name string
+		a, b []byte
+		same bool
+	}{
+		{"HMAC-SHA256", app.hashSessionToken("token"), want, true},
+		{"deterministic", app.hashSessionToken("token"), app.hashSessionToken("token"), true},
+		{"per token", app.hashSessionToken("token"), app.hashSessionToken("token2"), false},
+		{"per key", app.hashSessionToken("token"), otherApp.hashSessionToken("token"), false},
+		{"not the bare hash", app.hashSessionToken("token"), func() []byte { s := sha256.Sum256([]byte("token")); return s[:] }(), false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if bytes.Equal(tt.a, tt.b) != tt.same {
+				t.Errorf("%s and %s: equal = %v, want %v", hex.EncodeToString(tt.a), hex.EncodeToString(tt.b), !tt.same, tt.same)
+			}
+			if len(tt.a) != sha256.Size {
+				t.Errorf("hash has %d bytes, want %d", len(tt.a), sha256.Size)
+			}
+		})
+	}
+}
+
+func TestLoadSessionKey(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		want    string
+		wantErr bool
+	}{
+		{"trailing newline", strings.Repeat("k", sessionKeyMinLength) + "\n", strings.Repeat("k", sessionKeyMinLength), false},
+		{"too short", strings.Repeat("k", sessionKeyMinLength-1) + "\n", "", true},
+		{"short after trimming", "  " + strings.Repeat("k", sessionKeyMinLength-2) + "  ", "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "session.key")
+			if err := os.WriteFile(path, []byte(tt.content), 0o600); err != nil {
+				t.Fatal(err)
+			}
+			key, err := loadSessionKey(path)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("error = %v, want error %v", err, tt.wantErr)
+			}
+			if string(key) != tt.want {
+				t.Errorf("key = %q, want %q", key, tt.want)
+			}
+		})
+	}
+	if _, err := loadSessionKey(filepath.Join(t.TempDir(), "missing")); err == nil {
+		t.Error("missing key file accepted")
+	}
+}
@@ -4,6 +4,7 @@ package main
 import (
 	"bufio"
 	"context"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
@@ -28,6 +29,10 @@ var (
 		"/student/api/grades",
 		"/student/api/periods",
 	}
+	// studentDomain is appended to student IDs to form the identities
+	// that the development login maps back to them. The students must be
+	// allowed in dev_login.
+	studentDomain  = "stu.ykpaoschool.cn"
 	authTimeout    = 8 * time.Second
 	requestTimeout = 10 * time.Second
 )
@@ -120,6 +125,28 @@ func readStudentIDs(path string) ([]string, error) {
 	return ids, nil
 }
 
+func devLoginToken(ctx context.Context, client *http.Client, authURL string) (string, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, authURL, nil)
+	if err != nil {
+		return "", err
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	_, _ = io.Copy(io.Discard, resp.Body)
+	_ = resp.Body.Close()
+	if resp.StatusCode >= 400 {
+		return "", fmt.Errorf("status %d", resp.StatusCode)
+	}
+	for _, cookie := range resp.Cookies() {
+		if cookie.Name == "dev_login" {
+			return cookie.Value, nil
+		}
+	}
+	return "", errors.New("no dev_login cookie")
+}
+
 func handleStudent(studentID string, summary *resultSummary) error {
 	jar, _ := cookiejar.New(nil)
 	client := &http.Client{
@@ -127,13 +154,23 @@ func handleStudent(studentID string, summary *resultSummary) error {
 		Timeout: requestTimeout,
 	}
 
-	authURL := strings.TrimRight(baseURL, "/") + "/auth"
-	form := url.Values{}
-	form.Set("bypass", studentID)
+	authURL := strings.TrimRight(baseURL, "/") + "/auth/dev"
 
 	ctx, cancel := context.WithTimeout(context.Background(), authTimeout)
 	defer cancel()
 
+	// The login form sets a cookie whose value must be submitted with it.
+	token, err := devLoginToken(ctx, client, authURL)
+	atomic.AddInt64(&summary.totalRequests, 1)
+	if err != nil {
+		atomic.AddInt64(&summary.failures, 1)
+		return fmt.Errorf("login form: %w", err)
+	}
+
+	form := url.Values{}
+	form.Set("identity", studentID+"@"+studentDomain)
+	form.Set("csrf_token", token)
+
 	req, err := http.NewRequestWithContext(ctx, http.MethodPost, authURL, strings.NewReader(form.Encode()))
 	if err != nil {
 		return fmt.Errorf("creating auth request: %w", err)
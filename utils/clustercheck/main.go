@@ -0,0 +1,218 @@
+// Check that events reach students connected to another instance
+//
+// Logs in as one student on two instances that share a database, listens for
+// events on the second, selects a course through the first, and waits for the
+// student's selections to arrive. The selection is removed again afterwards.
+// Both instances need websocket.cluster enabled and the development login
+// enabled for the student.
+//
+// TestWSClusterTwoInstances checks the relay without running instances; this
+// checks that a deployment is configured for it.
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/cookiejar"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/coder/websocket"
+)
+
+type envelope struct {
+	Type    string          `json:"type"`
+	Payload json.RawMessage `json:"payload"`
+	Seq     uint64          `json:"seq"`
+}
+
+type selections struct {
+	Selections []struct {
+		CourseID string `json:"course_id"`
+	} `json:"selections"`
+}
+
+func main() {
+	urlA := flag.String("a", "http://localhost:8080", "instance to make the selection on")
+	urlB := flag.String("b", "http://localhost:8081", "instance to listen for events on")
+	student := flag.String("student", "", "identity to log in as, e.g. s12345@stu.ykpaoschool.cn")
+	course := flag.String("course", "", "course ID to select and then remove")
+	timeout := flag.Duration("timeout", 10*time.Second, "how long to wait for the event")
+	flag.Parse()
+
+	if *student == "" || *course == "" {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	if err := run(*urlA, *urlB, *student, *course, *timeout); err != nil {
+		fmt.Println("FAIL:", err)
+		os.Exit(1)
+	}
+	fmt.Println("OK")
+}
+
+func run(urlA, urlB, student, course string, timeout time.Duration) error {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	clientA, err := login(ctx, urlA, student)
+	if err != nil {
+		return fmt.Errorf("log in on %s: %w", urlA, err)
+	}
+	clientB, err := login(ctx, urlB, student)
+	if err != nil {
+		return fmt.Errorf("log in on %s: %w", urlB, err)
+	}
+
+	wsURL := "ws" + strings.TrimPrefix(strings.TrimRight(urlB, "/"), "http") + "/student/api/events"
+	conn, _, err := websocket.Dial(ctx, wsURL, &websocket.DialOptions{
+		HTTPClient:   clientB,
+		Subprotocols: []string{"cca.v1"},
+	})
+	if err != nil {
+		return fmt.Errorf("connect to %s: %w", wsURL, err)
+	}
+	defer func() {
+		_ = conn.Close(websocket.StatusNormalClosure, "")
+	}()
+
+	if _, err := waitFor(ctx, conn, "hello"); err != nil {
+		return err
+	}
+
+	if err := mutate(ctx, clientA, urlA, http.MethodPut, course); err != nil {
+		return fmt.Errorf("select %s on %s: %w", course, urlA, err)
+	}
+	defer func() {
+		ctx, cancel := context.WithTimeout(context.Background(), timeout)
+		defer cancel()
+		if err := mutate(ctx, clientA, urlA, http.MethodDelete, course); err != nil {
+			fmt.Printf("warning: could not remove %s again: %v\n", course, err)
+		}
+	}()
+
+	start := time.Now()
+	for {
+		payload, err := waitFor(ctx, conn, "selections")
+		if err != nil {
+			return err
+		}
+		var s selections
+		if err := json.Unmarshal(payload, &s); err != nil {
+			return fmt.Errorf("decode selections: %w", err)
+		}
+		for _, sel := range s.Selections {
+			if sel.CourseID == course {
+				fmt.Printf("selection arrived on %s after %s\n", urlB, time.Since(start))
+				return nil
+			}
+		}
+	}
+}
+
+func login(ctx context.Context, baseURL, student string) (*http.Client, error) {
+	jar, _ := cookiejar.New(nil)
+	client := &http.Client{Jar: jar}
+	authURL := strings.TrimRight(baseURL, "/") + "/auth/dev"
+
+	// The login form sets a cookie whose value must be submitted with it.
+	token, err := devLoginToken(ctx, client, authURL)
+	if err != nil {
+		return nil, err
+	}
+
+	form := url.Values{}
+	form.Set("identity", student)
+	form.Set("csrf_token", token)
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, authURL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	_, _ = io.Copy(io.Discard, resp.Body)
+	_ = resp.Body.Close()
+	if resp.StatusCode >= 400 {
+		return nil, fmt.Errorf("status %d", resp.StatusCode)
+	}
+	return client, nil
+}
+
+func devLoginToken(ctx context.Context, client *http.Client, authURL string) (string, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, authURL, nil)
+	if err != nil {
+		return "", err
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	_, _ = io.Copy(io.Discard, resp.Body)
+	_ = resp.Body.Close()
+	if resp.StatusCode >= 400 {
+		return "", fmt.Errorf("login form: status %d", resp.StatusCode)
+	}
+	for _, cookie := range resp.Cookies() {
+		if cookie.Name == "dev_login" {
+			return cookie.Value, nil
+		}
+	}
+	return "", errors.New("login form did not set the dev_login cookie")
+}
+
+func mutate(ctx context.Context, client *http.Client, baseURL, method, course string) error {
+	body, err := json.Marshal(course)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, method, strings.TrimRight(baseURL, "/")+"/student/api/my_selections", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	msg, _ := io.ReadAll(resp.Body)
+	_ = resp.Body.Close()
+	if resp.StatusCode >= 400 {
+		return fmt.Errorf("status %d: %s", resp.StatusCode, bytes.TrimSpace(msg))
+	}
+	return nil
+}
+
+var errClosed = errors.New("connection closed before the event arrived")
+
+func waitFor(ctx context.Context, conn *websocket.Conn, eventType string) (json.RawMessage, error) {
+	for {
+		_, data, err := conn.Read(ctx)
+		if err != nil {
+			if websocket.CloseStatus(err) != -1 {
+				return nil, errClosed
+			}
+			return nil, fmt.Errorf("waiting for %s: %w", eventType, err)
+		}
+		var e envelope
+		if err := json.Unmarshal(data, &e); err != nil {
+			return nil, fmt.Errorf("decode event: %w", err)
+		}
+		if e.Type == eventType {
+			return e.Payload, nil
+		}
+	}
+}
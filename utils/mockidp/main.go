@@ -0,0 +1,299 @@
+// Serve a minimal OpenID Connect provider for testing logins locally
+//
+// Implements just enough of the authorization code flow with PKCE for cca:
+// /authorize asks for an email address instead of a password, /token redeems
+// each code once after checking the client, redirect URI and code verifier,
+// /jwks serves the key that ID tokens are signed with, and
+// /.well-known/openid-configuration lists them all for discovery. The key is
+// generated at startup, so cca must be started after this.
+//
+// Set oidc.issuer to the -issuer flag and oidc.client to the -client flag.
+// ID tokens carry the email address in the email, upn and
+// preferred_username claims, so that any of them may be used as
+// identity.claim.
+package main
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/json"
+	"flag"
+	"html/template"
+	"log"
+	"math/big"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+const (
+	codeLifetime  = time.Minute
+	tokenLifetime = time.Hour
+)
+
+type authorization struct {
+	clientID    string
+	redirectURI string
+	challenge   string
+	nonce       string
+	email       string
+	name        string
+	expires     time.Time
+}
+
+type provider struct {
+	issuer   string
+	clientID string
+	key      *rsa.PrivateKey
+	keyID    string
+
+	mu    sync.Mutex
+	codes map[string]authorization
+}
+
+func main() {
+	listen := flag.String("listen", "localhost:8090", "address to listen on")
+	issuer := flag.String("issuer", "http://localhost:8090", "issuer URL, which must match oidc.issuer")
+	clientID := flag.String("client", "cca-dev", "client ID to accept, which must match oidc.client")
+	flag.Parse()
+
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		log.Fatalln(err)
+	}
+
+	p := &provider{
+		issuer:   strings.TrimRight(*issuer, "/"),
+		clientID: *clientID,
+		key:      key,
+		keyID:    rand.Text(),
+		codes:    make(map[string]authorization),
+	}
+
+	http.HandleFunc("/authorize", p.handleAuthorize)
+	http.HandleFunc("/token", p.handleToken)
+	http.HandleFunc("/jwks", p.handleJWKS)
+	http.HandleFunc("/.well-known/openid-configuration", p.handleDiscovery)
+
+	log.Printf("listening on %s as issuer %s for client %s", *listen, p.issuer, p.clientID)
+	server := &http.Server{
+		Addr:              *listen,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+	log.Fatalln(server.ListenAndServe())
+}
+
+var loginPage = template.Must(template.New("login").Parse(`<!DOCTYPE html>
+<html>
+<head><title>Mock identity provider</title></head>
+<body>
+<h1>Mock identity provider</h1>
+<p>Logging in to {{.ClientID}}. Any email address is accepted.</p>
+<form method="post">
+<label>Email <input type="email" name="email" required autofocus></label>
+<label>Name <input type="text" name="name"></label>
+<button type="submit">Log in</button>
+</form>
+</body>
+</html>
+`))
+
+// handleAuthorize shows the login form on GET and issues a code on POST. The
+// form posts back to the same URL, so the authorization request is still in
+// the query string.
+func (p *provider) handleAuthorize(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	clientID := r.Form.Get("client_id")
+	redirectURI := r.Form.Get("redirect_uri")
+	switch {
+	case clientID != p.clientID:
+		http.Error(w, "unknown client_id", http.StatusBadRequest)
+		return
+	case redirectURI == "":
+		http.Error(w, "missing redirect_uri", http.StatusBadRequest)
+		return
+	case r.Form.Get("response_type") != "code":
+		http.Error(w, "only response_type=code is supported", http.StatusBadRequest)
+		return
+	case r.Form.Get("code_challenge_method") != "S256" || r.Form.Get("code_challenge") == "":
+		http.Error(w, "PKCE with S256 is required", http.StatusBadRequest)
+		return
+	}
+
+	if r.Method == http.MethodGet {
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		if err := loginPage.Execute(w, map[string]string{"ClientID": clientID}); err != nil {
+			log.Println(err)
+		}
+		return
+	}
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	email := strings.TrimSpace(r.PostForm.Get("email"))
+	if email == "" {
+		http.Error(w, "missing email", http.StatusBadRequest)
+		return
+	}
+	name := strings.TrimSpace(r.PostForm.Get("name"))
+	if name == "" {
+		name, _, _ = strings.Cut(email, "@")
+	}
+
+	code := rand.Text()
+	p.mu.Lock()
+	p.codes[code] = authorization{
+		clientID:    clientID,
+		redirectURI: redirectURI,
+		challenge:   r.Form.Get("code_challenge"),
+		nonce:       r.Form.Get("nonce"),
+		email:       email,
+		name:        name,
+		expires:     time.Now().Add(codeLifetime),
+	}
+	p.mu.Unlock()
+
+	target, err := url.Parse(redirectURI)
+	if err != nil {
+		http.Error(w, "invalid redirect_uri", http.StatusBadRequest)
+		return
+	}
+	q := target.Query()
+	q.Set("code", code)
+	if state := r.Form.Get("state"); state != "" {
+		q.Set("state", state)
+	}
+	target.RawQuery = q.Encode()
+	log.Printf("issued code for %s", email)
+	http.Redirect(w, r, target.String(), http.StatusSeeOther)
+}
+
+func (p *provider) handleToken(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if err := r.ParseForm(); err != nil {
+		tokenError(w, "invalid_request", err.Error())
+		return
+	}
+	if r.PostForm.Get("grant_type") != "authorization_code" {
+		tokenError(w, "unsupported_grant_type", "only authorization_code is supported")
+		return
+	}
+
+	// Codes are removed before checking them, so a code cannot be redeemed
+	// twice even if the first attempt fails.
+	code := r.PostForm.Get("code")
+	p.mu.Lock()
+	auth, ok := p.codes[code]
+	delete(p.codes, code)
+	p.mu.Unlock()
+
+	sum := sha256.Sum256([]byte(r.PostForm.Get("code_verifier")))
+	challenge := base64.RawURLEncoding.EncodeToString(sum[:])
+
+	switch {
+	case !ok || time.Now().After(auth.expires):
+		tokenError(w, "invalid_grant", "unknown, expired or already redeemed code")
+		return
+	case r.PostForm.Get("client_id") != auth.clientID:
+		tokenError(w, "invalid_grant", "code was issued to another client")
+		return
+	case r.PostForm.Get("redirect_uri") != auth.redirectURI:
+		tokenError(w, "invalid_grant", "redirect_uri does not match the authorization request")
+		return
+	case subtle.ConstantTimeCompare([]byte(challenge), []byte(auth.challenge)) != 1:
+		tokenError(w, "invalid_grant", "code_verifier does not match code_challenge")
+		return
+	}
+
+	now := time.Now()
+	token := jwt.NewWithClaims(jwt.SigningMethodRS256, jwt.MapClaims{
+		"iss":                p.issuer,
+		"sub":                auth.email,
+		"aud":                auth.clientID,
+		"iat":                now.Unix(),
+		"exp":                now.Add(tokenLifetime).Unix(),
+		"nonce":              auth.nonce,
+		"email":              auth.email,
+		"name":               auth.name,
+		"upn":                auth.email,
+		"preferred_username": auth.email,
+	})
+	token.Header["kid"] = p.keyID
+	idToken, err := token.SignedString(p.key)
+	if err != nil {
+		log.Println(err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	log.Printf("redeemed code for %s", auth.email)
+	writeJSON(w, http.StatusOK, map[string]any{
+		"token_type": "Bearer",
+		"expires_in": int(tokenLifetime / time.Second),
+		"id_token":   idToken,
+	})
+}
+
+func (p *provider) handleJWKS(w http.ResponseWriter, _ *http.Request) {
+	pub := p.key.PublicKey
+	writeJSON(w, http.StatusOK, map[string]any{
+		"keys": []map[string]string{{
+			"kty": "RSA",
+			"use": "sig",
+			"alg": "RS256",
+			"kid": p.keyID,
+			"n":   base64.RawURLEncoding.EncodeToString(pub.N.Bytes()),
+			"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(pub.E)).Bytes()),
+		}},
+	})
+}
+
+// handleDiscovery serves the endpoints of this server, which are all
+// relative to the issuer.
+func (p *provider) handleDiscovery(w http.ResponseWriter, _ *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]any{
+		"issuer":                                p.issuer,
+		"authorization_endpoint":                p.issuer + "/authorize",
+		"token_endpoint":                        p.issuer + "/token",
+		"jwks_uri":                              p.issuer + "/jwks",
+		"response_types_supported":              []string{"code"},
+		"subject_types_supported":               []string{"public"},
+		"id_token_signing_alg_values_supported": []string{"RS256"},
+		"scopes_supported":                      []string{"openid", "profile", "email"},
+		"code_challenge_methods_supported":      []string{"S256"},
+		"grant_types_supported":                 []string{"authorization_code"},
+	})
+}
+
+func tokenError(w http.ResponseWriter, code, description string) {
+	log.Printf("token error: %s: %s", code, description)
+	writeJSON(w, http.StatusBadRequest, map[string]string{
+		"error":             code,
+		"error_description": description,
+	})
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		log.Println(err)
+	}
+}
@@ -4,17 +4,106 @@ import (
 	"context"
 	"log/slog"
 	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/coder/websocket"
 )
 
-type WSMessage string
-
 type Client struct {
-	conn      *websocket.Conn
+	transport clientTransport
 	send      chan WSMessage
 	hub       *WebSocketHub
 	studentID int64
+	grade     string
+	// protocol is the negotiated subprotocol, empty for legacy clients.
+	protocol string
+	// seq numbers the messages written to this connection. Dropped
+	// messages also consume a number, so clients can detect the gap and
+	// resynchronize.
+	seq atomic.Uint64
+
+	// pending holds state events, by type, that did not fit in send. Once
+	// an event type is pending, newer events of that type are merged into
+	// it rather than queued behind it, so they cannot be overtaken.
+	pendingMu sync.Mutex
+	pending   map[string]WSMessage
+	wake      chan struct{}
+
+	connected time.Time
+	// done is closed when the connection can no longer be read from.
+	done chan struct{}
+	// closeStatus and closeReason are set by the hub before it closes send.
+	closeStatus websocket.StatusCode
+	closeReason string
+}
+
+func newClient(transport clientTransport, protocol string, hub *WebSocketHub, studentID int64, grade string) *Client {
+	return &Client{
+		transport:   transport,
+		send:        make(chan WSMessage, 256),
+		hub:         hub,
+		studentID:   studentID,
+		grade:       grade,
+		protocol:    protocol,
+		pending:     make(map[string]WSMessage),
+		wake:        make(chan struct{}, 1),
+		connected:   time.Now(),
+		done:        make(chan struct{}),
+		closeStatus: websocket.StatusNormalClosure,
+	}
+}
+
+// enqueue is called by the hub only.
+func (c *Client) enqueue(message WSMessage) {
+	state, isState := message.Payload.(wsStatePayload)
+	if isState {
+		c.pendingMu.Lock()
+		older, ok := c.pending[message.Type()]
+		if ok {
+			c.pending[message.Type()] = newWSMessage(state.supersede(older.Payload))
+			c.pendingMu.Unlock()
+			c.hub.coalesced.Add(1)
+			return
+		}
+		c.pendingMu.Unlock()
+	}
+
+	select {
+	case c.send <- message:
+		return
+	default:
+	}
+
+	if !isState {
+		c.seq.Add(1)
+		c.hub.dropped.Add(1)
+		slog.Warn(logMsgWebsocketDropSlowClient, slog.Int64("student_id", c.studentID), slog.String("type", message.Type()))
+		return
+	}
+
+	c.pendingMu.Lock()
+	c.pending[message.Type()] = message
+	c.pendingMu.Unlock()
+	c.hub.coalesced.Add(1)
+	select {
+	case c.wake <- struct{}{}:
+	default:
+	}
+}
+
+func (c *Client) takePending() []WSMessage {
+	c.pendingMu.Lock()
+	defer c.pendingMu.Unlock()
+	if len(c.pending) == 0 {
+		return nil
+	}
+	messages := make([]WSMessage, 0, len(c.pending))
+	for _, message := range c.pending {
+		messages = append(messages, message)
+	}
+	clear(c.pending)
+	return messages
 }
 
 type WebSocketHub struct {
@@ -27,14 +116,61 @@ type WebSocketHub struct {
 		studentIDs []int64
 		message    WSMessage
 	}
+
+	// cluster relays events to other instances; nil when running alone.
+	cluster *WSCluster
+	// observe, if set before Run, is called with every event this instance
+	// delivers, and the students it is for or nil for everyone.
+	observe func(message WSMessage, studentIDs []int64)
+
+	pingInterval  time.Duration
+	writeTimeout  time.Duration
+	maxPerStudent int
+
+	dropped      atomic.Int64
+	coalesced    atomic.Int64
+	pingFailures atomic.Int64
+
+	// Guarded by mu.
+	acceptedTotal int64
+	closedTotal   int64
+	evictedTotal  int64
+	lifetimeTotal time.Duration
+}
+
+type WebSocketHubStats struct {
+	Students     int
+	Connections  int
+	QueueDepth   int
+	QueueSize    int
+	DroppedTotal int64
+	// CoalescedTotal counts state events that were merged into an unsent
+	// event for a slow client rather than dropped.
+	CoalescedTotal int64
+
+	AcceptedTotal int64
+	ClosedTotal   int64
+	// EvictedTotal counts connections closed because the student opened
+	// more than the allowed number.
+	EvictedTotal      int64
+	PingFailuresTotal int64
+	AverageLifetime   time.Duration
+	LongestOpen       time.Duration
 }
 
-func NewWebSocketHub() *WebSocketHub {
+// NewWebSocketHub creates a hub that pings every connection each
+// pingInterval, closes connections that do not answer a ping or accept a
+// write within writeTimeout, and keeps at most maxPerStudent connections per
+// student, closing the oldest ones first. Zero disables each of these.
+func NewWebSocketHub(pingInterval, writeTimeout time.Duration, maxPerStudent int) *WebSocketHub {
 	return &WebSocketHub{
-		clients:    make(map[int64]map[*Client]struct{}),
-		broadcast:  make(chan WSMessage, 256),
-		register:   make(chan *Client),
-		unregister: make(chan *Client),
+		pingInterval:  pingInterval,
+		writeTimeout:  writeTimeout,
+		maxPerStudent: maxPerStudent,
+		clients:       make(map[int64]map[*Client]struct{}),
+		broadcast:     make(chan WSMessage, 256),
+		register:      make(chan *Client),
+		unregister:    make(chan *Client),
 		broadcastTarget: make(chan struct {
 			studentIDs []int64
 			message    WSMessage
@@ -47,65 +183,163 @@ func (h *WebSocketHub) Run() {
 		select {
 		case client := <-h.register:
 			h.mu.Lock()
-			if _, ok := h.clients[client.studentID]; !ok {
-				h.clients[client.studentID] = make(map[*Client]struct{})
+			clients, ok := h.clients[client.studentID]
+			if !ok {
+				clients = make(map[*Client]struct{})
+				h.clients[client.studentID] = clients
+			}
+			for h.maxPerStudent > 0 && len(clients) >= h.maxPerStudent {
+				var oldest *Client
+				for c := range clients {
+					if oldest == nil || c.connected.Before(oldest.connected) {
+						oldest = c
+					}
+				}
+				oldest.closeStatus = websocket.StatusPolicyViolation
+				oldest.closeReason = "too many connections"
+				h.evictedTotal++
+				h.remove(oldest)
 			}
-			h.clients[client.studentID][client] = struct{}{}
+			clients[client] = struct{}{}
+			h.acceptedTotal++
 			h.mu.Unlock()
 			slog.Info(logMsgWebsocketClientRegistered, slog.Int64("student_id", client.studentID))
 
 		case client := <-h.unregister:
 			h.mu.Lock()
-			if clients, ok := h.clients[client.studentID]; ok {
-				if _, exists := clients[client]; exists {
-					delete(clients, client)
-					close(client.send)
-					if len(clients) == 0 {
-						delete(h.clients, client.studentID)
-					}
-				}
+			if _, exists := h.clients[client.studentID][client]; exists {
+				h.remove(client)
 			}
 			h.mu.Unlock()
-			slog.Info(logMsgWebsocketClientUnregistered, slog.Int64("student_id", client.studentID))
 
 		case message := <-h.broadcast:
 			h.mu.RLock()
 			for _, clients := range h.clients {
 				for client := range clients {
-					select {
-					case client.send <- message:
-					default:
-						slog.Warn(logMsgWebsocketDropSlowClient)
-					}
+					client.enqueue(message)
 				}
 			}
 			h.mu.RUnlock()
-			slog.Info(logMsgWebsocketBroadcastAll, slog.String("message", string(message)))
+			if h.observe != nil {
+				h.observe(message, nil)
+			}
+			slog.Info(logMsgWebsocketBroadcastAll, slog.String("type", message.Type()))
 
 		case target := <-h.broadcastTarget:
 			h.mu.RLock()
 			for _, studentID := range target.studentIDs {
 				if clients, ok := h.clients[studentID]; ok {
 					for client := range clients {
-						select {
-						case client.send <- target.message:
-						default:
-							slog.Warn(logMsgWebsocketDropTargetedSlowClient)
-						}
+						client.enqueue(target.message)
 					}
 				}
 			}
 			h.mu.RUnlock()
-			slog.Info(logMsgWebsocketBroadcastTargeted, slog.String("message", string(target.message)), slog.Int("targets", len(target.studentIDs)))
+			if h.observe != nil {
+				h.observe(target.message, target.studentIDs)
+			}
+			slog.Info(logMsgWebsocketBroadcastTargeted, slog.String("type", target.message.Type()), slog.Int("targets", len(target.studentIDs)))
 		}
 	}
 }
 
+// remove must be called with mu held.
+func (h *WebSocketHub) remove(client *Client) {
+	clients := h.clients[client.studentID]
+	delete(clients, client)
+	if len(clients) == 0 {
+		delete(h.clients, client.studentID)
+	}
+	close(client.send)
+
+	lifetime := time.Since(client.connected)
+	h.closedTotal++
+	h.lifetimeTotal += lifetime
+	slog.Info(logMsgWebsocketClientUnregistered, slog.Int64("student_id", client.studentID), slog.Duration("lifetime", lifetime), slog.String("reason", client.closeReason))
+}
+
+func (h *WebSocketHub) Stats() WebSocketHubStats {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	stats := WebSocketHubStats{
+		Students:          len(h.clients),
+		QueueDepth:        len(h.broadcast) + len(h.broadcastTarget),
+		QueueSize:         cap(h.broadcast) + cap(h.broadcastTarget),
+		DroppedTotal:      h.dropped.Load(),
+		CoalescedTotal:    h.coalesced.Load(),
+		AcceptedTotal:     h.acceptedTotal,
+		ClosedTotal:       h.closedTotal,
+		EvictedTotal:      h.evictedTotal,
+		PingFailuresTotal: h.pingFailures.Load(),
+	}
+	if h.closedTotal > 0 {
+		stats.AverageLifetime = (h.lifetimeTotal / time.Duration(h.closedTotal)).Round(time.Second)
+	}
+	for _, clients := range h.clients {
+		stats.Connections += len(clients)
+		for client := range clients {
+			stats.LongestOpen = max(stats.LongestOpen, time.Since(client.connected).Round(time.Second))
+		}
+	}
+	return stats
+}
+
+// ConnectedStudents returns how many of the given students have at least one
+// connection to this instance, or how many students do if studentIDs is nil.
+func (h *WebSocketHub) ConnectedStudents(studentIDs []int64) int {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	if studentIDs == nil {
+		return len(h.clients)
+	}
+	n := 0
+	for _, id := range studentIDs {
+		if _, ok := h.clients[id]; ok {
+			n++
+		}
+	}
+	return n
+}
+
+// ConnectedByGrade returns how many students of each grade have at least one
+// connection to this instance.
+func (h *WebSocketHub) ConnectedByGrade() map[string]int {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	grades := make(map[string]int)
+	for _, clients := range h.clients {
+		for client := range clients {
+			grades[client.grade]++
+			break
+		}
+	}
+	return grades
+}
+
+// Broadcast sends msg to every connected student on every instance.
 func (h *WebSocketHub) Broadcast(msg WSMessage) {
-	h.broadcast <- msg
+	h.deliver(msg)
+	if h.cluster != nil {
+		h.cluster.publish(nil, msg)
+	}
 }
 
+// BroadcastToStudents sends msg to the given students on every instance.
 func (h *WebSocketHub) BroadcastToStudents(studentIDs []int64, msg WSMessage) {
+	h.deliverToStudents(studentIDs, msg)
+	if h.cluster != nil {
+		h.cluster.publish(studentIDs, msg)
+	}
+}
+
+func (h *WebSocketHub) deliver(msg WSMessage) {
+	h.broadcast <- msg
+}
+
+func (h *WebSocketHub) deliverToStudents(studentIDs []int64, msg WSMessage) {
 	h.broadcastTarget <- struct {
 		studentIDs []int64
 		message    WSMessage
@@ -114,30 +348,114 @@ func (h *WebSocketHub) BroadcastToStudents(studentIDs []int64, msg WSMessage) {
 
 func (c *Client) writePump() {
 	defer func() {
-		_ = c.conn.Close(websocket.StatusNormalClosure, "")
+		c.transport.close(c.closeStatus, c.closeReason)
 	}()
 
-	for message := range c.send {
-		if err := c.conn.Write(context.Background(), websocket.MessageText, []byte(message)); err != nil {
-			slog.Error(logMsgWebsocketWriteError, slog.Any("error", err))
+	for {
+		// Queued events go first: a pending event is always newer than any
+		// queued event of the same type.
+		select {
+		case message, ok := <-c.send:
+			if !ok {
+				return
+			}
+			if !c.writeOrLog(message) {
+				return
+			}
+			continue
+		default:
+		}
+
+		if messages := c.takePending(); messages != nil {
+			for _, message := range messages {
+				if !c.writeOrLog(message) {
+					return
+				}
+			}
+			continue
+		}
+
+		select {
+		case message, ok := <-c.send:
+			if !ok {
+				return
+			}
+			if !c.writeOrLog(message) {
+				return
+			}
+		case <-c.wake:
+		}
+	}
+}
+
+func (c *Client) writeOrLog(message WSMessage) bool {
+	if err := c.write(context.Background(), message); err != nil {
+		slog.Error(logMsgWebsocketWriteError, slog.Int64("student_id", c.studentID), slog.Any("error", err))
+		// Unblock readPump, which unregisters the client.
+		c.transport.closeNow()
+		return false
+	}
+	return true
+}
+
+func (c *Client) write(ctx context.Context, message WSMessage) error {
+	data, err := message.encode(c.protocol, c.seq.Add(1))
+	if err != nil {
+		return err
+	}
+	if len(data) == 0 {
+		return nil
+	}
+	ctx, cancel := c.hub.withWriteTimeout(ctx)
+	defer cancel()
+	return c.transport.write(ctx, message, data)
+}
+
+func (h *WebSocketHub) withWriteTimeout(ctx context.Context) (context.Context, context.CancelFunc) {
+	if h.writeTimeout <= 0 {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeout(ctx, h.writeTimeout)
+}
+
+// pingPump closes the connection if the client stops answering pings, as
+// clients that disappear without closing the connection, such as laptops
+// being closed, would otherwise stay registered until TCP gives up.
+func (c *Client) pingPump() {
+	if c.hub.pingInterval <= 0 {
+		return
+	}
+	ticker := time.NewTicker(c.hub.pingInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-c.done:
+			return
+		case <-ticker.C:
+		}
+
+		ctx, cancel := c.hub.withWriteTimeout(context.Background())
+		err := c.transport.ping(ctx)
+		cancel()
+		if err != nil {
+			c.hub.pingFailures.Add(1)
+			slog.Info(logMsgWebsocketPingFailed, slog.Int64("student_id", c.studentID), slog.Any("error", err))
+			c.transport.closeNow()
 			return
 		}
 	}
 }
 
+// readPump returns once the client has gone away.
 func (c *Client) readPump() {
 	defer func() {
+		close(c.done)
 		c.hub.unregister <- c
-		_ = c.conn.Close(websocket.StatusNormalClosure, "")
+		c.transport.close(websocket.StatusNormalClosure, "")
 	}()
 
-	for {
-		_, _, err := c.conn.Read(context.Background())
-		if err != nil {
-			if websocket.CloseStatus(err) != websocket.StatusNormalClosure && websocket.CloseStatus(err) != websocket.StatusGoingAway {
-				slog.Error(logMsgWebsocketReadError, slog.Any("error", err))
-			}
-			break
-		}
+	if err := c.transport.wait(); err != nil {
+		slog.Error(logMsgWebsocketReadError, slog.Any("error", err))
 	}
 }
@@ -0,0 +1,268 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+
+	"git.sr.ht/~runxiyu/cca/db"
+)
+
+// ChangeDispatcher turns the rows that triggers write to change_outbox into
+// WebSocket events carrying the new state. Rows are claimed with SKIP LOCKED,
+// so with several instances each change is dispatched by one of them and
+// relayed to the others by the hub.
+type ChangeDispatcher struct {
+	pool         *pgxpool.Pool
+	queries      *db.Queries
+	hub          *WebSocketHub
+	courseCounts *CourseCountBatcher
+	grades       func(context.Context) ([]AbsGradesRow, error)
+	wake         chan struct{}
+
+	listening atomic.Bool
+
+	mu           sync.Mutex
+	batchesTotal int64
+	changesTotal int64
+	errorsTotal  int64
+	lastDispatch time.Time
+}
+
+type ChangeDispatcherStats struct {
+	Listening    bool
+	BatchesTotal int64
+	ChangesTotal int64
+	ErrorsTotal  int64
+	LastDispatch time.Time
+}
+
+const (
+	// changeOutboxChannel must match the channel in the schema's triggers.
+	changeOutboxChannel = "cca_outbox"
+	changeBatchSize     = 500
+	// Changes are also picked up periodically, in case a notification was
+	// missed while the listener was reconnecting.
+	changePollInterval = 5 * time.Second
+)
+
+func NewChangeDispatcher(pool *pgxpool.Pool, queries *db.Queries, hub *WebSocketHub, courseCounts *CourseCountBatcher, grades func(context.Context) ([]AbsGradesRow, error)) *ChangeDispatcher {
+	return &ChangeDispatcher{
+		pool:         pool,
+		queries:      queries,
+		hub:          hub,
+		courseCounts: courseCounts,
+		grades:       grades,
+		wake:         make(chan struct{}, 1),
+	}
+}
+
+func (d *ChangeDispatcher) Run(ctx context.Context) {
+	go listenForever(ctx, d.pool, changeOutboxChannel, &d.listening, d.notify, func(string) { d.notify() })
+
+	ticker := time.NewTicker(changePollInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-d.wake:
+		case <-ticker.C:
+		}
+		d.drain(ctx)
+	}
+}
+
+func (d *ChangeDispatcher) notify() {
+	select {
+	case d.wake <- struct{}{}:
+	default:
+	}
+}
+
+func (d *ChangeDispatcher) drain(ctx context.Context) {
+	for {
+		n, err := d.dispatch(ctx)
+		if err != nil {
+			d.mu.Lock()
+			d.errorsTotal++
+			d.mu.Unlock()
+			slog.Error(logMsgChangesDispatchError, slog.Any("error", err))
+			return
+		}
+		if n < changeBatchSize {
+			return
+		}
+	}
+}
+
+// dispatch claims one batch of changes and sends the resulting events. The
+// changes are only removed once the events have been handed to the hub;
+// should anything fail, they are dispatched again later.
+func (d *ChangeDispatcher) dispatch(ctx context.Context) (int, error) {
+	tx, err := d.pool.Begin(ctx)
+	if err != nil {
+		return 0, fmt.Errorf("begin: %w", err)
+	}
+	defer func() {
+		_ = tx.Rollback(ctx)
+	}()
+	qtx := d.queries.WithTx(tx)
+
+	changes, err := qtx.ClaimChanges(ctx, changeBatchSize)
+	if err != nil {
+		return 0, fmt.Errorf("claim changes: %w", err)
+	}
+	if len(changes) == 0 {
+		return 0, nil
+	}
+
+	selectionStudents := make(map[int64]struct{})
+	changedStudents := make(map[int64]struct{})
+	courseIDs := make(map[string]struct{})
+	resources := make(map[string]bool)
+	for _, change := range changes {
+		switch change.Resource {
+		case wsResourceSelections, "student":
+			id, err := strconv.ParseInt(change.Key, 10, 64)
+			if err != nil {
+				slog.Warn(logMsgChangesDispatchError, slog.String("resource", change.Resource), slog.String("key", change.Key), slog.Any("error", err))
+				continue
+			}
+			if change.Resource == wsResourceSelections {
+				selectionStudents[id] = struct{}{}
+			} else {
+				changedStudents[id] = struct{}{}
+			}
+		case "course_counts":
+			courseIDs[change.Key] = struct{}{}
+		default:
+			resources[change.Resource] = true
+		}
+	}
+
+	var messages []func()
+	if len(selectionStudents) > 0 {
+		sends, err := d.selectionMessages(ctx, qtx, setKeys(selectionStudents))
+		if err != nil {
+			return 0, err
+		}
+		messages = append(messages, sends...)
+	}
+	if resources[wsResourceCourses] {
+		courses, err := qtx.GetCourses(ctx)
+		if err != nil {
+			return 0, fmt.Errorf("fetch courses: %w", err)
+		}
+		messages = append(messages, d.broadcast(WSCourses{Courses: courses}))
+	}
+	if resources[wsResourceGrades] {
+		grades, err := d.grades(ctx)
+		if err != nil {
+			return 0, err
+		}
+		messages = append(messages, d.broadcast(WSGrades{Grades: grades}))
+	}
+	if resources[wsResourceCategories] {
+		categories, err := qtx.GetCategories(ctx)
+		if err != nil {
+			return 0, fmt.Errorf("fetch categories: %w", err)
+		}
+		messages = append(messages, d.broadcast(WSCategories{Categories: categories}))
+	}
+	if resources[wsResourcePeriods] {
+		periods, err := qtx.GetPeriods(ctx)
+		if err != nil {
+			return 0, fmt.Errorf("fetch periods: %w", err)
+		}
+		messages = append(messages, d.broadcast(WSPeriods{Periods: periods}))
+	}
+	if len(changedStudents) > 0 {
+		// The student's own record, such as their grade, is part of the
+		// snapshot, so they simply fetch it again.
+		students := setKeys(changedStudents)
+		messages = append(messages, func() {
+			d.hub.BroadcastToStudents(students, newWSMessage(WSResync{}))
+		})
+	}
+
+	for _, send := range messages {
+		send()
+	}
+	d.courseCounts.Mark(setKeys(courseIDs))
+
+	if err := tx.Commit(ctx); err != nil {
+		return 0, fmt.Errorf("commit: %w", err)
+	}
+
+	d.mu.Lock()
+	d.batchesTotal++
+	d.changesTotal += int64(len(changes))
+	d.lastDispatch = time.Now()
+	d.mu.Unlock()
+	slog.Debug(logMsgChangesDispatched, slog.Int("changes", len(changes)), slog.Int("events", len(messages)))
+
+	return len(changes), nil
+}
+
+func (d *ChangeDispatcher) selectionMessages(ctx context.Context, q *db.Queries, studentIDs []int64) ([]func(), error) {
+	rows, err := q.GetSelectionsByStudents(ctx, studentIDs)
+	if err != nil {
+		return nil, fmt.Errorf("fetch selections: %w", err)
+	}
+
+	byStudent := make(map[int64][]db.GetSelectionsByStudentRow, len(studentIDs))
+	for _, id := range studentIDs {
+		byStudent[id] = []db.GetSelectionsByStudentRow{}
+	}
+	for _, row := range rows {
+		byStudent[row.StudentID] = append(byStudent[row.StudentID], db.GetSelectionsByStudentRow{
+			CourseID:      row.CourseID,
+			Period:        row.Period,
+			SelectionType: row.SelectionType,
+		})
+	}
+
+	messages := make([]func(), 0, len(byStudent))
+	for id, selections := range byStudent {
+		message := newWSMessage(WSSelections{Selections: selections})
+		messages = append(messages, func() {
+			d.hub.BroadcastToStudents([]int64{id}, message)
+		})
+	}
+	return messages, nil
+}
+
+func (d *ChangeDispatcher) broadcast(payload WSPayload) func() {
+	message := newWSMessage(payload)
+	return func() {
+		d.hub.Broadcast(message)
+	}
+}
+
+func (d *ChangeDispatcher) Stats() ChangeDispatcherStats {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return ChangeDispatcherStats{
+		Listening:    d.listening.Load(),
+		BatchesTotal: d.batchesTotal,
+		ChangesTotal: d.changesTotal,
+		ErrorsTotal:  d.errorsTotal,
+		LastDispatch: d.lastDispatch,
+	}
+}
+
+func setKeys[K comparable](set map[K]struct{}) []K {
+	keys := make([]K, 0, len(set))
+	for k := range set {
+		keys = append(keys, k)
+	}
+	return keys
+}
@@ -0,0 +1,239 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"sync/atomic"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+
+	"git.sr.ht/~runxiyu/cca/db"
+)
+
+// WSCluster relays hub events between cca instances that share a database,
+// so that students receive events regardless of which instance they are
+// connected to. Events are published with pg_notify and received on a
+// dedicated LISTEN connection per instance. Each instance delivers its own
+// events locally without waiting for the round trip.
+type WSCluster struct {
+	hub     *WebSocketHub
+	pool    *pgxpool.Pool
+	queries *db.Queries
+	origin  string
+	outbox  chan wsClusterEvent
+
+	listening     atomic.Bool
+	published     atomic.Int64
+	received      atomic.Int64
+	downgraded    atomic.Int64
+	referenced    atomic.Int64
+	dropped       atomic.Int64
+	publishErrors atomic.Int64
+	reconnects    atomic.Int64
+}
+
+type WSClusterStats struct {
+	Listening     bool
+	Published     int64
+	Received      int64
+	Downgraded    int64
+	Referenced    int64
+	Dropped       int64
+	PublishErrors int64
+	Reconnects    int64
+}
+
+const (
+	// wsClusterChannel must match the channel in the PublishWSEvent query.
+	wsClusterChannel = "cca_ws"
+	// Postgres rejects notification payloads of 8000 bytes or more.
+	wsClusterMaxPayload = 7900
+)
+
+type wsClusterEvent struct {
+	Origin  string          `json:"o"`
+	Type    string          `json:"t"`
+	Payload json.RawMessage `json:"p"`
+	Time    time.Time       `json:"ts"`
+	ID      int64           `json:"id,omitempty"`
+	// Students is nil for events sent to everyone.
+	Students []int64 `json:"s,omitempty"`
+	// Logged events too large to send are read back from the event log by
+	// ID instead, with Payload and Students left empty.
+	Logged bool `json:"l,omitempty"`
+}
+
+func NewWSCluster(hub *WebSocketHub, pool *pgxpool.Pool, queries *db.Queries) (*WSCluster, error) {
+	origin := make([]byte, 8)
+	if _, err := rand.Read(origin); err != nil {
+		return nil, err
+	}
+	return &WSCluster{
+		hub:     hub,
+		pool:    pool,
+		queries: queries,
+		origin:  hex.EncodeToString(origin),
+		outbox:  make(chan wsClusterEvent, 1024),
+	}, nil
+}
+
+// publish queues an event for the other instances. It never blocks, as it
+// is called from request handlers; if the queue is full the event is lost
+// and the other instances' clients are out of date until their next resync.
+func (c *WSCluster) publish(studentIDs []int64, message WSMessage) {
+	event := wsClusterEvent{
+		Origin:   c.origin,
+		Type:     message.Type(),
+		Payload:  message.payloadJSON,
+		Time:     message.Time,
+		ID:       message.ID,
+		Students: studentIDs,
+	}
+	select {
+	case c.outbox <- event:
+	default:
+		c.dropped.Add(1)
+		slog.Warn(logMsgWebsocketClusterDrop, slog.String("type", event.Type))
+	}
+}
+
+func (c *WSCluster) runPublisher(ctx context.Context) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case event := <-c.outbox:
+			data, err := c.encode(event)
+			if err != nil {
+				c.dropped.Add(1)
+				slog.Warn(logMsgWebsocketClusterDrop, slog.String("type", event.Type), slog.Any("error", err))
+				continue
+			}
+			if err := c.queries.PublishWSEvent(ctx, string(data)); err != nil {
+				c.publishErrors.Add(1)
+				slog.Error(logMsgWebsocketClusterPublishError, slog.String("type", event.Type), slog.Any("error", err))
+				continue
+			}
+			c.published.Add(1)
+		}
+	}
+}
+
+var errWSClusterTooLarge = errors.New("event too large for pg_notify and has no resource to invalidate")
+
+// encode marshals an event. Events that are too large for pg_notify are
+// sent by their ID if they are in the event log, such as notifications to
+// long lists of students, and are otherwise replaced with an invalidation of
+// the resource they describe so that remote clients refetch it instead.
+func (c *WSCluster) encode(event wsClusterEvent) ([]byte, error) {
+	data, err := json.Marshal(event)
+	if err != nil || len(data) <= wsClusterMaxPayload {
+		return data, err
+	}
+
+	if event.ID != 0 {
+		c.referenced.Add(1)
+		event.Payload = nil
+		event.Students = nil
+		event.Logged = true
+		return json.Marshal(event)
+	}
+
+	resource, ok := wsResourceOf(event.Type)
+	if !ok {
+		return nil, errWSClusterTooLarge
+	}
+	c.downgraded.Add(1)
+	event.Type = WSInvalidate{}.wsType()
+	event.Payload, err = json.Marshal(WSInvalidate{Resource: resource})
+	if err != nil {
+		return nil, err
+	}
+	data, err = json.Marshal(event)
+	if err != nil || len(data) <= wsClusterMaxPayload {
+		return data, err
+	}
+	// Only the student list can still be too long.
+	event.Students = nil
+	return json.Marshal(event)
+}
+
+// runListener receives the other instances' events. Events published while
+// it was disconnected are lost, so clients of this instance are told to
+// resynchronize after every reconnection.
+func (c *WSCluster) runListener(ctx context.Context) {
+	connectedBefore := false
+	listenForever(ctx, c.pool, wsClusterChannel, &c.listening, func() {
+		if connectedBefore {
+			c.reconnects.Add(1)
+			c.hub.deliver(newWSMessage(WSResync{}))
+		}
+		connectedBefore = true
+	}, c.handleNotification)
+}
+
+func (c *WSCluster) handleNotification(data string) {
+	var event wsClusterEvent
+	if err := json.Unmarshal([]byte(data), &event); err != nil {
+		slog.Error(logMsgWebsocketClusterDecodeError, slog.Any("error", err))
+		return
+	}
+	if event.Origin == c.origin {
+		return
+	}
+	if event.Logged {
+		row, err := c.queries.GetEventByID(context.Background(), event.ID)
+		if err != nil {
+			// The event may have been pruned already, which is what
+			// resynchronizing is for.
+			slog.Error(logMsgWebsocketClusterDecodeError, slog.Int64("id", event.ID), slog.Any("error", err))
+			c.hub.deliver(newWSMessage(WSResync{}))
+			return
+		}
+		event.Type = row.Type
+		event.Payload = row.Payload
+		event.Students = row.StudentIds
+	}
+
+	decode, ok := wsPayloadDecoders[event.Type]
+	if !ok {
+		slog.Error(logMsgWebsocketClusterDecodeError, slog.String("type", event.Type))
+		return
+	}
+	payload, err := decode(event.Payload)
+	if err != nil {
+		slog.Error(logMsgWebsocketClusterDecodeError, slog.String("type", event.Type), slog.Any("error", err))
+		return
+	}
+	c.received.Add(1)
+
+	message := WSMessage{Payload: payload, Time: event.Time, ID: event.ID, payloadJSON: event.Payload}
+	if event.Students == nil {
+		c.hub.deliver(message)
+	} else {
+		c.hub.deliverToStudents(event.Students, message)
+	}
+}
+
+func (c *WSCluster) Run(ctx context.Context) {
+	go c.runPublisher(ctx)
+	c.runListener(ctx)
+}
+
+func (c *WSCluster) Stats() WSClusterStats {
+	return WSClusterStats{
+		Listening:     c.listening.Load(),
+		Published:     c.published.Load(),
+		Received:      c.received.Load(),
+		Downgraded:    c.downgraded.Load(),
+		Referenced:    c.referenced.Load(),
+		Dropped:       c.dropped.Load(),
+		PublishErrors: c.publishErrors.Load(),
+		Reconnects:    c.reconnects.Load(),
+	}
+}
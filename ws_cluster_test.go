@@ -0,0 +1,174 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+
+	"git.sr.ht/~runxiyu/cca/db"
+)
+
+func TestWSClusterEncode(t *testing.T) {
+	large := WSCategories{Categories: []string{strings.Repeat("x", wsClusterMaxPayload)}}
+	manyStudents := make([]int64, 2000)
+	for i := range manyStudents {
+		manyStudents[i] = 100000 + int64(i)
+	}
+
+	tests := []struct {
+		name         string
+		message      WSMessage
+		students     []int64
+		wantType     string
+		wantLogged   bool
+		wantStudents bool
+		wantErr      error
+	}{
+		{"small", newWSMessage(WSCategories{Categories: []string{"a"}}), []int64{1}, "categories", false, true, nil},
+		{"large state", newWSMessage(large), []int64{1}, "invalidate", false, true, nil},
+		{"large state to many", newWSMessage(WSCategories{Categories: []string{"a"}}), manyStudents, "invalidate", false, false, nil},
+		{"large logged", WSMessage{Payload: WSNotify{}, ID: 7, payloadJSON: mustJSON(t, WSNotify{Text: strings.Repeat("x", wsClusterMaxPayload)})}, []int64{1}, "notify", true, false, nil},
+		{"large unlogged", newWSMessage(WSNotify{Text: strings.Repeat("x", wsClusterMaxPayload)}), nil, "", false, false, errWSClusterTooLarge},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := &WSCluster{origin: "test", outbox: make(chan wsClusterEvent, 1)}
+			c.publish(tt.students, tt.message)
+			data, err := c.encode(<-c.outbox)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("encode error = %v, want %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			if len(data) > wsClusterMaxPayload {
+				t.Fatalf("encoded %d bytes, more than %d", len(data), wsClusterMaxPayload)
+			}
+			var event wsClusterEvent
+			if err := json.Unmarshal(data, &event); err != nil {
+				t.Fatal(err)
+			}
+			if event.Type != tt.wantType || event.Logged != tt.wantLogged || (event.Students != nil) != tt.wantStudents {
+				t.Fatalf("event = %+v, want type %q, logged %v, students %v", event, tt.wantType, tt.wantLogged, tt.wantStudents)
+			}
+		})
+	}
+}
+
+func mustJSON(t *testing.T, v any) json.RawMessage {
+	t.Helper()
+	data, err := json.Marshal(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+type observedMessage struct {
+	message  WSMessage
+	students []int64
+}
+
+// startClusterInstance runs a hub with a cluster on pool, as main does with
+// websocket.cluster enabled, and returns what the hub delivers.
+func startClusterInstance(ctx context.Context, t *testing.T, pool *pgxpool.Pool) (*WebSocketHub, <-chan observedMessage) {
+	t.Helper()
+	hub := NewWebSocketHub(0, 0, 0)
+	delivered := make(chan observedMessage, 16)
+	hub.observe = func(message WSMessage, studentIDs []int64) {
+		delivered <- observedMessage{message, studentIDs}
+	}
+	cluster, err := NewWSCluster(hub, pool, db.New(pool))
+	if err != nil {
+		t.Fatal(err)
+	}
+	hub.cluster = cluster
+	go hub.Run()
+	go cluster.Run(ctx)
+	waitFor(t, cluster.listening.Load)
+	return hub, delivered
+}
+
+func receive(t *testing.T, delivered <-chan observedMessage) observedMessage {
+	t.Helper()
+	select {
+	case m := <-delivered:
+		return m
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for an event")
+		return observedMessage{}
+	}
+}
+
+// testPool connects to the database named by CCA_TEST_DATABASE_URL, which
+// must have schema.sql loaded, and skips the test if it is not set.
+func testPool(t *testing.T) *pgxpool.Pool {
+	t.Helper()
+	url := os.Getenv("CCA_TEST_DATABASE_URL")
+	if url == "" {
+		t.Skip("CCA_TEST_DATABASE_URL is not set")
+	}
+	pool, err := pgxpool.New(t.Context(), url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(pool.Close)
+	return pool
+}
+
+// TestWSClusterTwoInstances relays events between two hubs on one
+// database.
+func TestWSClusterTwoInstances(t *testing.T) {
+	pool := testPool(t)
+	ctx, cancel := context.WithCancel(t.Context())
+	defer cancel()
+
+	hubA, deliveredA := startClusterInstance(ctx, t, pool)
+	_, deliveredB := startClusterInstance(ctx, t, pool)
+
+	t.Run("targeted", func(t *testing.T) {
+		hubA.BroadcastToStudents([]int64{42}, newWSMessage(WSCategories{Categories: []string{"sports"}}))
+		if m := receive(t, deliveredA); m.message.Type() != "categories" {
+			t.Fatalf("delivered locally %s", m.message.Type())
+		}
+		m := receive(t, deliveredB)
+		payload, ok := m.message.Payload.(WSCategories)
+		if !ok || len(payload.Categories) != 1 || payload.Categories[0] != "sports" || len(m.students) != 1 || m.students[0] != 42 {
+			t.Fatalf("relayed %+v to %v", m.message.Payload, m.students)
+		}
+	})
+
+	t.Run("too large", func(t *testing.T) {
+		hubA.Broadcast(newWSMessage(WSCategories{Categories: []string{strings.Repeat("x", wsClusterMaxPayload)}}))
+		receive(t, deliveredA)
+		m := receive(t, deliveredB)
+		if payload, ok := m.message.Payload.(WSInvalidate); !ok || payload.Resource != wsResourceCategories || m.students != nil {
+			t.Fatalf("relayed %+v to %v", m.message.Payload, m.students)
+		}
+	})
+
+	t.Run("too large logged", func(t *testing.T) {
+		text := strings.Repeat("x", wsClusterMaxPayload)
+		if err := NewEventLog(db.New(pool), hubA, 100).Publish(ctx, []int64{42}, WSNotify{Text: text}); err != nil {
+			t.Fatal(err)
+		}
+		receive(t, deliveredA)
+		m := receive(t, deliveredB)
+		if payload, ok := m.message.Payload.(WSNotify); !ok || payload.Text != text || m.message.ID == 0 || len(m.students) != 1 {
+			t.Fatalf("relayed %+v to %v", m.message.Payload, m.students)
+		}
+	})
+
+	// Neither instance delivers its own events a second time.
+	select {
+	case m := <-deliveredA:
+		t.Fatalf("instance delivered its own %s event again", m.message.Type())
+	case <-time.After(200 * time.Millisecond):
+	}
+}
@@ -1,46 +1,120 @@
 package main
 
 import (
+	"context"
 	"log/slog"
-	"net/http"
-	"strconv"
+	"sync"
+	"time"
+
+	"git.sr.ht/~runxiyu/cca/db"
 )
 
-func (app *App) broadcastCourseCounts(r *http.Request, courseIDs []string) {
-	if len(courseIDs) == 0 {
-		return
+// CourseCountBatcher collects the courses whose enrollment changed and
+// broadcasts their counts at most once per interval, so that a burst of
+// selection changes costs one query and one message rather than one of each
+// per change.
+type CourseCountBatcher struct {
+	interval time.Duration
+	queries  *db.Queries
+	hub      *WebSocketHub
+
+	mu    sync.Mutex
+	dirty map[string]struct{}
+
+	batchesTotal int64
+	coursesTotal int64
+	lastBatch    int
+}
+
+type CourseCountBatcherStats struct {
+	Interval     time.Duration
+	Pending      int
+	BatchesTotal int64
+	CoursesTotal int64
+	LastBatch    int
+}
+
+func NewCourseCountBatcher(interval time.Duration, queries *db.Queries, hub *WebSocketHub) *CourseCountBatcher {
+	return &CourseCountBatcher{
+		interval: interval,
+		queries:  queries,
+		hub:      hub,
+		dirty:    make(map[string]struct{}),
 	}
+}
 
-	dedup := make([]string, 0, len(courseIDs))
-	seen := make(map[string]struct{}, len(courseIDs))
+// Mark records that the counts of the given courses may have changed.
+func (b *CourseCountBatcher) Mark(courseIDs []string) {
+	b.mu.Lock()
 	for _, id := range courseIDs {
-		if id == "" {
-			continue
+		if id != "" {
+			b.dirty[id] = struct{}{}
 		}
-		if _, ok := seen[id]; ok {
-			continue
+	}
+	b.mu.Unlock()
+}
+
+func (b *CourseCountBatcher) Run(ctx context.Context) {
+	ticker := time.NewTicker(b.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			b.flush(ctx)
 		}
-		seen[id] = struct{}{}
-		dedup = append(dedup, id)
 	}
+}
 
-	if len(dedup) == 0 {
+func (b *CourseCountBatcher) flush(ctx context.Context) {
+	b.mu.Lock()
+	if len(b.dirty) == 0 {
+		b.mu.Unlock()
 		return
 	}
+	ids := make([]string, 0, len(b.dirty))
+	for id := range b.dirty {
+		ids = append(ids, id)
+	}
+	b.dirty = make(map[string]struct{})
+	b.mu.Unlock()
 
-	rows, err := app.queries.GetCourseCountsByIDs(r.Context(), dedup)
+	rows, err := b.queries.GetCourseCountsByIDs(ctx, ids)
 	if err != nil {
-		app.logError(r, logMsgAdminCoursesCountsError, slog.Any("error", err))
+		slog.Error(logMsgAdminCoursesCountsError, slog.Any("error", err))
+		// Try again on the next tick.
+		b.Mark(ids)
 		return
 	}
 
-	counts := make(map[string]int64, len(dedup))
+	counts := make(map[string]int64, len(ids))
 	for _, row := range rows {
 		counts[row.ID] = row.CurrentStudents
 	}
 
-	for _, id := range dedup {
-		count := counts[id]
-		app.wsHub.Broadcast(WSMessage("course_count_update," + id + "," + strconv.FormatInt(count, 10)))
+	payload := WSCourseCounts{Counts: make([]WSCourseCount, 0, len(ids))}
+	for _, id := range ids {
+		payload.Counts = append(payload.Counts, WSCourseCount{CourseID: id, CurrentStudents: counts[id]})
+	}
+	b.hub.Broadcast(newWSMessage(payload))
+
+	b.mu.Lock()
+	b.batchesTotal++
+	b.coursesTotal += int64(len(ids))
+	b.lastBatch = len(ids)
+	b.mu.Unlock()
+}
+
+func (b *CourseCountBatcher) Stats() CourseCountBatcherStats {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return CourseCountBatcherStats{
+		Interval:     b.interval,
+		Pending:      len(b.dirty),
+		BatchesTotal: b.batchesTotal,
+		CoursesTotal: b.coursesTotal,
+		LastBatch:    b.lastBatch,
 	}
 }
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+
+	"git.sr.ht/~runxiyu/cca/db"
+)
+
+// EventLog persists events that clients must not miss, such as
+// notifications, before sending them. A client that reconnects passes the
+// ID of the last logged event it saw and is sent whatever it missed.
+type EventLog struct {
+	queries *db.Queries
+	hub     *WebSocketHub
+	keep    int32
+}
+
+func NewEventLog(queries *db.Queries, hub *WebSocketHub, keep int32) *EventLog {
+	return &EventLog{
+		queries: queries,
+		hub:     hub,
+		keep:    keep,
+	}
+}
+
+// Publish logs an event and sends it to the given students, or to every
+// student if studentIDs is nil.
+func (l *EventLog) Publish(ctx context.Context, studentIDs []int64, payload WSPayload) error {
+	message := newWSMessage(payload)
+	row, err := l.queries.AppendEvent(ctx, db.AppendEventParams{
+		Type:       message.Type(),
+		Payload:    message.payloadJSON,
+		StudentIds: studentIDs,
+	})
+	if err != nil {
+		return fmt.Errorf("append event: %w", err)
+	}
+	message.ID = row.ID
+	message.Time = row.CreatedAt.Time
+
+	if studentIDs == nil {
+		l.hub.Broadcast(message)
+	} else {
+		l.hub.BroadcastToStudents(studentIDs, message)
+	}
+
+	if err := l.queries.PruneEvents(ctx, l.keep); err != nil {
+		slog.Error(logMsgEventLogPruneError, slog.Any("error", err))
+	}
+	return nil
+}
+
+// replay returns the ID of the newest logged event and the messages a
+// student missed after the event since; a negative since means the student
+// is connecting for the first time. If the log no longer reaches back that
+// far, or does not know the event at all, the student is told to
+// resynchronize instead.
+func (l *EventLog) replay(ctx context.Context, studentID int64, since int64) (int64, []WSMessage, error) {
+	bounds, err := l.queries.GetEventLogBounds(ctx)
+	if err != nil {
+		return 0, nil, fmt.Errorf("fetch event log bounds: %w", err)
+	}
+	if since < 0 || since == bounds.Newest {
+		return bounds.Newest, nil, nil
+	}
+	if since > bounds.Newest || since < bounds.Oldest-1 {
+		return bounds.Newest, []WSMessage{newWSMessage(WSResync{})}, nil
+	}
+
+	rows, err := l.queries.GetEventsSince(ctx, db.GetEventsSinceParams{
+		ID:        since,
+		StudentID: studentID,
+	})
+	if err != nil {
+		return 0, nil, fmt.Errorf("fetch events: %w", err)
+	}
+
+	messages := make([]WSMessage, 0, len(rows))
+	for _, row := range rows {
+		decode, ok := wsPayloadDecoders[row.Type]
+		if !ok {
+			return 0, nil, fmt.Errorf("unknown event type %q", row.Type)
+		}
+		payload, err := decode(row.Payload)
+		if err != nil {
+			return 0, nil, fmt.Errorf("decode event %d: %w", row.ID, err)
+		}
+		messages = append(messages, WSMessage{
+			Payload:     payload,
+			Time:        row.CreatedAt.Time,
+			ID:          row.ID,
+			payloadJSON: row.Payload,
+		})
+	}
+	return bounds.Newest, messages, nil
+}
@@ -0,0 +1,265 @@
+package main
+
+import (
+	"encoding/json"
+	"log/slog"
+	"strconv"
+	"strings"
+	"time"
+
+	"git.sr.ht/~runxiyu/cca/db"
+)
+
+// Clients that request this WebSocket subprotocol receive JSON envelopes as
+// described in ws_schema.json. Clients that request no subprotocol receive
+// the legacy comma-separated text messages, so that SPAs loaded before a
+// deployment keep working until they are reloaded.
+const (
+	wsSubprotocolV1 = "cca.v1"
+	wsProtocolV1    = 1
+)
+
+// WSPayload is implemented by every event that may be sent over the hub.
+type WSPayload interface {
+	wsType() string
+	// legacyText returns the message for clients without a negotiated
+	// subprotocol, or an empty string if they should not receive it.
+	legacyText() string
+}
+
+// wsStatePayload is implemented by events that carry the current state of
+// something. When a client falls behind, such an event replaces an unsent
+// older event of the same type instead of being dropped.
+type wsStatePayload interface {
+	WSPayload
+	supersede(older WSPayload) WSPayload
+}
+
+type WSMessage struct {
+	Payload WSPayload
+	Time    time.Time
+	// ID is the event's position in the event log, or zero for events that
+	// are not logged.
+	ID int64
+	// payloadJSON is encoded once, as the same message is usually written
+	// to many connections.
+	payloadJSON json.RawMessage
+}
+
+func newWSMessage(payload WSPayload) WSMessage {
+	payloadJSON, err := json.Marshal(payload)
+	if err != nil {
+		slog.Error(logMsgWebsocketEncodeError, slog.String("type", payload.wsType()), slog.Any("error", err))
+		payloadJSON = json.RawMessage("{}")
+	}
+	return WSMessage{
+		Payload:     payload,
+		Time:        time.Now(),
+		payloadJSON: payloadJSON,
+	}
+}
+
+func (m WSMessage) Type() string {
+	return m.Payload.wsType()
+}
+
+type wsEnvelope struct {
+	Version   int             `json:"v"`
+	Type      string          `json:"type"`
+	Payload   json.RawMessage `json:"payload"`
+	Timestamp time.Time       `json:"ts"`
+	Seq       uint64          `json:"seq"`
+	ID        int64           `json:"id,omitempty"`
+}
+
+func (m WSMessage) encode(protocol string, seq uint64) ([]byte, error) {
+	if protocol != wsSubprotocolV1 {
+		return []byte(m.Payload.legacyText()), nil
+	}
+	return json.Marshal(wsEnvelope{
+		Version:   wsProtocolV1,
+		Type:      m.Type(),
+		Payload:   m.payloadJSON,
+		Timestamp: m.Time,
+		Seq:       seq,
+		ID:        m.ID,
+	})
+}
+
+type WSHello struct {
+	// LastEventID is the newest event in the event log, from which a client
+	// connecting for the first time counts missed events.
+	LastEventID int64 `json:"last_event_id"`
+}
+
+func (WSHello) wsType() string     { return "hello" }
+func (WSHello) legacyText() string { return "hello" }
+
+type WSNotify struct {
+	// NotificationID identifies the notification in the student's inbox.
+	NotificationID int64  `json:"notification_id,omitempty"`
+	Text           string `json:"text"`
+}
+
+func (WSNotify) wsType() string       { return "notify" }
+func (p WSNotify) legacyText() string { return "notify," + p.Text }
+
+type WSCourseCount struct {
+	CourseID        string `json:"course_id"`
+	CurrentStudents int64  `json:"current_students"`
+}
+
+type WSCourseCounts struct {
+	Counts []WSCourseCount `json:"counts"`
+}
+
+func (WSCourseCounts) wsType() string { return "course_counts" }
+func (p WSCourseCounts) legacyText() string {
+	var b strings.Builder
+	b.WriteString("course_count_update")
+	for _, c := range p.Counts {
+		b.WriteString("," + c.CourseID + "," + strconv.FormatInt(c.CurrentStudents, 10))
+	}
+	return b.String()
+}
+
+// supersede merges older counts that have not been sent yet, preferring the
+// newer count for courses that appear in both.
+func (p WSCourseCounts) supersede(older WSPayload) WSPayload {
+	o, ok := older.(WSCourseCounts)
+	if !ok {
+		return p
+	}
+	merged := WSCourseCounts{Counts: make([]WSCourseCount, 0, len(o.Counts)+len(p.Counts))}
+	seen := make(map[string]struct{}, len(p.Counts))
+	for _, c := range p.Counts {
+		seen[c.CourseID] = struct{}{}
+	}
+	for _, c := range o.Counts {
+		if _, ok := seen[c.CourseID]; !ok {
+			merged.Counts = append(merged.Counts, c)
+		}
+	}
+	merged.Counts = append(merged.Counts, p.Counts...)
+	return merged
+}
+
+const (
+	wsResourceSelections = "selections"
+	wsResourceCourses    = "courses"
+	wsResourceGrades     = "grades"
+	wsResourceCategories = "categories"
+	wsResourcePeriods    = "periods"
+)
+
+type WSInvalidate struct {
+	Resource string `json:"resource"`
+}
+
+func (WSInvalidate) wsType() string       { return "invalidate" }
+func (p WSInvalidate) legacyText() string { return "invalidate_" + p.Resource }
+
+// The following events carry the new state of a resource so that clients can
+// patch their local copy instead of refetching everything. Legacy clients are
+// told to refetch instead.
+
+type WSSelections struct {
+	Selections []db.GetSelectionsByStudentRow `json:"selections"`
+}
+
+func (WSSelections) wsType() string                  { return "selections" }
+func (WSSelections) legacyText() string              { return "invalidate_selections" }
+func (p WSSelections) supersede(WSPayload) WSPayload { return p }
+
+type WSCourses struct {
+	Courses []db.GetCoursesRow `json:"courses"`
+}
+
+func (WSCourses) wsType() string                  { return "courses" }
+func (WSCourses) legacyText() string              { return "invalidate_courses" }
+func (p WSCourses) supersede(WSPayload) WSPayload { return p }
+
+type WSGrades struct {
+	Grades []AbsGradesRow `json:"grades"`
+}
+
+func (WSGrades) wsType() string                  { return "grades" }
+func (WSGrades) legacyText() string              { return "invalidate_grades" }
+func (p WSGrades) supersede(WSPayload) WSPayload { return p }
+
+type WSCategories struct {
+	Categories []string `json:"categories"`
+}
+
+func (WSCategories) wsType() string                  { return "categories" }
+func (WSCategories) legacyText() string              { return "invalidate_categories" }
+func (p WSCategories) supersede(WSPayload) WSPayload { return p }
+
+type WSPeriods struct {
+	Periods []string `json:"periods"`
+}
+
+func (WSPeriods) wsType() string                  { return "periods" }
+func (WSPeriods) legacyText() string              { return "invalidate_periods" }
+func (p WSPeriods) supersede(WSPayload) WSPayload { return p }
+
+// WSResync tells clients that they may have missed events and should fetch
+// a fresh snapshot.
+type WSResync struct{}
+
+func (WSResync) wsType() string     { return "resync" }
+func (WSResync) legacyText() string { return "invalidate_selections" }
+
+// wsResourceOf returns the resource described by a state event type.
+func wsResourceOf(wsType string) (string, bool) {
+	switch wsType {
+	case WSSelections{}.wsType():
+		return wsResourceSelections, true
+	case WSCourses{}.wsType(), WSCourseCounts{}.wsType():
+		return wsResourceCourses, true
+	case WSGrades{}.wsType():
+		return wsResourceGrades, true
+	case WSCategories{}.wsType():
+		return wsResourceCategories, true
+	case WSPeriods{}.wsType():
+		return wsResourcePeriods, true
+	}
+	return "", false
+}
+
+func decodeWSPayload[T WSPayload](data []byte) (WSPayload, error) {
+	var payload T
+	err := json.Unmarshal(data, &payload)
+	return payload, err
+}
+
+// wsPayloadDecoders is used to reconstruct events received from other
+// instances.
+var wsPayloadDecoders = map[string]func([]byte) (WSPayload, error){
+	WSHello{}.wsType():         decodeWSPayload[WSHello],
+	WSNotify{}.wsType():        decodeWSPayload[WSNotify],
+	WSCourseCounts{}.wsType():  decodeWSPayload[WSCourseCounts],
+	WSInvalidate{}.wsType():    decodeWSPayload[WSInvalidate],
+	WSSelections{}.wsType():    decodeWSPayload[WSSelections],
+	WSCourses{}.wsType():       decodeWSPayload[WSCourses],
+	WSGrades{}.wsType():        decodeWSPayload[WSGrades],
+	WSCategories{}.wsType():    decodeWSPayload[WSCategories],
+	WSPeriods{}.wsType():       decodeWSPayload[WSPeriods],
+	WSResync{}.wsType():        decodeWSPayload[WSResync],
+	WSQueuePosition{}.wsType(): decodeWSPayload[WSQueuePosition],
+	WSQueueAdmitted{}.wsType(): decodeWSPayload[WSQueueAdmitted],
+}
+
+type WSQueuePosition struct {
+	Position int `json:"position"`
+}
+
+func (WSQueuePosition) wsType() string { return "queue_position" }
+func (p WSQueuePosition) legacyText() string {
+	return "queue_position," + strconv.Itoa(p.Position)
+}
+
+type WSQueueAdmitted struct{}
+
+func (WSQueueAdmitted) wsType() string     { return "queue_admitted" }
+func (WSQueueAdmitted) legacyText() string { return "queue_admitted" }
@@ -0,0 +1,145 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/coder/websocket"
+)
+
+// clientTransport is how the hub's events reach a client. Students normally
+// connect with a WebSocket; sseTransport serves networks that break
+// WebSocket upgrades.
+type clientTransport interface {
+	write(ctx context.Context, message WSMessage, data []byte) error
+	ping(ctx context.Context) error
+	// wait blocks until the client goes away or the transport is closed.
+	wait() error
+	close(status websocket.StatusCode, reason string)
+	closeNow()
+}
+
+type wsTransport struct {
+	conn *websocket.Conn
+}
+
+func (t wsTransport) write(ctx context.Context, _ WSMessage, data []byte) error {
+	return t.conn.Write(ctx, websocket.MessageText, data)
+}
+
+func (t wsTransport) ping(ctx context.Context) error {
+	return t.conn.Ping(ctx)
+}
+
+func (t wsTransport) wait() error {
+	// Clients send nothing, but reading is what processes pongs and close
+	// frames.
+	for {
+		if _, _, err := t.conn.Read(context.Background()); err != nil {
+			status := websocket.CloseStatus(err)
+			if status == websocket.StatusNormalClosure || status == websocket.StatusGoingAway {
+				return nil
+			}
+			return err
+		}
+	}
+}
+
+func (t wsTransport) close(status websocket.StatusCode, reason string) {
+	_ = t.conn.Close(status, reason)
+}
+
+func (t wsTransport) closeNow() {
+	_ = t.conn.CloseNow()
+}
+
+var errSSEClosed = errors.New("event stream closed")
+
+// sseTransport writes events as a text/event-stream response. The response
+// writer may only be used while the handler is running, so the handler runs
+// wait and every write checks that the stream is still open.
+type sseTransport struct {
+	w   http.ResponseWriter
+	rc  *http.ResponseController
+	ctx context.Context
+
+	mu       sync.Mutex
+	closed   bool
+	closedCh chan struct{}
+}
+
+func newSSETransport(w http.ResponseWriter, r *http.Request) *sseTransport {
+	return &sseTransport{
+		w:        w,
+		rc:       http.NewResponseController(w),
+		ctx:      r.Context(),
+		closedCh: make(chan struct{}),
+	}
+}
+
+func (t *sseTransport) write(ctx context.Context, message WSMessage, data []byte) error {
+	var frame []byte
+	// Browsers send the last id back in Last-Event-ID when they reconnect,
+	// so only logged events carry one.
+	if message.ID != 0 {
+		frame = append(frame, "id: "+strconv.FormatInt(message.ID, 10)+"\n"...)
+	}
+	frame = append(frame, "data: "...)
+	frame = append(frame, data...)
+	frame = append(frame, "\n\n"...)
+	return t.send(ctx, frame)
+}
+
+func (t *sseTransport) ping(ctx context.Context) error {
+	// Comments are ignored by EventSource; writing one is enough to notice
+	// a dead connection.
+	return t.send(ctx, []byte(": ping\n\n"))
+}
+
+func (t *sseTransport) send(ctx context.Context, frame []byte) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.closed {
+		return errSSEClosed
+	}
+
+	deadline, ok := ctx.Deadline()
+	if !ok {
+		deadline = time.Time{}
+	}
+	if err := t.rc.SetWriteDeadline(deadline); err != nil && !errors.Is(err, http.ErrNotSupported) {
+		return err
+	}
+	if _, err := t.w.Write(frame); err != nil {
+		return err
+	}
+	return t.rc.Flush()
+}
+
+func (t *sseTransport) wait() error {
+	select {
+	case <-t.ctx.Done():
+	case <-t.closedCh:
+	}
+	t.closeNow()
+	return nil
+}
+
+// close ends the stream. The status and reason have no equivalent in SSE;
+// EventSource reconnects by itself.
+func (t *sseTransport) close(websocket.StatusCode, string) {
+	t.closeNow()
+}
+
+func (t *sseTransport) closeNow() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if !t.closed {
+		t.closed = true
+		close(t.closedCh)
+	}
+}